@@ -0,0 +1,382 @@
+package yarn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AdvisoryBindingType is the type of the service binding that supplies OSV
+// advisories to check the resolved Yarn against.
+const AdvisoryBindingType = "osv-advisories"
+
+// AdvisoryPolicy controls what happens when the resolved Yarn is affected by
+// a known advisory.
+type AdvisoryPolicy string
+
+const (
+	AdvisoryPolicyOff  AdvisoryPolicy = "off"
+	AdvisoryPolicyWarn AdvisoryPolicy = "warn"
+	AdvisoryPolicyFail AdvisoryPolicy = "fail"
+)
+
+// advisoryPackages lists the npm packages that Yarn of each dependency ID is
+// published as, and therefore the packages advisories are recorded against.
+var advisoryPackages = map[string][]string{
+	YarnDependency:  {"yarn"},
+	BerryDependency: {"@yarnpkg/cli-dist", "@yarnpkg/cli"},
+}
+
+// Advisory is the subset of the OSV schema that the gate evaluates.
+type Advisory struct {
+	ID        string             `json:"id"`
+	Aliases   []string           `json:"aliases"`
+	Summary   string             `json:"summary"`
+	Withdrawn string             `json:"withdrawn"`
+	Affected  []AdvisoryAffected `json:"affected"`
+}
+
+type AdvisoryAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+		PURL      string `json:"purl"`
+	} `json:"package"`
+	Ranges []struct {
+		Type   string `json:"type"`
+		Events []struct {
+			Introduced   string `json:"introduced"`
+			Fixed        string `json:"fixed"`
+			LastAffected string `json:"last_affected"`
+		} `json:"events"`
+	} `json:"ranges"`
+	Versions []string `json:"versions"`
+}
+
+// advisoryGate checks resolved dependencies against a local advisory
+// database. It never uses the network.
+type advisoryGate struct {
+	Policy     AdvisoryPolicy
+	Advisories []Advisory
+	Allowlist  []string
+}
+
+// advisoryGateFromEnv reads the gate configuration. BP_YARN_ADVISORY_POLICY
+// turns the gate on with the warn or fail policy; the advisories are read
+// from the OSV file or directory in BP_YARN_ADVISORY_DB and from any
+// osv-advisories bindings, and BP_YARN_ADVISORY_ALLOWLIST lists advisory IDs
+// or aliases to ignore.
+func advisoryGateFromEnv(platformPath string) (advisoryGate, error) {
+	gate := advisoryGate{Policy: AdvisoryPolicyOff}
+
+	if value, ok := os.LookupEnv("BP_YARN_ADVISORY_POLICY"); ok && value != "" {
+		gate.Policy = AdvisoryPolicy(value)
+		switch gate.Policy {
+		case AdvisoryPolicyOff, AdvisoryPolicyWarn, AdvisoryPolicyFail:
+		default:
+			return advisoryGate{}, fmt.Errorf("failed to parse BP_YARN_ADVISORY_POLICY value %s: must be one of fail, warn or off", value)
+		}
+	}
+
+	if gate.Policy == AdvisoryPolicyOff {
+		return gate, nil
+	}
+
+	for _, id := range strings.Split(os.Getenv("BP_YARN_ADVISORY_ALLOWLIST"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			gate.Allowlist = append(gate.Allowlist, id)
+		}
+	}
+
+	var sources int
+	if path := os.Getenv("BP_YARN_ADVISORY_DB"); path != "" {
+		advisories, err := readAdvisories(path)
+		if err != nil {
+			return advisoryGate{}, err
+		}
+		gate.Advisories = append(gate.Advisories, advisories...)
+		sources++
+	}
+
+	bindings, err := servicebindings.NewResolver().Resolve(AdvisoryBindingType, "", platformPath)
+	if err != nil {
+		return advisoryGate{}, fmt.Errorf("failed to resolve %s bindings: %w", AdvisoryBindingType, err)
+	}
+
+	for _, binding := range bindings {
+		advisories, err := readBindingAdvisories(binding)
+		if err != nil {
+			return advisoryGate{}, err
+		}
+		gate.Advisories = append(gate.Advisories, advisories...)
+		sources++
+	}
+
+	if sources == 0 {
+		return advisoryGate{}, fmt.Errorf("BP_YARN_ADVISORY_POLICY is %s but no advisory database was given: set BP_YARN_ADVISORY_DB or add an %s binding", gate.Policy, AdvisoryBindingType)
+	}
+
+	return gate, nil
+}
+
+// readAdvisories reads the advisories in an OSV file, or in every .json file
+// below an OSV directory.
+func readAdvisories(path string) ([]Advisory, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read advisory database: %w", err)
+	}
+
+	if !info.IsDir() {
+		return readAdvisoryFile(path)
+	}
+
+	var advisories []Advisory
+	err = filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		found, err := readAdvisoryFile(path)
+		if err != nil {
+			return err
+		}
+		advisories = append(advisories, found...)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read advisory database: %w", err)
+	}
+
+	return advisories, nil
+}
+
+func readBindingAdvisories(binding servicebindings.Binding) ([]Advisory, error) {
+	var names []string
+	for name := range binding.Entries {
+		if filepath.Ext(name) == ".json" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var advisories []Advisory
+	for _, name := range names {
+		content, err := binding.Entries[name].ReadBytes()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read advisories from binding %s: %w", binding.Name, err)
+		}
+
+		found, err := parseAdvisories(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse advisories in binding %s entry %s: %w", binding.Name, name, err)
+		}
+		advisories = append(advisories, found...)
+	}
+
+	return advisories, nil
+}
+
+func readAdvisoryFile(path string) ([]Advisory, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read advisory database: %w", err)
+	}
+
+	advisories, err := parseAdvisories(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse advisories in %s: %w", path, err)
+	}
+
+	return advisories, nil
+}
+
+// parseAdvisories parses a single OSV advisory or a list of them.
+func parseAdvisories(content []byte) ([]Advisory, error) {
+	var advisories []Advisory
+	if err := json.Unmarshal(content, &advisories); err == nil {
+		return advisories, nil
+	}
+
+	var advisory Advisory
+	err := json.Unmarshal(content, &advisory)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Advisory{advisory}, nil
+}
+
+// check logs the advisories that affect the dependency and, under the fail
+// policy, returns an error when any of them is not allowlisted.
+func (g advisoryGate) check(dependency postal.Dependency, logger scribe.Emitter, report *BuildReport, tracer phaseTracer) (err error) {
+	if g.Policy == AdvisoryPolicyOff {
+		return nil
+	}
+
+	_, span := tracer.start("yarn.advisories", append(dependencyAttributes(dependency), attribute.String("yarn.advisories.policy", string(g.Policy)))...)
+	defer func() { endSpan(span, err) }()
+
+	logger.Subprocess("Checking advisories for %s %s", dependency.ID, dependency.Version)
+
+	version, err := semver.NewVersion(dependency.Version)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s version %q: %w", dependency.ID, dependency.Version, err)
+	}
+
+	var blocking []string
+	for _, advisory := range g.Advisories {
+		if advisory.Withdrawn != "" || !advisory.affects(dependency, version) {
+			continue
+		}
+
+		if g.allows(advisory) {
+			logger.Action("%s (allowed): %s", advisory.ID, advisory.Summary)
+			continue
+		}
+
+		logger.Action("%s: %s", advisory.ID, advisory.Summary)
+		blocking = append(blocking, advisory.ID)
+	}
+
+	if len(blocking) == 0 {
+		logger.Action("No advisories found")
+		logger.Break()
+		return nil
+	}
+	logger.Break()
+
+	if g.Policy == AdvisoryPolicyWarn {
+		report.Warn("%s %s is affected by advisories %s", dependency.ID, dependency.Version, strings.Join(blocking, ", "))
+		return nil
+	}
+
+	return fmt.Errorf("%s %s is affected by advisories %s: use a fixed version, allow them with BP_YARN_ADVISORY_ALLOWLIST or set BP_YARN_ADVISORY_POLICY=warn", dependency.ID, dependency.Version, strings.Join(blocking, ", "))
+}
+
+func (g advisoryGate) allows(advisory Advisory) bool {
+	if slices.Contains(g.Allowlist, advisory.ID) {
+		return true
+	}
+
+	for _, alias := range advisory.Aliases {
+		if slices.Contains(g.Allowlist, alias) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a Advisory) affects(dependency postal.Dependency, version *semver.Version) bool {
+	for _, affected := range a.Affected {
+		if !affected.matches(dependency) {
+			continue
+		}
+
+		if slices.Contains(affected.Versions, dependency.Version) {
+			return true
+		}
+
+		for _, r := range affected.Ranges {
+			if r.Type != "SEMVER" && r.Type != "ECOSYSTEM" {
+				continue
+			}
+
+			// The fields of each event are taken in a fixed order, so that
+			// events sharing a version keep the same order once sorted.
+			var events []advisoryEvent
+			for _, event := range r.Events {
+				for _, field := range []struct{ kind, value string }{
+					{"introduced", event.Introduced},
+					{"fixed", event.Fixed},
+					{"last_affected", event.LastAffected},
+				} {
+					kind, value := field.kind, field.value
+					if value == "" {
+						continue
+					}
+
+					if value == "0" {
+						value = "0.0.0"
+					}
+
+					v, err := semver.NewVersion(value)
+					if err != nil {
+						continue
+					}
+					events = append(events, advisoryEvent{kind: kind, version: v})
+				}
+			}
+
+			if inRange(events, version) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matches reports whether the affected package is the dependency, either by
+// PURL or by the npm package Yarn is published as.
+func (a AdvisoryAffected) matches(dependency postal.Dependency) bool {
+	if a.Package.PURL != "" && dependency.PURL != "" {
+		purl, _, _ := strings.Cut(dependency.PURL, "@")
+		if strings.TrimSuffix(a.Package.PURL, "@") == purl {
+			return true
+		}
+	}
+
+	return a.Package.Ecosystem == "npm" && slices.Contains(advisoryPackages[dependency.ID], a.Package.Name)
+}
+
+type advisoryEvent struct {
+	kind    string
+	version *semver.Version
+}
+
+// inRange evaluates the events of an OSV range in version order: the version
+// is affected from an introduced event until a fixed event, or beyond a
+// last_affected event.
+func inRange(events []advisoryEvent, version *semver.Version) bool {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].version.LessThan(events[j].version)
+	})
+
+	var affected bool
+	for _, event := range events {
+		switch event.kind {
+		case "introduced":
+			if !version.LessThan(event.version) {
+				affected = true
+			}
+		case "fixed":
+			if !version.LessThan(event.version) {
+				affected = false
+			}
+		case "last_affected":
+			if version.GreaterThan(event.version) {
+				affected = false
+			}
+		}
+	}
+
+	return affected
+}
@@ -0,0 +1,400 @@
+package yarn
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/chronos"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/vacation"
+)
+
+const (
+	defaultArtifactCacheMaxSizeMB = 256
+	artifactCacheIndex            = "index.json"
+)
+
+// ArtifactCache keeps archives of recently delivered Yarn dependencies in a
+// cache-only layer, keyed by dependency checksum, so that switching back to a
+// previously used version does not require another download. It wraps the
+// deliverer the build uses and archives what that deliverer extracted, so
+// that a cached install matches a delivered one. Entries are evicted
+// least-recently-used first once either the entry limit or the size cap is
+// exceeded.
+type ArtifactCache struct {
+	layer      packit.Layer
+	deliverer  Deliverer
+	maxEntries int
+	maxSize    int64
+	clock      chronos.Clock
+	entries    []artifactCacheEntry
+}
+
+type artifactCacheEntry struct {
+	Checksum string `json:"checksum"`
+	Digest   string `json:"digest"`
+	LastUsed int64  `json:"last-used"`
+	Size     int64  `json:"size"`
+}
+
+// NewArtifactCache returns an ArtifactCache stored in the given layer that
+// delivers missing dependencies through deliverer. The layer is marked as
+// cache-only and any existing index is loaded from it.
+func NewArtifactCache(layer packit.Layer, deliverer Deliverer, maxEntries int, maxSize int64, clock chronos.Clock) (ArtifactCache, error) {
+	layer.Launch, layer.Build, layer.Cache = false, false, true
+
+	cache := ArtifactCache{
+		layer:      layer,
+		deliverer:  deliverer,
+		maxEntries: maxEntries,
+		maxSize:    maxSize,
+		clock:      clock,
+	}
+
+	err := os.MkdirAll(layer.Path, os.ModePerm)
+	if err != nil {
+		return ArtifactCache{}, fmt.Errorf("failed to create artifact cache layer: %w", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(layer.Path, artifactCacheIndex))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cache, nil
+		}
+		return ArtifactCache{}, fmt.Errorf("failed to read artifact cache index: %w", err)
+	}
+
+	err = json.Unmarshal(content, &cache.entries)
+	if err != nil {
+		return ArtifactCache{}, fmt.Errorf("failed to parse artifact cache index: %w", err)
+	}
+
+	return cache, nil
+}
+
+// Layer returns the layer backing the cache.
+func (c ArtifactCache) Layer() packit.Layer {
+	return c.layer
+}
+
+// Has reports whether the archive with the given checksum is in the cache.
+func (c ArtifactCache) Has(checksum string) bool {
+	return c.find(checksum) >= 0
+}
+
+// Touch marks the archive with the given checksum as used, so that it is not
+// evicted while a layer built from it is still being reused. It does
+// nothing when the archive is not in the cache.
+func (c *ArtifactCache) Touch(checksum string) error {
+	i := c.find(checksum)
+	if i < 0 {
+		return nil
+	}
+
+	c.entries[i].LastUsed = c.clock.Now().UnixNano()
+
+	return c.writeIndex()
+}
+
+// Deliver extracts the dependency into layerPath and reports whether the
+// cache served it. A cached archive is extracted once its digest has been
+// verified; any other dependency, including one whose cached archive turns
+// out to be damaged, is delivered through the wrapped deliverer and archived
+// into the cache afterwards.
+func (c *ArtifactCache) Deliver(dependency postal.Dependency, cnbPath, layerPath, platformPath string) (bool, error) {
+	checksum := dependencyChecksum(dependency)
+
+	if i := c.find(checksum); i >= 0 {
+		err := c.restore(c.entries[i], layerPath)
+		if err == nil {
+			return true, c.Touch(checksum)
+		}
+
+		// A damaged entry is dropped and the dependency delivered again.
+		err = c.remove(checksum)
+		if err != nil {
+			return false, err
+		}
+
+		err = resetDir(layerPath)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err := c.deliverer.Deliver(dependency, cnbPath, layerPath, platformPath)
+	if err != nil {
+		return false, err
+	}
+
+	entry, err := c.store(checksum, layerPath)
+	if err != nil {
+		return false, err
+	}
+
+	c.entries = append(c.entries, entry)
+
+	err = c.evict()
+	if err != nil {
+		return false, err
+	}
+
+	return false, c.writeIndex()
+}
+
+// restore extracts the archive of the entry into layerPath, unless its
+// contents no longer match the digest recorded when it was stored.
+func (c ArtifactCache) restore(entry artifactCacheEntry, layerPath string) error {
+	content, err := os.ReadFile(c.entryPath(entry.Checksum))
+	if err != nil {
+		return fmt.Errorf("failed to read %s from artifact cache: %w", entry.Checksum, err)
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	if digest != entry.Digest {
+		return fmt.Errorf("archive of %s in artifact cache has digest %s, expected %s", entry.Checksum, digest, entry.Digest)
+	}
+
+	err = vacation.NewArchive(bytes.NewReader(content)).Decompress(layerPath)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s from artifact cache: %w", entry.Checksum, err)
+	}
+
+	return nil
+}
+
+// store archives the contents of layerPath as the entry of the given
+// checksum.
+func (c ArtifactCache) store(checksum, layerPath string) (artifactCacheEntry, error) {
+	path := c.entryPath(checksum)
+	partial := path + ".partial"
+
+	file, err := os.Create(partial)
+	if err != nil {
+		return artifactCacheEntry{}, fmt.Errorf("failed to create artifact cache entry: %w", err)
+	}
+
+	hash := sha256.New()
+	err = archiveDir(layerPath, io.MultiWriter(file, hash))
+	err = errors.Join(err, file.Close())
+	if err != nil {
+		_ = os.Remove(partial)
+		return artifactCacheEntry{}, fmt.Errorf("failed to store %s in artifact cache: %w", checksum, err)
+	}
+
+	err = os.Rename(partial, path)
+	if err != nil {
+		return artifactCacheEntry{}, fmt.Errorf("failed to store %s in artifact cache: %w", checksum, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return artifactCacheEntry{}, fmt.Errorf("failed to store %s in artifact cache: %w", checksum, err)
+	}
+
+	return artifactCacheEntry{
+		Checksum: checksum,
+		Digest:   fmt.Sprintf("sha256:%x", hash.Sum(nil)),
+		LastUsed: c.clock.Now().UnixNano(),
+		Size:     info.Size(),
+	}, nil
+}
+
+func (c ArtifactCache) find(checksum string) int {
+	for i, entry := range c.entries {
+		if entry.Checksum == checksum {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (c *ArtifactCache) remove(checksum string) error {
+	err := os.Remove(c.entryPath(checksum))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to evict %s from artifact cache: %w", checksum, err)
+	}
+
+	if i := c.find(checksum); i >= 0 {
+		c.entries = append(c.entries[:i], c.entries[i+1:]...)
+	}
+
+	return c.writeIndex()
+}
+
+func (c *ArtifactCache) evict() error {
+	sort.SliceStable(c.entries, func(i, j int) bool {
+		return c.entries[i].LastUsed > c.entries[j].LastUsed
+	})
+
+	var total int64
+	for i, entry := range c.entries {
+		total += entry.Size
+
+		// The most recently used entry is always kept, even when it alone
+		// exceeds the size cap, so that the current install is not lost.
+		if i == 0 {
+			continue
+		}
+
+		if i >= c.maxEntries || (c.maxSize > 0 && total > c.maxSize) {
+			for _, evicted := range c.entries[i:] {
+				err := os.Remove(c.entryPath(evicted.Checksum))
+				if err != nil {
+					return fmt.Errorf("failed to evict %s from artifact cache: %w", evicted.Checksum, err)
+				}
+			}
+
+			c.entries = c.entries[:i]
+			break
+		}
+	}
+
+	return nil
+}
+
+func (c ArtifactCache) writeIndex() error {
+	content, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal artifact cache index: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(c.layer.Path, artifactCacheIndex), content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write artifact cache index: %w", err)
+	}
+
+	return nil
+}
+
+func (c ArtifactCache) entryPath(checksum string) string {
+	return filepath.Join(c.layer.Path, strings.ReplaceAll(checksum, ":", "-"))
+}
+
+// artifactCacheConfig reads the artifact cache limits from the environment.
+// The cache is disabled unless BP_YARN_ARTIFACT_CACHE_ENTRIES is set to a
+// positive number.
+func artifactCacheConfig() (int, int64, error) {
+	var maxEntries int
+	if value, ok := os.LookupEnv("BP_YARN_ARTIFACT_CACHE_ENTRIES"); ok {
+		var err error
+		maxEntries, err = strconv.Atoi(value)
+		if err != nil || maxEntries < 0 {
+			return 0, 0, fmt.Errorf("failed to parse BP_YARN_ARTIFACT_CACHE_ENTRIES value %s: must be a non-negative integer", value)
+		}
+	}
+
+	maxSizeMB := int64(defaultArtifactCacheMaxSizeMB)
+	if value, ok := os.LookupEnv("BP_YARN_ARTIFACT_CACHE_MAX_SIZE_MB"); ok {
+		var err error
+		maxSizeMB, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || maxSizeMB < 0 {
+			return 0, 0, fmt.Errorf("failed to parse BP_YARN_ARTIFACT_CACHE_MAX_SIZE_MB value %s: must be a non-negative integer", value)
+		}
+	}
+
+	return maxEntries, maxSizeMB * 1024 * 1024, nil
+}
+
+// dependencyChecksum returns the checksum postal.Service verifies the
+// dependency against.
+func dependencyChecksum(dependency postal.Dependency) string {
+	if dependency.SHA256 != "" {
+		return fmt.Sprintf("sha256:%s", dependency.SHA256)
+	}
+
+	return dependency.Checksum
+}
+
+// resetDir empties dir, leaving an empty directory behind.
+func resetDir(dir string) error {
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return fmt.Errorf("failed to clean up %s: %w", dir, err)
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to clean up %s: %w", dir, err)
+	}
+
+	return nil
+}
+
+// archiveDir writes the contents of dir to w as a gzipped tar archive,
+// keeping file modes and symlinks.
+func archiveDir(dir string, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(tw.Close(), gw.Close())
+}
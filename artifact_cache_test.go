@@ -0,0 +1,220 @@
+package yarn_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/chronos"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/yarn"
+	"github.com/paketo-buildpacks/yarn/fakes"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testArtifactCache(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		layerPath  string
+		layersDir  string
+		now        time.Time
+		clock      chronos.Clock
+		deliverer  *fakes.DependencyManager
+		dependency func(name string) postal.Dependency
+	)
+
+	it.Before(func() {
+		var err error
+		layerPath, err = os.MkdirTemp("", "artifact-cache")
+		Expect(err).NotTo(HaveOccurred())
+
+		layersDir, err = os.MkdirTemp("", "layers")
+		Expect(err).NotTo(HaveOccurred())
+
+		now = time.Unix(1000, 0)
+		clock = chronos.NewClock(func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		})
+
+		deliverer = &fakes.DependencyManager{}
+		deliverer.DeliverCall.Stub = func(dependency postal.Dependency, cnbPath, layerPath, platformPath string) error {
+			err := os.MkdirAll(filepath.Join(layerPath, "bin"), os.ModePerm)
+			if err != nil {
+				return err
+			}
+
+			err = os.WriteFile(filepath.Join(layerPath, "bin", "yarn.js"), []byte(dependency.Name), 0755)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink("yarn.js", filepath.Join(layerPath, "bin", "yarn"))
+		}
+
+		dependency = func(name string) postal.Dependency {
+			return postal.Dependency{
+				ID:       "yarn",
+				Name:     name,
+				Checksum: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(name))),
+			}
+		}
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(layerPath)).To(Succeed())
+		Expect(os.RemoveAll(layersDir)).To(Succeed())
+	})
+
+	newCache := func(maxEntries int, maxSize int64) yarn.ArtifactCache {
+		cache, err := yarn.NewArtifactCache(packit.Layer{Name: "yarn-artifacts", Path: layerPath}, deliverer, maxEntries, maxSize, clock)
+		Expect(err).NotTo(HaveOccurred())
+		return cache
+	}
+
+	deliver := func(cache *yarn.ArtifactCache, dependency postal.Dependency) (string, bool) {
+		destination, err := os.MkdirTemp(layersDir, "destination")
+		Expect(err).NotTo(HaveOccurred())
+
+		cached, err := cache.Deliver(dependency, "some-cnb-path", destination, "some-platform-path")
+		Expect(err).NotTo(HaveOccurred())
+		return destination, cached
+	}
+
+	entryPath := func(dependency postal.Dependency) string {
+		return filepath.Join(layerPath, "sha256-"+dependency.Checksum[len("sha256:"):])
+	}
+
+	it("delivers through the wrapped deliverer and restores the delivered files without it", func() {
+		first := dependency("first")
+
+		cache := newCache(2, 0)
+		destination, cached := deliver(&cache, first)
+		Expect(cached).To(BeFalse())
+		Expect(deliverer.DeliverCall.CallCount).To(Equal(1))
+		Expect(deliverer.DeliverCall.Receives.Dependency).To(Equal(first))
+		Expect(deliverer.DeliverCall.Receives.CnbPath).To(Equal("some-cnb-path"))
+		Expect(deliverer.DeliverCall.Receives.LayerPath).To(Equal(destination))
+		Expect(deliverer.DeliverCall.Receives.PlatformPath).To(Equal("some-platform-path"))
+		Expect(entryPath(first)).To(BeARegularFile())
+
+		cache = newCache(2, 0)
+		Expect(cache.Has(first.Checksum)).To(BeTrue())
+
+		destination, cached = deliver(&cache, first)
+		Expect(cached).To(BeTrue())
+		Expect(deliverer.DeliverCall.CallCount).To(Equal(1))
+
+		content, err := os.ReadFile(filepath.Join(destination, "bin", "yarn.js"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("first"))
+
+		info, err := os.Stat(filepath.Join(destination, "bin", "yarn.js"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+
+		link, err := os.Readlink(filepath.Join(destination, "bin", "yarn"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(link).To(Equal("yarn.js"))
+	})
+
+	it("evicts the least recently used archive when there are too many entries", func() {
+		first, second, third := dependency("first"), dependency("second"), dependency("third")
+
+		cache := newCache(2, 0)
+		deliver(&cache, first)
+		deliver(&cache, second)
+		deliver(&cache, first)
+		deliver(&cache, third)
+
+		Expect(entryPath(first)).To(BeARegularFile())
+		Expect(entryPath(second)).NotTo(BeAnExistingFile())
+		Expect(entryPath(third)).To(BeARegularFile())
+	})
+
+	it("keeps touched archives when evicting", func() {
+		first, second, third := dependency("first"), dependency("second"), dependency("third")
+
+		cache := newCache(2, 0)
+		deliver(&cache, first)
+		deliver(&cache, second)
+		Expect(cache.Touch(first.Checksum)).To(Succeed())
+
+		cache = newCache(2, 0)
+		deliver(&cache, third)
+
+		Expect(entryPath(first)).To(BeARegularFile())
+		Expect(entryPath(second)).NotTo(BeAnExistingFile())
+	})
+
+	it("evicts archives that do not fit within the size cap", func() {
+		first, second := dependency("first"), dependency("second")
+
+		cache := newCache(5, 1)
+		deliver(&cache, first)
+		deliver(&cache, second)
+
+		Expect(entryPath(first)).NotTo(BeAnExistingFile())
+		Expect(entryPath(second)).To(BeARegularFile())
+	})
+
+	context("when a cached archive is damaged", func() {
+		it("delivers it again and does not report it as served from the cache", func() {
+			first := dependency("first")
+
+			cache := newCache(2, 0)
+			deliver(&cache, first)
+			Expect(os.WriteFile(entryPath(first), []byte("damaged"), 0644)).To(Succeed())
+
+			destination, cached := deliver(&cache, first)
+			Expect(cached).To(BeFalse())
+			Expect(filepath.Join(destination, "bin", "yarn.js")).To(BeARegularFile())
+			Expect(deliverer.DeliverCall.CallCount).To(Equal(2))
+
+			cache = newCache(2, 0)
+			_, cached = deliver(&cache, first)
+			Expect(cached).To(BeTrue())
+		})
+	})
+
+	context("failure cases", func() {
+		context("when the index cannot be parsed", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(layerPath, "index.json"), []byte("%%%"), 0644)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := yarn.NewArtifactCache(packit.Layer{Path: layerPath}, deliverer, 1, 0, clock)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse artifact cache index")))
+			})
+		})
+
+		context("when the wrapped deliverer fails", func() {
+			it.Before(func() {
+				deliverer.DeliverCall.Stub = nil
+				deliverer.DeliverCall.Returns.Error = errors.New("checksum does not match")
+			})
+
+			it("does not keep an archive", func() {
+				first := dependency("first")
+
+				cache := newCache(2, 0)
+				cached, err := cache.Deliver(first, "some-cnb-path", t.TempDir(), "some-platform-path")
+				Expect(err).To(MatchError("checksum does not match"))
+				Expect(cached).To(BeFalse())
+
+				Expect(cache.Has(first.Checksum)).To(BeFalse())
+				Expect(entryPath(first)).NotTo(BeAnExistingFile())
+				Expect(entryPath(first) + ".partial").NotTo(BeAnExistingFile())
+			})
+		})
+	})
+}
@@ -1,20 +1,21 @@
 package yarn
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/paketo-buildpacks/packit/v2"
 	"github.com/paketo-buildpacks/packit/v2/chronos"
 	"github.com/paketo-buildpacks/packit/v2/draft"
+	"github.com/paketo-buildpacks/packit/v2/pexec"
 	"github.com/paketo-buildpacks/packit/v2/postal"
 	"github.com/paketo-buildpacks/packit/v2/sbom"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 //go:generate faux --interface DependencyManager --output fakes/dependency_manager.go
@@ -27,15 +28,35 @@ type DependencyManager interface {
 //go:generate faux --interface SBOMGenerator --output fakes/sbom_generator.go
 type SBOMGenerator interface {
 	GenerateFromDependency(dependency postal.Dependency, dir string) (sbom.SBOM, error)
+	Generate(dir string) (sbom.SBOM, error)
+}
+
+//go:generate faux --interface VersionResolver --output fakes/version_resolver.go
+type VersionResolver interface {
+	Resolve(project Project, entries []packit.BuildpackPlanEntry, pins bool) (VersionResolution, error)
+}
+
+//go:generate faux --interface Sleeper --output fakes/sleeper.go
+type Sleeper interface {
+	Sleep(duration time.Duration)
+}
+
+//go:generate faux --interface Executable --output fakes/executable.go
+type Executable interface {
+	Execute(execution pexec.Execution) error
 }
 
 func Build(
 	dependencyManager DependencyManager,
 	sbomGenerator SBOMGenerator,
+	versionResolver VersionResolver,
+	node Executable,
+	yarn Executable,
 	clock chronos.Clock,
+	sleeper Sleeper,
 	logger scribe.Emitter,
 ) packit.BuildFunc {
-	return func(context packit.BuildContext) (packit.BuildResult, error) {
+	run := func(context packit.BuildContext, report *BuildReport, tracer phaseTracer) (packit.BuildResult, error) {
 		logger.Title("%s %s", context.BuildpackInfo.Name, context.BuildpackInfo.Version)
 
 		yarnLayer, err := context.Layers.Get(YarnLayerName)
@@ -43,34 +64,197 @@ func Build(
 			return packit.BuildResult{}, err
 		}
 
-		planner := draft.NewPlanner()
-		entry, _ := planner.Resolve("yarn", context.Plan.Entries, nil)
-		version, ok := entry.Metadata["version"].(string)
-		if !ok {
-			version = "default"
+		entry, _ := draft.NewPlanner().Resolve("yarn", context.Plan.Entries, nil)
+		launch, build := draft.NewPlanner().MergeLayerTypes("yarn", context.Plan.Entries)
+
+		useSystemYarn, err := lookupBoolEnv("BP_YARN_USE_SYSTEM")
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		nodePolicy, err := nodeCompatibilityPolicyFromEnv()
+		if err != nil {
+			return packit.BuildResult{}, err
 		}
 
-		// Determine whether the app uses Yarn Berry via the packageManager field
-		// in package.json (e.g. "yarn@4.x.x") or via BP_YARN_VERSION env var.
-		dependencyID := YarnDependency
-		if isBerry(context.WorkingDir, version) {
-			dependencyID = BerryDependency
-			// Reset version so the dependency constraint in buildpack.toml drives selection.
-			version = "default"
+		advisories, err := advisoryGateFromEnv(context.Platform.Path)
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		global, err := globalPackagesFromEnv()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		projects, err := FindProjects(context.WorkingDir)
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		if len(projects) > 0 {
+			if useSystemYarn {
+				return packit.BuildResult{}, errors.New("BP_YARN_USE_SYSTEM cannot be combined with BP_YARN_PROJECT_PATHS")
+			}
+
+			if os.Getenv("BP_YARN_PROCESSES") != "" {
+				return packit.BuildResult{}, errors.New("BP_YARN_PROCESSES cannot be combined with BP_YARN_PROJECT_PATHS")
+			}
+
+			if len(global.Packages) > 0 {
+				return packit.BuildResult{}, errors.New("BP_YARN_GLOBAL_PACKAGES cannot be combined with BP_YARN_PROJECT_PATHS")
+			}
+
+			installer, err := newInstaller(context, dependencyManager, sbomGenerator, node, yarn, clock, sleeper, logger, report, tracer, launch, build)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			return buildProjects(context, projects, versionResolver, entry, yarnLayer, installer, nodePolicy, advisories)
+		}
+
+		project, err := FindProject(context.WorkingDir)
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		if project.Path != context.WorkingDir {
+			logger.Process("Using Yarn project at %s", project.Path)
+		}
+
+		// A single project keeps the behavior the buildpack always had: a
+		// packageManager pin only selects the flavor, and Yarn Berry is
+		// installed at its default version. buildProjects honors the pins, as
+		// the projects it installs for differ by the version they pin.
+		resolution, err := versionResolver.Resolve(project, context.Plan.Entries, false)
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		dependencyID, version := resolution.DependencyID, resolution.Constraint
+		warnSkippedSources(logger, report, resolution)
+		logTrail(logger, resolution.Trail)
+
+		processes, err := processesFromEnv(project, context.WorkingDir, dependencyID)
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		if len(processes) > 0 && !launch {
+			logger.Process("Skipping the processes in BP_YARN_PROCESSES: Yarn is not required at launch")
+			logger.Break()
+			processes = nil
+		}
+
+		if useSystemYarn {
+			logger.Process("Using system Yarn")
+
+			_, resolveSpan := tracer.start("yarn.resolve", attribute.String("yarn.dependency.id", dependencyID), attribute.Bool("yarn.system", true))
+			dependency, path, err := findSystemYarn(yarn, dependencyID, systemYarnConstraint(dependencyID, version))
+			endSpan(resolveSpan, err)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			logger.Subprocess("Found yarn %s at %s", dependency.Version, path)
+			logger.Break()
+
+			err = advisories.check(dependency, logger, report, tracer)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			bom := dependencyManager.GenerateBillOfMaterials(dependency)
+
+			sbomOptions, err := sbomOptionsFromEnv(context.BuildpackInfo.SBOMFormats)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			var sbomFormatter packit.SBOMFormatter
+			duration, err := clock.Measure(func() error {
+				sbomFormatter, err = generateSBOM(sbomGenerator, dependency, filepath.Dir(path), sbomOptions, clock, logger, tracer)
+				return err
+			})
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			installReport := newInstallReport(dependency, resolution.Source())
+			installReport.SBOMDurationMS = duration.Milliseconds()
+			report.addInstall(installReport)
+
+			var result packit.BuildResult
+			if build {
+				result.Build = packit.BuildMetadata{BOM: bom, SBOM: sbomFormatter}
+
+				// The layer only carries the build environment describing the
+				// system Yarn to later buildpacks.
+				yarnLayer, err = yarnLayer.Reset()
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+
+				yarnLayer.Build = true
+
+				yarnLayer, err = publishInfo(yarnLayer, []InstallInfo{
+					newInstallInfo(dependency, filepath.Dir(filepath.Dir(path)), project.Path),
+				})
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+
+				result.Layers = []packit.Layer{yarnLayer}
+			}
+
+			if len(global.Packages) > 0 {
+				globalLayer, err := global.install(context.Layers, yarn, filepath.Dir(path), dependency, sbomGenerator, sbomOptions, logger, tracer, launch, build)
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+
+				result.Layers = append(result.Layers, globalLayer)
+			}
+
+			if launch {
+				labels, err := imageLabels(dependency)
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+
+				result.Launch = packit.LaunchMetadata{BOM: bom, SBOM: sbomFormatter, Labels: labels, Processes: processes}
+				if len(processes) > 0 {
+					logger.LaunchProcesses(processes)
+				}
+			}
+
+			return result, nil
 		}
 
+		_, resolveSpan := tracer.start("yarn.resolve", attribute.String("yarn.dependency.id", dependencyID), attribute.String("yarn.version.constraint", version))
 		dependency, err := dependencyManager.Resolve(
 			filepath.Join(context.CNBPath, "buildpack.toml"),
 			dependencyID,
 			version,
 			context.Stack)
+		endSpan(resolveSpan, err)
 		if err != nil {
 			return packit.BuildResult{}, err
 		}
 
-		bom := dependencyManager.GenerateBillOfMaterials(dependency)
+		if nodePolicy != NodeCompatibilityOff {
+			err = checkNodeCompatibility(context.CNBPath, dependency, entry, node, nodePolicy, logger, report)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+		}
 
-		launch, build := planner.MergeLayerTypes("yarn", context.Plan.Entries)
+		err = advisories.check(dependency, logger, report, tracer)
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		bom := dependencyManager.GenerateBillOfMaterials(dependency)
 
 		var buildMetadata = packit.BuildMetadata{}
 		var launchMetadata = packit.LaunchMetadata{}
@@ -79,146 +263,348 @@ func Build(
 		}
 
 		if launch {
-			launchMetadata = packit.LaunchMetadata{BOM: bom}
-		}
-
-		cachedSHA, ok := yarnLayer.Metadata[DependencyCacheKey].(string)
-		if ok && postal.Checksum(dependency.Checksum).MatchString(cachedSHA) {
-			logger.Process("Reusing cached layer %s", yarnLayer.Path)
-			logger.Break()
+			labels, err := imageLabels(dependency)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
 
-			yarnLayer.Launch, yarnLayer.Build, yarnLayer.Cache = launch, build, build
+			launchMetadata = packit.LaunchMetadata{BOM: bom, Labels: labels, Processes: processes}
+		}
 
-			return packit.BuildResult{
-				Layers: []packit.Layer{yarnLayer},
-				Build:  buildMetadata,
-				Launch: launchMetadata,
-			}, nil
+		installer, err := newInstaller(context, dependencyManager, sbomGenerator, node, yarn, clock, sleeper, logger, report, tracer, launch, build)
+		if err != nil {
+			return packit.BuildResult{}, err
 		}
 
-		logger.Process("Executing build process")
+		yarnLayer, err = installer.Install(yarnLayer, dependency, resolution.Source(), "")
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
 
-		yarnLayer, err = yarnLayer.Reset()
+		yarnLayer, err = publishInfo(yarnLayer, []InstallInfo{
+			newInstallInfo(dependency, yarnLayer.Path, project.Path),
+		})
 		if err != nil {
 			return packit.BuildResult{}, err
 		}
 
-		yarnLayer.Launch, yarnLayer.Build, yarnLayer.Cache = launch, build, build
+		if yarnLayer.Launch {
+			yarnLayer.ExecD = []string{filepath.Join(context.CNBPath, "bin", RuntimeEnvExecutable)}
+		}
 
-		logger.Subprocess("Installing Yarn")
+		if len(processes) > 0 {
+			logger.LaunchProcesses(processes)
+		}
 
-		duration, err := clock.Measure(func() error {
-			return dependencyManager.Deliver(dependency, context.CNBPath, yarnLayer.Path, context.Platform.Path)
-		})
+		layers := []packit.Layer{yarnLayer}
+		if len(global.Packages) > 0 {
+			globalLayer, err := global.install(context.Layers, yarn, filepath.Join(yarnLayer.Path, "bin"), dependency, sbomGenerator, installer.sbomOptions, logger, tracer, launch, build)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			layers = append(layers, globalLayer)
+		}
+
+		if installer.artifactCache != nil {
+			layers = append(layers, installer.artifactCache.Layer())
+		}
+
+		return packit.BuildResult{
+			Layers: layers,
+			Build:  buildMetadata,
+			Launch: launchMetadata,
+		}, nil
+	}
+
+	return func(context packit.BuildContext) (packit.BuildResult, error) {
+		report := &BuildReport{}
+
+		epoch, err := sourceDateEpochFromEnv()
 		if err != nil {
 			return packit.BuildResult{}, err
 		}
 
-		// The @yarnpkg/cli-dist tarball ships bin/yarn as 0644; chmod it so it
-		// is executable on PATH. bin/yarn.js already has the correct 0755 mode.
-		if dependencyID == BerryDependency {
-			yarnShim := filepath.Join(yarnLayer.Path, "bin", "yarn")
-			if _, statErr := os.Stat(yarnShim); statErr == nil {
-				if err := os.Chmod(yarnShim, 0755); err != nil {
-					return packit.BuildResult{}, fmt.Errorf("failed to make berry yarn shim executable: %w", err)
-				}
+		// Tracing is only diagnostic, so a configuration it cannot honor is
+		// reported and the build goes on without traces.
+		tracer, shutdown, err := newTracing()
+		if err != nil {
+			logger.Process("Tracing is disabled: %s", err)
+			logger.Break()
+			report.Warn("tracing is disabled: %s", err)
+		}
+
+		buildTracer, span := tracer.start("yarn.build", attribute.String("buildpack.version", context.BuildpackInfo.Version))
+		result, err := run(context, report, buildTracer)
+		if err == nil {
+			// Two builds of the same app must produce identical layers, so
+			// nothing may depend on when or in which order files were written.
+			err = normalizeLayers(result.Layers, epoch)
+		}
+		endSpan(span, err)
+
+		// A tracing backend that cannot be reached must not fail the build.
+		shutdownErr := shutdown()
+		if shutdownErr != nil {
+			logger.Process("Failed to export traces: %s", shutdownErr)
+			report.Warn("failed to export traces: %s", shutdownErr)
+		}
+
+		// The report is also written when the build fails so that failures
+		// show up in the aggregated telemetry.
+		if path, ok := os.LookupEnv("BP_YARN_REPORT_PATH"); ok && path != "" {
+			writeErr := report.write(path, err)
+			if writeErr != nil && err == nil {
+				return packit.BuildResult{}, writeErr
 			}
 		}
 
-		logger.Action("Completed in %s", duration.Round(time.Millisecond))
-		logger.Break()
+		return result, err
+	}
+}
 
-		sbomDisabled, err := checkSbomDisabled()
+// buildProjects installs the Yarn pinned by each of the projects into its own
+// layer, named after the dependency and its version, and puts a selector
+// that runs the right one for the current project into the yarn layer.
+// Projects that pin the same version share a layer.
+func buildProjects(
+	context packit.BuildContext,
+	projects []Project,
+	versionResolver VersionResolver,
+	entry packit.BuildpackPlanEntry,
+	selectorLayer packit.Layer,
+	installer installer,
+	nodePolicy NodeCompatibilityPolicy,
+	advisories advisoryGate,
+) (packit.BuildResult, error) {
+	var (
+		dependencies []postal.Dependency
+		layers       []packit.Layer
+		selections   []projectSelection
+		installs     []InstallInfo
+	)
+
+	installed := map[string]packit.Layer{}
+	indexes := map[string]int{}
+	for _, project := range projects {
+		// Unlike a single project, each project gets the version it pins, so
+		// that projects pinning different releases of the same flavor do not
+		// share the default version.
+		resolution, err := versionResolver.Resolve(project, context.Plan.Entries, true)
 		if err != nil {
 			return packit.BuildResult{}, err
 		}
 
-		if sbomDisabled {
-			logger.Subprocess("Skipping SBOM generation for Yarn")
-			logger.Break()
-		} else {
-			logger.GeneratingSBOM(yarnLayer.Path)
-			var sbomContent sbom.SBOM
-			duration, err = clock.Measure(func() error {
-				sbomContent, err = sbomGenerator.GenerateFromDependency(dependency, yarnLayer.Path)
-				return err
-			})
+		dependencyID, version := resolution.DependencyID, resolution.Constraint
+		warnSkippedSources(installer.logger, installer.report, resolution)
+		logTrail(installer.logger, resolution.Trail)
+
+		_, resolveSpan := installer.tracer.start("yarn.resolve", attribute.String("yarn.dependency.id", dependencyID), attribute.String("yarn.version.constraint", version), attribute.String("yarn.project", project.Path))
+		dependency, err := installer.dependencyManager.Resolve(
+			filepath.Join(context.CNBPath, "buildpack.toml"),
+			dependencyID,
+			version,
+			context.Stack)
+		endSpan(resolveSpan, err)
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		name := fmt.Sprintf("%s-%s", dependency.ID, dependency.Version)
+		installer.logger.Process("Yarn project %s uses %s", project.Path, name)
+
+		layer, ok := installed[name]
+		if !ok {
+			if nodePolicy != NodeCompatibilityOff {
+				err = checkNodeCompatibility(context.CNBPath, dependency, entry, installer.node, nodePolicy, installer.logger, installer.report)
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+			}
+
+			err = advisories.check(dependency, installer.logger, installer.report, installer.tracer)
 			if err != nil {
 				return packit.BuildResult{}, err
 			}
 
-			logger.Action("Completed in %s", duration.Round(time.Millisecond))
-			logger.Break()
+			layer, err = context.Layers.Get(name)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
 
-			logger.FormattingSBOM(context.BuildpackInfo.SBOMFormats...)
-			yarnLayer.SBOM, err = sbomContent.InFormats(context.BuildpackInfo.SBOMFormats...)
+			layer, err = installer.Install(layer, dependency, resolution.Source(), projectLayerInstallDir)
 			if err != nil {
 				return packit.BuildResult{}, err
 			}
-		}
 
-		yarnLayer.Metadata = map[string]interface{}{
-			DependencyCacheKey: dependency.Checksum,
-			"dependency-id":    dependencyID,
+			installed[name] = layer
+			indexes[name] = len(installs)
+			dependencies = append(dependencies, dependency)
+			layers = append(layers, layer)
+			installs = append(installs, newInstallInfo(dependency, filepath.Join(layer.Path, projectLayerInstallDir)))
 		}
 
-		return packit.BuildResult{
-			Layers: []packit.Layer{yarnLayer},
-			Build:  buildMetadata,
-			Launch: launchMetadata,
-		}, nil
+		installs[indexes[name]].Projects = append(installs[indexes[name]].Projects, project.Path)
+
+		selections = append(selections, projectSelection{
+			ProjectPath: project.Path,
+			BinDir:      filepath.Join(layer.Path, projectLayerInstallDir, "bin"),
+		})
+	}
+
+	selectorLayer, err := selectorLayer.Reset()
+	if err != nil {
+		return packit.BuildResult{}, err
+	}
+
+	selectorLayer.Launch, selectorLayer.Build = installer.launch, installer.build
+	if selectorLayer.Launch {
+		selectorLayer.ExecD = []string{filepath.Join(context.CNBPath, "bin", RuntimeEnvExecutable)}
 	}
-}
 
-func checkSbomDisabled() (bool, error) {
-	if disableStr, ok := os.LookupEnv("BP_DISABLE_SBOM"); ok {
-		disable, err := strconv.ParseBool(disableStr)
+	err = writeSelector(selectorLayer.Path, selections)
+	if err != nil {
+		return packit.BuildResult{}, err
+	}
+
+	selectorLayer, err = publishInfo(selectorLayer, installs)
+	if err != nil {
+		return packit.BuildResult{}, err
+	}
+
+	layers = append(layers, selectorLayer)
+	if installer.artifactCache != nil {
+		layers = append(layers, installer.artifactCache.Layer())
+	}
+
+	bom := installer.dependencyManager.GenerateBillOfMaterials(dependencies...)
+
+	var result packit.BuildResult
+	result.Layers = layers
+	if installer.build {
+		result.Build = packit.BuildMetadata{BOM: bom}
+	}
+
+	if installer.launch {
+		labels, err := imageLabels(dependencies...)
 		if err != nil {
-			return false, fmt.Errorf("failed to parse BP_DISABLE_SBOM value %s: %w", disableStr, err)
+			return packit.BuildResult{}, err
 		}
-		return disable, nil
+
+		result.Launch = packit.LaunchMetadata{BOM: bom, Labels: labels}
 	}
-	return false, nil
+
+	return result, nil
 }
 
-// isBerry returns true when the app declares a packageManager field in
-// package.json that starts with "yarn@" and the major version is >= 2 (i.e.
-// Yarn Berry), or when the resolved build-plan version string is >= "2".
-func isBerry(workingDir, version string) bool {
-	// Explicit version constraint wins first.
-	if version != "" && version != "default" {
-		major := strings.SplitN(version, ".", 2)[0]
-		if major >= "2" {
-			return true
+// generateSBOM generates the SBOM for the dependency installed in dir in the
+// formats selected by the options and writes copies of it to the output
+// directory, if any. It returns nil when SBOM generation is disabled with
+// BP_DISABLE_SBOM.
+func generateSBOM(
+	sbomGenerator SBOMGenerator,
+	dependency postal.Dependency,
+	dir string,
+	options sbomOptions,
+	clock chronos.Clock,
+	logger scribe.Emitter,
+	tracer phaseTracer,
+) (packit.SBOMFormatter, error) {
+	sbomDisabled, err := lookupBoolEnv("BP_DISABLE_SBOM")
+	if err != nil {
+		return nil, err
+	}
+
+	if sbomDisabled {
+		logger.Subprocess("Skipping SBOM generation for Yarn")
+		logger.Break()
+		return nil, nil
+	}
+
+	logger.GeneratingSBOM(dir)
+	_, span := tracer.start("yarn.sbom.generate", append(dependencyAttributes(dependency), attribute.String("sbom.mode", string(options.Mode)))...)
+	var sbomContent, dependencyContent sbom.SBOM
+	duration, err := clock.Measure(func() error {
+		switch options.Mode {
+		case SBOMModeScan:
+			sbomContent, err = sbomGenerator.Generate(dir)
+		case SBOMModeBoth:
+			sbomContent, err = sbomGenerator.Generate(dir)
+			if err != nil {
+				return err
+			}
+			dependencyContent, err = sbomGenerator.GenerateFromDependency(dependency, dir)
+		default:
+			sbomContent, err = sbomGenerator.GenerateFromDependency(dependency, dir)
 		}
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
 	}
 
-	pm := readPackageManager(workingDir)
-	if strings.HasPrefix(pm, "yarn@") {
-		ver := strings.TrimPrefix(pm, "yarn@")
-		major := strings.SplitN(ver, ".", 2)[0]
-		if major >= "2" {
-			return true
+	logger.Action("Completed in %s", duration.Round(time.Millisecond))
+	logger.Break()
+
+	logger.FormattingSBOM(options.Formats...)
+	_, span = tracer.start("yarn.sbom.format", attribute.StringSlice("sbom.formats", options.Formats))
+	formatter, err := formatSBOM(sbomContent, dependencyContent, dependency, dir, options)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.OutputDir != "" {
+		logger.Subprocess("Writing SBOM copies to %s", options.OutputDir)
+		logger.Break()
+
+		err = writeSBOMCopies(formatter, dependency, options.OutputDir)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return false
+
+	return formatter, nil
 }
 
-// readPackageManager reads the "packageManager" field from package.json in the
-// given directory. Returns an empty string if the file cannot be read or the
-// field is absent.
-func readPackageManager(workingDir string) string {
-	f, err := os.Open(filepath.Join(workingDir, "package.json"))
+// formatSBOM formats the SBOM in the selected formats. In the both mode the
+// packages of the dependency SBOM are merged into the scanned one. In every
+// mode the entry of the dependency references its license files in dir.
+func formatSBOM(sbomContent, dependencyContent sbom.SBOM, dependency postal.Dependency, dir string, options sbomOptions) (packit.SBOMFormatter, error) {
+	var formatter packit.SBOMFormatter
+	formatter, err := sbomContent.InFormats(options.Formats...)
 	if err != nil {
-		return ""
+		return nil, err
 	}
-	defer func() { _ = f.Close() }()
 
-	var pkg struct {
-		PackageManager string `json:"packageManager"`
+	if options.Mode == SBOMModeBoth {
+		dependencyFormatter, err := dependencyContent.InFormats(options.Formats...)
+		if err != nil {
+			return nil, err
+		}
+
+		formatter, err = MergeSBOMs(formatter, dependencyFormatter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	licenseFiles, err := licenseFilePaths(dir)
+	if err != nil {
+		return nil, err
 	}
-	if err := json.NewDecoder(f).Decode(&pkg); err != nil {
-		return ""
+
+	return ReferenceLicenseFiles(formatter, dependency, licenseFiles)
+}
+
+func lookupBoolEnv(name string) (bool, error) {
+	if str, ok := os.LookupEnv(name); ok {
+		value, err := strconv.ParseBool(str)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse %s value %s: %w", name, str, err)
+		}
+		return value, nil
 	}
-	return pkg.PackageManager
+	return false, nil
 }
@@ -2,21 +2,32 @@ package yarn_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/paketo-buildpacks/packit/v2"
 	"github.com/paketo-buildpacks/packit/v2/chronos"
+	"github.com/paketo-buildpacks/packit/v2/pexec"
 	"github.com/paketo-buildpacks/packit/v2/postal"
 	"github.com/paketo-buildpacks/packit/v2/sbom"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
 	"github.com/paketo-buildpacks/yarn"
 	"github.com/paketo-buildpacks/yarn/fakes"
 	"github.com/sclevine/spec"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
 
 	//nolint Ignore SA1019, informed usage of deprecated package
 	"github.com/paketo-buildpacks/packit/v2/paketosbom"
@@ -33,6 +44,9 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 		cnbDir            string
 		dependencyManager *fakes.DependencyManager
 		sbomGenerator     *fakes.SBOMGenerator
+		node              *fakes.Executable
+		yarnExecutable    *fakes.Executable
+		sleeper           *fakes.Sleeper
 
 		buffer *bytes.Buffer
 
@@ -74,9 +88,27 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			},
 		}
 
+		dependencyManager.DeliverCall.Stub = func(dep postal.Dependency, cnbPath, layerPath, platformPath string) error {
+			return writeBinFiles(layerPath, 0755, "yarn", "yarn.js", "yarnpkg")
+		}
+
 		sbomGenerator = &fakes.SBOMGenerator{}
 		sbomGenerator.GenerateFromDependencyCall.Returns.SBOM = sbom.SBOM{}
 
+		node = &fakes.Executable{}
+		node.ExecuteCall.Stub = func(execution pexec.Execution) error {
+			_, err := fmt.Fprintln(execution.Stdout, "v20.11.0")
+			return err
+		}
+
+		sleeper = &fakes.Sleeper{}
+
+		yarnExecutable = &fakes.Executable{}
+		yarnExecutable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+			_, err := fmt.Fprintln(execution.Stdout, dependencyManager.ResolveCall.Returns.Dependency.Version)
+			return err
+		}
+
 		buffer = bytes.NewBuffer(nil)
 
 		buildContext = packit.BuildContext{
@@ -101,7 +133,11 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 
 		build = yarn.Build(dependencyManager,
 			sbomGenerator,
+			yarn.NewVersionResolver(yarn.DefaultVersionSources()...),
+			node,
+			yarnExecutable,
 			chronos.DefaultClock,
+			sleeper,
 			scribe.NewEmitter(buffer))
 	})
 
@@ -111,6 +147,22 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 		Expect(os.RemoveAll(workingDir)).To(Succeed())
 	})
 
+	// yarnSettings returns the YARN_ variables set by the layers, which Yarn
+	// Berry reads as settings.
+	yarnSettings := func(layers []packit.Layer) []string {
+		var settings []string
+		for _, layer := range layers {
+			for _, env := range []packit.Environment{layer.BuildEnv, layer.LaunchEnv, layer.SharedEnv} {
+				for key := range env {
+					if strings.HasPrefix(key, "YARN_") {
+						settings = append(settings, key)
+					}
+				}
+			}
+		}
+		return settings
+	}
+
 	it("returns a result that installs yarn", func() {
 		result, err := build(buildContext)
 		Expect(err).NotTo(HaveOccurred())
@@ -120,10 +172,37 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 
 		Expect(layer.Name).To(Equal("yarn"))
 		Expect(layer.Path).To(Equal(filepath.Join(layersDir, "yarn")))
+		Expect(layer.ExecD).To(BeEmpty())
 		Expect(layer.Metadata).To(Equal(map[string]interface{}{
-			yarn.DependencyCacheKey: "sha256:yarn-dependency-sha",
+			"schema-version":        yarn.LayerMetadataSchemaVersion,
 			"dependency-id":         "yarn",
+			"dependency-version":    "yarn-dependency-version",
+			yarn.DependencyCacheKey: "sha256:yarn-dependency-sha",
+			"fixups":                []string{yarn.FixupBinLayout, yarn.FixupLicenses},
+		}))
+
+		Expect(layer.BuildEnv).To(Equal(packit.Environment{
+			"PAKETO_YARN_FLAVOR.override":        "classic",
+			"PAKETO_YARN_VERSION.override":       "yarn-dependency-version",
+			"PAKETO_YARN_DEPENDENCY_ID.override": "yarn",
+			"PAKETO_YARN_HOME.override":          filepath.Join(layersDir, "yarn"),
+			"PAKETO_YARN_INFO.override":          filepath.Join(layersDir, "yarn", "yarn.json"),
 		}))
+		Expect(yarnSettings(result.Layers)).To(BeEmpty())
+
+		content, err := os.ReadFile(filepath.Join(layersDir, "yarn", "yarn.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(MatchJSON(fmt.Sprintf(`{
+			"installs": [
+				{
+					"flavor": "classic",
+					"version": "yarn-dependency-version",
+					"dependency-id": "yarn",
+					"path": %q,
+					"projects": [%q]
+				}
+			]
+		}`, filepath.Join(layersDir, "yarn"), workingDir)))
 
 		Expect(layer.SBOM.Formats()).To(HaveLen(2))
 
@@ -132,7 +211,7 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 
 		Expect(cdx.Extension).To(Equal("cdx.json"))
 
-		content, err := io.ReadAll(cdx.Content)
+		content, err = io.ReadAll(cdx.Content)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(string(content)).To(MatchJSON(`{
 			"$schema": "http://cyclonedx.org/schema/bom-1.3.schema.json",
@@ -257,190 +336,3057 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			Expect(layer.Build).To(BeTrue())
 			Expect(layer.Launch).To(BeTrue())
 			Expect(layer.Cache).To(BeTrue())
+			Expect(layer.ExecD).To(Equal([]string{filepath.Join(cnbDir, "bin", "yarn-runtime-env")}))
 			Expect(layer.Metadata).To(Equal(map[string]interface{}{
-				yarn.DependencyCacheKey: "sha256:yarn-dependency-sha",
+				"schema-version":        yarn.LayerMetadataSchemaVersion,
 				"dependency-id":         "yarn",
+				"dependency-version":    "yarn-dependency-version",
+				yarn.DependencyCacheKey: "sha256:yarn-dependency-sha",
+				"fixups":                []string{yarn.FixupBinLayout, yarn.FixupLicenses},
 			}))
 		})
 	})
 
-	context("when the app uses Yarn Berry via packageManager in package.json", func() {
+	context("when the plan entry requires the dependency during the launch phase", func() {
 		it.Before(func() {
-			err := os.WriteFile(filepath.Join(workingDir, "package.json"),
-				[]byte(`{"packageManager":"yarn@4.14.1"}`), os.ModePerm)
-			Expect(err).NotTo(HaveOccurred())
-
-			dependencyManager.ResolveCall.Returns.Dependency = postal.Dependency{
-				ID:       "berry",
-				Name:     "berry-dependency-name",
-				Checksum: "sha256:berry-dependency-sha",
-				Stacks:   []string{"some-stack"},
-				URI:      "berry-dependency-uri",
-				Version:  "4.14.1",
-			}
-
-			// Simulate the @yarnpkg/cli-dist tgz delivery: bin/yarn is extracted
-			// with 0644 and must be chmod'd to 0755 by the buildpack.
-			dependencyManager.DeliverCall.Stub = func(dep postal.Dependency, cnbPath, layerPath, platformPath string) error {
-				Expect(os.MkdirAll(filepath.Join(layerPath, "bin"), os.ModePerm)).To(Succeed())
-				return os.WriteFile(filepath.Join(layerPath, "bin", "yarn"), []byte("#!/bin/sh\n"), 0644)
+			buildContext.Plan.Entries[0].Metadata = map[string]interface{}{
+				"launch": true,
 			}
 		})
 
-		it("resolves the berry dependency and makes bin/yarn executable", func() {
+		it("labels the image with the installed yarn", func() {
 			result, err := build(buildContext)
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("berry"))
-
-			layer := result.Layers[0]
-			Expect(layer.Metadata).To(Equal(map[string]interface{}{
-				yarn.DependencyCacheKey: "sha256:berry-dependency-sha",
-				"dependency-id":         "berry",
+			Expect(result.Launch.Labels).To(Equal(map[string]string{
+				"io.paketo.yarn.version": "yarn-dependency-version",
+				"io.paketo.yarn.flavor":  "classic",
+				"io.paketo.yarn.source":  "yarn-dependency-uri",
 			}))
+		})
 
-			// bin/yarn must be executable after the buildpack runs.
-			info, err := os.Stat(filepath.Join(layer.Path, "bin", "yarn"))
-			Expect(err).NotTo(HaveOccurred())
-			Expect(info.Mode()&0111).NotTo(BeZero(), "bin/yarn should be executable")
+		context("when BP_YARN_IMAGE_LABEL_PREFIX is set", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_IMAGE_LABEL_PREFIX", "com.example.yarn.")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_IMAGE_LABEL_PREFIX")).To(Succeed())
+			})
+
+			it("uses the prefix for the labels", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(result.Launch.Labels).To(HaveKeyWithValue("com.example.yarn.version", "yarn-dependency-version"))
+				Expect(result.Launch.Labels).NotTo(HaveKey("io.paketo.yarn.version"))
+			})
+
+			context("when the prefix is not a valid label key", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_IMAGE_LABEL_PREFIX", "some prefix")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError(ContainSubstring(`failed to parse BP_YARN_IMAGE_LABEL_PREFIX value "some prefix"`)))
+				})
+			})
+		})
+
+		context("when BP_YARN_IMAGE_LABELS is false", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_IMAGE_LABELS", "false")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_IMAGE_LABELS")).To(Succeed())
+			})
+
+			it("does not label the image", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(result.Launch.Labels).To(BeEmpty())
+				Expect(result.Launch.BOM).To(HaveLen(1))
+			})
 		})
 	})
 
-	context("when the app uses packageManager yarn@2.x (classic threshold)", func() {
-		it.Before(func() {
-			err := os.WriteFile(filepath.Join(workingDir, "package.json"),
-				[]byte(`{"packageManager":"yarn@2.4.3"}`), os.ModePerm)
+	context("when verifying the installed yarn", func() {
+		it("runs yarn --version from the layer", func() {
+			result, err := build(buildContext)
 			Expect(err).NotTo(HaveOccurred())
 
-			dependencyManager.ResolveCall.Returns.Dependency = postal.Dependency{
-				ID:       "berry",
-				Version:  "2.4.3",
-				Checksum: "sha256:berry-2-sha",
-			}
+			Expect(node.ExecuteCall.Receives.Execution.Args).To(Equal([]string{"--version"}))
+			Expect(yarnExecutable.ExecuteCall.Receives.Execution.Args).To(Equal([]string{"--version"}))
+			Expect(yarnExecutable.ExecuteCall.Receives.Execution.Dir).To(Equal(result.Layers[0].Path))
+			Expect(yarnExecutable.ExecuteCall.Receives.Execution.Env).To(ContainElement(HavePrefix(fmt.Sprintf("PATH=%s", filepath.Join(result.Layers[0].Path, "bin")))))
+
+			Expect(buffer.String()).To(ContainSubstring("Verifying Yarn installation"))
+			Expect(buffer.String()).To(ContainSubstring("yarn --version reports yarn-dependency-version"))
 		})
 
-		it("resolves berry for major version 2", func() {
-			_, err := build(buildContext)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("berry"))
+		context("when node is not on the PATH", func() {
+			it.Before(func() {
+				node.ExecuteCall.Stub = nil
+				node.ExecuteCall.Returns.Error = &exec.Error{Name: "node", Err: exec.ErrNotFound}
+			})
+
+			it("skips the check", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(yarnExecutable.ExecuteCall.CallCount).To(Equal(0))
+				Expect(buffer.String()).To(ContainSubstring("Skipping: node is not available on the PATH"))
+			})
+		})
+
+		context("when BP_YARN_SKIP_VERSION_CHECK is true", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_SKIP_VERSION_CHECK", "true")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_SKIP_VERSION_CHECK")).To(Succeed())
+			})
+
+			it("does not run the check", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(node.ExecuteCall.CallCount).To(Equal(0))
+				Expect(yarnExecutable.ExecuteCall.CallCount).To(Equal(0))
+			})
 		})
 	})
 
-	context("when the app declares packageManager yarn@1.22.22 (classic)", func() {
+	context("when BP_YARN_USE_SYSTEM is true", func() {
+		var (
+			binDir string
+			path   string
+		)
+
 		it.Before(func() {
-			err := os.WriteFile(filepath.Join(workingDir, "package.json"),
-				[]byte(`{"packageManager":"yarn@1.22.22"}`), os.ModePerm)
+			var err error
+			binDir, err = os.MkdirTemp("", "bin")
 			Expect(err).NotTo(HaveOccurred())
-		})
+			Expect(os.WriteFile(filepath.Join(binDir, "yarn"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
 
-		it("still resolves the classic yarn dependency", func() {
-			_, err := build(buildContext)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("yarn"))
+			path = os.Getenv("PATH")
+			Expect(os.Setenv("PATH", binDir)).To(Succeed())
+			Expect(os.Setenv("BP_YARN_USE_SYSTEM", "true")).To(Succeed())
+
+			yarnExecutable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+				_, err := fmt.Fprintln(execution.Stdout, "1.22.22")
+				return err
+			}
+
+			buildContext.Plan.Entries[0].Metadata = map[string]interface{}{
+				"build":  true,
+				"launch": true,
+			}
 		})
-	})
 
-	context("when package.json has no packageManager field", func() {
-		it("defaults to classic yarn", func() {
-			_, err := build(buildContext)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("yarn"))
+		it.After(func() {
+			Expect(os.Setenv("PATH", path)).To(Succeed())
+			Expect(os.Unsetenv("BP_YARN_USE_SYSTEM")).To(Succeed())
+			Expect(os.RemoveAll(binDir)).To(Succeed())
 		})
-	})
 
-	context("when there is no package.json in the working directory", func() {
-		it("defaults to classic yarn", func() {
-			_, err := build(buildContext)
+		it("uses the yarn on the PATH instead of installing one", func() {
+			result, err := build(buildContext)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("yarn"))
+
+			Expect(result.Layers).To(HaveLen(1))
+			layer := result.Layers[0]
+			Expect(layer.Build).To(BeTrue())
+			Expect(layer.Launch).To(BeFalse())
+			Expect(layer.Cache).To(BeFalse())
+			Expect(layer.BuildEnv).To(HaveKeyWithValue("PAKETO_YARN_VERSION.override", "1.22.22"))
+			Expect(layer.BuildEnv).To(HaveKeyWithValue("PAKETO_YARN_HOME.override", filepath.Dir(binDir)))
+			Expect(filepath.Join(layer.Path, "yarn.json")).To(BeARegularFile())
+
+			Expect(dependencyManager.ResolveCall.CallCount).To(Equal(0))
+			Expect(dependencyManager.DeliverCall.CallCount).To(Equal(0))
+
+			Expect(yarnExecutable.ExecuteCall.Receives.Execution.Args).To(Equal([]string{"--version"}))
+
+			dir := yarnExecutable.ExecuteCall.Receives.Execution.Dir
+			Expect(dir).To(HavePrefix(os.TempDir()))
+			Expect(dir).NotTo(Equal(workingDir))
+			Expect(dir).NotTo(BeAnExistingFile())
+
+			dependency := dependencyManager.GenerateBillOfMaterialsCall.Receives.Dependencies[0]
+			Expect(dependency.ID).To(Equal("yarn"))
+			Expect(dependency.Version).To(Equal("1.22.22"))
+			Expect(dependency.Checksum).To(HavePrefix("sha256:"))
+			Expect(dependency.URI).To(Equal(fmt.Sprintf("file://%s", filepath.Join(binDir, "yarn"))))
+
+			Expect(sbomGenerator.GenerateFromDependencyCall.Receives.Dependency).To(Equal(dependency))
+			Expect(sbomGenerator.GenerateFromDependencyCall.Receives.Dir).To(Equal(binDir))
+
+			Expect(result.Build.BOM).To(HaveLen(1))
+			Expect(result.Build.SBOM).NotTo(BeNil())
+			Expect(result.Launch.BOM).To(HaveLen(1))
+			Expect(result.Launch.SBOM).NotTo(BeNil())
+
+			Expect(buffer.String()).To(ContainSubstring("Using system Yarn"))
+			Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Found yarn 1.22.22 at %s", filepath.Join(binDir, "yarn"))))
 		})
-	})
 
-	context("failure cases", func() {
-		context("when the yarn layer cannot be retrieved", func() {
+		context("when the system yarn does not satisfy the version constraint", func() {
 			it.Before(func() {
-				err := os.WriteFile(filepath.Join(layersDir, "yarn.toml"), nil, 0000)
-				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(workingDir, "package.json"),
+					[]byte(`{"packageManager":"yarn@4.14.1"}`), os.ModePerm)).To(Succeed())
 			})
 
 			it("returns an error", func() {
 				_, err := build(buildContext)
-				Expect(err).To(MatchError(ContainSubstring("failed to parse layer content metadata")))
+				Expect(err).To(MatchError(fmt.Sprintf("system yarn 1.22.22 at %s does not satisfy the version constraint >=2.0.0", filepath.Join(binDir, "yarn"))))
 			})
 		})
 
-		context("when the dependency cannot be resolved", func() {
+		context("when there is no yarn on the PATH", func() {
 			it.Before(func() {
-				dependencyManager.ResolveCall.Returns.Error = errors.New("failed to resolve dependency")
+				Expect(os.Remove(filepath.Join(binDir, "yarn"))).To(Succeed())
 			})
 
 			it("returns an error", func() {
 				_, err := build(buildContext)
-				Expect(err).To(MatchError("failed to resolve dependency"))
+				Expect(err).To(MatchError(ContainSubstring("BP_YARN_USE_SYSTEM is set but no yarn was found on the PATH")))
 			})
 		})
 
-		context("when the layers directory cannot be written to", func() {
+		context("when the system yarn cannot be run", func() {
 			it.Before(func() {
-				Expect(os.Chmod(layersDir, 4444)).To(Succeed())
-			})
-
-			it.After(func() {
-				Expect(os.Chmod(layersDir, os.ModePerm)).To(Succeed())
+				yarnExecutable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+					_, _ = fmt.Fprintln(execution.Stderr, "node: not found")
+					return errors.New("exit status 127")
+				}
 			})
 
-			it("returns an error", func() {
+			it("returns an error with the captured output", func() {
 				_, err := build(buildContext)
-				Expect(err).To(MatchError(ContainSubstring("permission denied")))
+				Expect(err).To(MatchError("failed to run system yarn: exit status 127\nnode: not found\n"))
 			})
 		})
+	})
 
-		context("when the dependency cannot be installed", func() {
+	context("when BP_YARN_PROJECT_PATHS lists several projects", func() {
+		it.Before(func() {
+			for dir, packageManager := range map[string]string{
+				"legacy":   "yarn@1.22.22",
+				"frontend": "yarn@4.14.1",
+				"admin":    "yarn@4.14.1+sha512.abcdef",
+			} {
+				Expect(os.MkdirAll(filepath.Join(workingDir, dir, "src"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(workingDir, dir, "package.json"),
+					[]byte(fmt.Sprintf(`{"packageManager":%q}`, packageManager)), os.ModePerm)).To(Succeed())
+			}
+
+			Expect(os.Setenv("BP_YARN_PROJECT_PATHS", "legacy, frontend,admin")).To(Succeed())
+			Expect(os.Setenv("BP_YARN_SKIP_VERSION_CHECK", "true")).To(Succeed())
+
+			dependencyManager.ResolveCall.Stub = func(path, id, version, stack string) (postal.Dependency, error) {
+				return postal.Dependency{
+					ID:       id,
+					Checksum: fmt.Sprintf("sha256:%s-%s-sha", id, version),
+					Version:  version,
+				}, nil
+			}
+
+			// Each delivered yarn prints which dependency it belongs to so that
+			// the selector can be exercised.
+			dependencyManager.DeliverCall.Stub = func(dep postal.Dependency, cnbPath, layerPath, platformPath string) error {
+				Expect(writeBinFiles(layerPath, 0755, "yarn.js", "yarnpkg")).To(Succeed())
+				return os.WriteFile(filepath.Join(layerPath, "bin", "yarn"),
+					[]byte(fmt.Sprintf("#!/bin/sh\necho %s-%s \"$@\"\n", dep.ID, dep.Version)), 0755)
+			}
+
+			buildContext.Plan.Entries[0].Metadata = map[string]interface{}{
+				"build": true,
+			}
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_PROJECT_PATHS")).To(Succeed())
+			Expect(os.Unsetenv("BP_YARN_SKIP_VERSION_CHECK")).To(Succeed())
+		})
+
+		context("when the plan entry requires the dependency during the launch phase", func() {
 			it.Before(func() {
-				dependencyManager.DeliverCall.Returns.Error = errors.New("failed to install dependency")
+				buildContext.Plan.Entries[0].Metadata = map[string]interface{}{
+					"launch": true,
+				}
 			})
 
-			it("returns an error", func() {
-				_, err := build(buildContext)
-				Expect(err).To(MatchError("failed to install dependency"))
+			it("adds the exec.d helper to the selector layer", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				selector := result.Layers[2]
+				Expect(selector.Launch).To(BeTrue())
+				Expect(selector.ExecD).To(Equal([]string{filepath.Join(cnbDir, "bin", "yarn-runtime-env")}))
 			})
 		})
 
-		context("when generating the SBOM returns an error", func() {
+		it("installs the pinned versions that a single project only uses to select the flavor", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("berry"))
+			Expect(dependencyManager.ResolveCall.Receives.Version).To(Equal("4.14.1"))
+
+			Expect(os.Unsetenv("BP_YARN_PROJECT_PATHS")).To(Succeed())
+			Expect(os.Setenv("BP_YARN_PROJECT_PATH", "frontend")).To(Succeed())
+			defer func() { Expect(os.Unsetenv("BP_YARN_PROJECT_PATH")).To(Succeed()) }()
+
+			_, err = build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("berry"))
+			Expect(dependencyManager.ResolveCall.Receives.Version).To(Equal("default"))
+		})
+
+		it("installs each required version into its own layer behind a selector", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers).To(HaveLen(3))
+
+			classic := result.Layers[0]
+			Expect(classic.Name).To(Equal("yarn-1.22.22"))
+			Expect(classic.Build).To(BeTrue())
+			Expect(classic.Cache).To(BeTrue())
+			Expect(classic.Metadata).To(HaveKeyWithValue("dependency-version", "1.22.22"))
+			Expect(classic.SBOM).NotTo(BeNil())
+			Expect(filepath.Join(classic.Path, "dist", "bin", "yarn")).To(BeARegularFile())
+
+			berry := result.Layers[1]
+			Expect(berry.Name).To(Equal("berry-4.14.1"))
+			Expect(berry.Metadata).To(HaveKeyWithValue("dependency-id", "berry"))
+			Expect(berry.SBOM).NotTo(BeNil())
+
+			selector := result.Layers[2]
+			Expect(selector.Name).To(Equal("yarn"))
+			Expect(selector.Build).To(BeTrue())
+			Expect(selector.Cache).To(BeFalse())
+			Expect(selector.ExecD).To(BeEmpty())
+			Expect(selector.BuildEnv).To(Equal(packit.Environment{
+				"PAKETO_YARN_INFO.override": filepath.Join(selector.Path, "yarn.json"),
+			}))
+			Expect(yarnSettings(result.Layers)).To(BeEmpty())
+
+			content, err := os.ReadFile(filepath.Join(selector.Path, "yarn.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(MatchJSON(fmt.Sprintf(`{
+				"installs": [
+					{
+						"flavor": "classic",
+						"version": "1.22.22",
+						"dependency-id": "yarn",
+						"path": %q,
+						"projects": [%q]
+					},
+					{
+						"flavor": "berry",
+						"version": "4.14.1",
+						"dependency-id": "berry",
+						"path": %q,
+						"projects": [%q, %q]
+					}
+				]
+			}`,
+				filepath.Join(classic.Path, "dist"), filepath.Join(workingDir, "legacy"),
+				filepath.Join(berry.Path, "dist"), filepath.Join(workingDir, "frontend"), filepath.Join(workingDir, "admin"))))
+
+			Expect(dependencyManager.DeliverCall.CallCount).To(Equal(2))
+			Expect(dependencyManager.GenerateBillOfMaterialsCall.Receives.Dependencies).To(HaveLen(2))
+
+			for dir, expected := range map[string]string{
+				"legacy":       "yarn-1.22.22 --version",
+				"frontend/src": "berry-4.14.1 --version",
+				"admin":        "berry-4.14.1 --version",
+			} {
+				command := exec.Command(filepath.Join(selector.Path, "bin", "yarn"), "--version")
+				command.Dir = filepath.Join(workingDir, dir)
+				output, err := command.CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).To(Equal(expected + "\n"))
+			}
+
+			command := exec.Command(filepath.Join(selector.Path, "bin", "yarn"))
+			command.Dir = workingDir
+			output, err := command.CombinedOutput()
+			Expect(err).To(HaveOccurred())
+			Expect(string(output)).To(ContainSubstring(fmt.Sprintf("yarn: %s is not inside a Yarn project; configured projects:", workingDir)))
+
+			Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Yarn project %s uses berry-4.14.1", filepath.Join(workingDir, "frontend"))))
+		})
+
+		context("when a version layer was cached by a previous build", func() {
 			it.Before(func() {
-				buildContext.BuildpackInfo = packit.BuildpackInfo{SBOMFormats: []string{"random-format"}}
+				Expect(os.WriteFile(filepath.Join(layersDir, "berry-4.14.1.toml"), []byte(`[metadata]
+schema-version = 1
+dependency-id = "berry"
+dependency-version = "4.14.1"
+dependency-sha = "sha256:berry-4.14.1-sha"
+fixups = ["bin-layout", "licenses"]
+`), 0600)).To(Succeed())
 			})
 
-			it("returns an error", func() {
+			it("reuses it", func() {
 				_, err := build(buildContext)
-				Expect(err).To(MatchError("unsupported SBOM format: 'random-format'"))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+				Expect(dependencyManager.DeliverCall.Receives.Dependency.ID).To(Equal("yarn"))
+				Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Reusing cached layer %s", filepath.Join(layersDir, "berry-4.14.1"))))
 			})
 		})
 
-		context("when formatting the SBOM returns an error", func() {
-			it.Before(func() {
-				sbomGenerator.GenerateFromDependencyCall.Returns.Error = errors.New("failed to generate SBOM")
+		context("failure cases", func() {
+			context("when BP_YARN_PROJECT_PATH is also set", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_PROJECT_PATH", "legacy")).To(Succeed())
+				})
+
+				it.After(func() {
+					Expect(os.Unsetenv("BP_YARN_PROJECT_PATH")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("BP_YARN_PROJECT_PATH and BP_YARN_PROJECT_PATHS cannot both be set"))
+				})
 			})
 
-			it("returns an error", func() {
-				_, err := build(buildContext)
-				Expect(err).To(MatchError(ContainSubstring("failed to generate SBOM")))
+			context("when BP_YARN_USE_SYSTEM is also set", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_USE_SYSTEM", "true")).To(Succeed())
+				})
+
+				it.After(func() {
+					Expect(os.Unsetenv("BP_YARN_USE_SYSTEM")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("BP_YARN_USE_SYSTEM cannot be combined with BP_YARN_PROJECT_PATHS"))
+				})
 			})
-		})
 
-		context("when BP_DISABLE_SBOM is set incorrectly", func() {
-			it.Before(func() {
-				Expect(os.Setenv("BP_DISABLE_SBOM", "not-a-bool")).To(Succeed())
+			context("when BP_YARN_PROCESSES is also set", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_PROCESSES", "start")).To(Succeed())
+				})
+
+				it.After(func() {
+					Expect(os.Unsetenv("BP_YARN_PROCESSES")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("BP_YARN_PROCESSES cannot be combined with BP_YARN_PROJECT_PATHS"))
+				})
 			})
 
-			it.After(func() {
-				Expect(os.Unsetenv("BP_DISABLE_SBOM")).To(Succeed())
+			context("when a project path does not exist", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_PROJECT_PATHS", "legacy,backend")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError(ContainSubstring(`invalid BP_YARN_PROJECT_PATHS "backend"`)))
+				})
 			})
 
-			it("returns an error", func() {
-				_, err := build(buildContext)
-				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_DISABLE_SBOM")))
+			context("when a pinned version cannot be resolved", func() {
+				it.Before(func() {
+					dependencyManager.ResolveCall.Stub = nil
+					dependencyManager.ResolveCall.Returns.Error = errors.New("failed to satisfy \"berry\" dependency version constraint \"4.14.1\"")
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError(ContainSubstring("failed to satisfy")))
+				})
 			})
 		})
 	})
+
+	context("when BP_YARN_PROCESSES is set", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(filepath.Join(workingDir, "package.json"), []byte(`{
+				"scripts": {
+					"start": "node server.js",
+					"worker": "node worker.js",
+					"db:migrate": "knex migrate:latest"
+				}
+			}`), os.ModePerm)).To(Succeed())
+
+			Expect(os.Setenv("BP_YARN_PROCESSES", "worker, db:migrate")).To(Succeed())
+
+			buildContext.Plan.Entries[0].Metadata = map[string]interface{}{
+				"launch": true,
+			}
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_PROCESSES")).To(Succeed())
+		})
+
+		it("adds a process for each listed script with the first as the default", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Launch.Processes).To(Equal([]packit.Process{
+				{
+					Type:    "worker",
+					Command: "yarn",
+					Args:    []string{"run", "worker"},
+					Default: true,
+					Direct:  true,
+				},
+				{
+					Type:    "db-migrate",
+					Command: "yarn",
+					Args:    []string{"run", "db:migrate"},
+					Direct:  true,
+				},
+			}))
+
+			Expect(buffer.String()).To(ContainSubstring("Assigning launch processes:"))
+			Expect(buffer.String()).To(ContainSubstring("worker (default): yarn run worker"))
+		})
+
+		context("when the value is *", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_PROCESSES", "*")).To(Succeed())
+			})
+
+			it("adds a process for every script with start as the default", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				var types []string
+				for _, process := range result.Launch.Processes {
+					types = append(types, process.Type)
+					Expect(process.Default).To(Equal(process.Type == "start"))
+				}
+				Expect(types).To(Equal([]string{"db-migrate", "start", "worker"}))
+			})
+		})
+
+		context("when BP_YARN_PROJECT_PATH is set", func() {
+			it.Before(func() {
+				Expect(os.MkdirAll(filepath.Join(workingDir, "frontend"), os.ModePerm)).To(Succeed())
+				Expect(os.Rename(filepath.Join(workingDir, "package.json"), filepath.Join(workingDir, "frontend", "package.json"))).To(Succeed())
+
+				Expect(os.Setenv("BP_YARN_PROJECT_PATH", "frontend")).To(Succeed())
+				Expect(os.Setenv("BP_YARN_PROCESSES", "start")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_PROJECT_PATH")).To(Succeed())
+			})
+
+			it("runs the scripts in the project directory", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(result.Launch.Processes).To(Equal([]packit.Process{
+					{
+						Type:    "start",
+						Command: "yarn",
+						Args:    []string{"--cwd", filepath.Join(workingDir, "frontend"), "run", "start"},
+						Default: true,
+						Direct:  true,
+					},
+				}))
+			})
+
+			context("when the project uses Yarn Berry", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "frontend", "package.json"), []byte(`{
+						"packageManager": "yarn@4.14.1",
+						"scripts": {
+							"start": "node server.js"
+						}
+					}`), os.ModePerm)).To(Succeed())
+				})
+
+				it("runs the scripts with the project directory as the working directory", func() {
+					result, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(result.Launch.Processes).To(Equal([]packit.Process{
+						{
+							Type:             "start",
+							Command:          "yarn",
+							Args:             []string{"run", "start"},
+							Default:          true,
+							Direct:           true,
+							WorkingDirectory: filepath.Join(workingDir, "frontend"),
+						},
+					}))
+				})
+			})
+		})
+
+		context("when the plan entry does not require the dependency during the launch phase", func() {
+			it.Before(func() {
+				buildContext.Plan.Entries[0].Metadata = map[string]interface{}{
+					"build": true,
+				}
+			})
+
+			it("does not add any processes", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(result.Launch.Processes).To(BeEmpty())
+				Expect(buffer.String()).To(ContainSubstring("Skipping the processes in BP_YARN_PROCESSES: Yarn is not required at launch"))
+			})
+		})
+
+		context("failure cases", func() {
+			context("when a listed script is not defined", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_PROCESSES", "start,serve")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError(fmt.Sprintf("BP_YARN_PROCESSES lists script serve, which is not defined in the scripts of %s", filepath.Join(workingDir, "package.json"))))
+				})
+			})
+
+			context("when two scripts become the same process type", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "package.json"),
+						[]byte(`{"scripts": {"db:migrate": "knex migrate:latest", "db-migrate": "knex migrate:latest"}}`), os.ModePerm)).To(Succeed())
+					Expect(os.Setenv("BP_YARN_PROCESSES", "*")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("BP_YARN_PROCESSES selects scripts db-migrate and db:migrate, which both become process type db-migrate"))
+				})
+			})
+
+			context("when the project has no package.json", func() {
+				it.Before(func() {
+					Expect(os.Remove(filepath.Join(workingDir, "package.json"))).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("BP_YARN_PROCESSES is set, but the project has no package.json"))
+				})
+			})
+		})
+	})
+
+	context("when BP_YARN_GLOBAL_PACKAGES is set", func() {
+		var installs []pexec.Execution
+
+		it.Before(func() {
+			installs = nil
+			yarnExecutable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+				if execution.Args[0] != "install" {
+					_, err := fmt.Fprintln(execution.Stdout, dependencyManager.ResolveCall.Returns.Dependency.Version)
+					return err
+				}
+				installs = append(installs, execution)
+
+				for name, content := range map[string]string{
+					"serve":           `{"name": "serve", "bin": {"serve": "build/main.js"}}`,
+					"@example/tool":   `{"name": "@example/tool", "bin": "cli.js"}`,
+					"serve-handler":   `{"name": "serve-handler", "bin": {"serve-handler": "index.js"}}`,
+					"@example/no-bin": `{"name": "@example/no-bin"}`,
+				} {
+					dir := filepath.Join(execution.Dir, "node_modules", name)
+					Expect(os.MkdirAll(filepath.Join(dir, "build"), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(dir, "package.json"), []byte(content), 0644)).To(Succeed())
+					for _, file := range []string{"build/main.js", "cli.js", "index.js"} {
+						Expect(os.WriteFile(filepath.Join(dir, file), []byte("#!/usr/bin/env node\n"), 0644)).To(Succeed())
+					}
+				}
+
+				return nil
+			}
+
+			Expect(os.Setenv("BP_YARN_GLOBAL_PACKAGES", "serve@14.2.3, @example/tool@1.0.0,@example/no-bin@2.0.0")).To(Succeed())
+
+			buildContext.Plan.Entries[0].Metadata = map[string]interface{}{
+				"launch": true,
+			}
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_GLOBAL_PACKAGES")).To(Succeed())
+		})
+
+		it("installs the packages into a layer of their own", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers).To(HaveLen(2))
+			layer := result.Layers[1]
+			Expect(layer.Name).To(Equal("yarn-global"))
+			Expect(layer.Path).To(Equal(filepath.Join(layersDir, "yarn-global")))
+			Expect(layer.Launch).To(BeTrue())
+			Expect(layer.Build).To(BeFalse())
+			Expect(layer.Metadata).To(HaveKeyWithValue(yarn.GlobalPackagesCacheKey, MatchRegexp(`^[0-9a-f]{64}$`)))
+			Expect(layer.SBOM).NotTo(BeNil())
+
+			content, err := os.ReadFile(filepath.Join(layer.Path, "package.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(MatchJSON(`{
+				"name": "yarn-global-packages",
+				"private": true,
+				"dependencies": {
+					"serve": "14.2.3",
+					"@example/tool": "1.0.0",
+					"@example/no-bin": "2.0.0"
+				}
+			}`))
+
+			Expect(installs).To(HaveLen(1))
+			Expect(installs[0].Args).To(Equal([]string{"install", "--non-interactive", "--no-progress"}))
+			Expect(installs[0].Dir).To(Equal(layer.Path))
+			Expect(installs[0].Env).To(ContainElement(HavePrefix(fmt.Sprintf("PATH=%s", filepath.Join(layersDir, "yarn", "bin")))))
+			Expect(installs[0].Env).To(ContainElement(HavePrefix("YARN_CACHE_FOLDER=")))
+
+			Expect(os.Readlink(filepath.Join(layer.Path, "bin", "serve"))).To(Equal(filepath.Join("..", "node_modules", "serve", "build", "main.js")))
+			Expect(os.Readlink(filepath.Join(layer.Path, "bin", "tool"))).To(Equal(filepath.Join("..", "node_modules", "@example", "tool", "cli.js")))
+			Expect(filepath.Join(layer.Path, "bin", "serve-handler")).NotTo(BeAnExistingFile())
+
+			info, err := os.Stat(filepath.Join(layer.Path, "bin", "serve"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+
+			Expect(sbomGenerator.GenerateCall.Receives.Dir).To(Equal(layer.Path))
+
+			Expect(buffer.String()).To(ContainSubstring("Installing global packages"))
+			Expect(buffer.String()).To(ContainSubstring("@example/tool@1.0.0"))
+			Expect(buffer.String()).To(ContainSubstring("Linked serve"))
+		})
+
+		context("when the layer was built with the same packages and Yarn", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_GLOBAL_PACKAGES", "@example/tool@1.0.0 serve@14.2.3 @example/no-bin@2.0.0")).To(Succeed())
+
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				metadata := bytes.NewBuffer([]byte("[metadata]\n"))
+				Expect(toml.NewEncoder(metadata).Encode(result.Layers[1].Metadata)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(layersDir, "yarn-global.toml"), metadata.Bytes(), 0644)).To(Succeed())
+
+				installs = nil
+			})
+
+			it("reuses it, whatever the order of the packages", func() {
+				Expect(os.Setenv("BP_YARN_GLOBAL_PACKAGES", "serve@14.2.3,@example/tool@1.0.0,@example/no-bin@2.0.0")).To(Succeed())
+
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installs).To(BeEmpty())
+				Expect(result.Layers[1].Launch).To(BeTrue())
+				Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Reusing cached layer %s", filepath.Join(layersDir, "yarn-global"))))
+			})
+
+			it("reinstalls the packages when the Yarn version changes", func() {
+				dependencyManager.ResolveCall.Returns.Dependency.Version = "other-yarn-version"
+				dependencyManager.ResolveCall.Returns.Dependency.Checksum = "sha256:other-yarn-sha"
+
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installs).To(HaveLen(1))
+			})
+
+			it("reinstalls the packages when the registry changes", func() {
+				Expect(os.Setenv("BP_YARN_GLOBAL_REGISTRY", "http://localhost:4873")).To(Succeed())
+				defer func() { Expect(os.Unsetenv("BP_YARN_GLOBAL_REGISTRY")).To(Succeed()) }()
+
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installs).To(HaveLen(1))
+			})
+		})
+
+		context("when BP_YARN_GLOBAL_REGISTRY is set", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_GLOBAL_REGISTRY", "http://localhost:4873")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_GLOBAL_REGISTRY")).To(Succeed())
+			})
+
+			it("installs the packages from it", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installs).To(HaveLen(1))
+				Expect(installs[0].Env).To(ContainElements(
+					"YARN_REGISTRY=http://localhost:4873",
+					"npm_config_registry=http://localhost:4873",
+				))
+				Expect(installs[0].Env).NotTo(ContainElement(HavePrefix("YARN_NPM_REGISTRY_SERVER=")))
+			})
+
+			context("when the dependency is Yarn Berry", func() {
+				it.Before(func() {
+					dependencyManager.ResolveCall.Returns.Dependency.ID = "berry"
+				})
+
+				it("configures Yarn Berry to install the packages from it into node_modules", func() {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(installs).To(HaveLen(1))
+					Expect(installs[0].Args).To(Equal([]string{"install"}))
+					Expect(installs[0].Env).To(ContainElements(
+						"YARN_NODE_LINKER=node-modules",
+						"YARN_ENABLE_IMMUTABLE_INSTALLS=false",
+						"YARN_NPM_REGISTRY_SERVER=http://localhost:4873",
+						"YARN_UNSAFE_HTTP_WHITELIST=localhost",
+					))
+					Expect(installs[0].Env).NotTo(ContainElement(HavePrefix("YARN_REGISTRY=")))
+					Expect(installs[0].Env).NotTo(ContainElement(HavePrefix("npm_config_registry=")))
+				})
+			})
+
+			it("keys the layer on the registry for both Yarn Classic and Yarn Berry", func() {
+				for _, id := range []string{"yarn", "berry"} {
+					dependencyManager.ResolveCall.Returns.Dependency.ID = id
+
+					keys := map[string]interface{}{}
+					for _, registry := range []string{"", "http://localhost:4873", "https://registry.example.com"} {
+						Expect(os.Setenv("BP_YARN_GLOBAL_REGISTRY", registry)).To(Succeed())
+
+						installs = nil
+						result, err := build(buildContext)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(installs).To(HaveLen(1), fmt.Sprintf("%s with registry %q", id, registry))
+
+						key := result.Layers[1].Metadata[yarn.GlobalPackagesCacheKey]
+						Expect(keys).NotTo(ContainElement(key), fmt.Sprintf("%s with registry %q", id, registry))
+						keys[registry] = key
+
+						metadata := bytes.NewBuffer([]byte("[metadata]\n"))
+						Expect(toml.NewEncoder(metadata).Encode(result.Layers[1].Metadata)).To(Succeed())
+						Expect(os.WriteFile(filepath.Join(layersDir, "yarn-global.toml"), metadata.Bytes(), 0644)).To(Succeed())
+
+						installs = nil
+						_, err = build(buildContext)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(installs).To(BeEmpty(), fmt.Sprintf("%s with registry %q", id, registry))
+					}
+				}
+			})
+		})
+
+		context("when BP_YARN_USE_SYSTEM is true", func() {
+			var (
+				binDir string
+				path   string
+			)
+
+			it.Before(func() {
+				var err error
+				binDir, err = os.MkdirTemp("", "bin")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(binDir, "yarn"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+				path = os.Getenv("PATH")
+				Expect(os.Setenv("PATH", binDir)).To(Succeed())
+				Expect(os.Setenv("BP_YARN_USE_SYSTEM", "true")).To(Succeed())
+
+				dependencyManager.ResolveCall.Returns.Dependency.Version = "1.22.22"
+			})
+
+			it.After(func() {
+				Expect(os.Setenv("PATH", path)).To(Succeed())
+				Expect(os.Unsetenv("BP_YARN_USE_SYSTEM")).To(Succeed())
+				Expect(os.RemoveAll(binDir)).To(Succeed())
+			})
+
+			it("installs the packages with the system yarn", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(result.Layers).To(HaveLen(1))
+				Expect(result.Layers[0].Name).To(Equal("yarn-global"))
+				Expect(result.Layers[0].Launch).To(BeTrue())
+
+				Expect(installs).To(HaveLen(1))
+				Expect(installs[0].Env).To(ContainElement(HavePrefix(fmt.Sprintf("PATH=%s", binDir))))
+			})
+		})
+
+		context("failure cases", func() {
+			context("when an entry has no version", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_GLOBAL_PACKAGES", "serve,@example/tool@1.0.0")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("failed to parse BP_YARN_GLOBAL_PACKAGES entry serve: must be name@version"))
+				})
+			})
+
+			context("when a package is listed twice", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_GLOBAL_PACKAGES", "serve@14.2.3,serve@14.2.4")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("failed to parse BP_YARN_GLOBAL_PACKAGES: package serve is listed more than once"))
+				})
+			})
+
+			context("when BP_YARN_GLOBAL_REGISTRY is not a URL", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_GLOBAL_REGISTRY", "localhost:4873")).To(Succeed())
+				})
+
+				it.After(func() {
+					Expect(os.Unsetenv("BP_YARN_GLOBAL_REGISTRY")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("failed to parse BP_YARN_GLOBAL_REGISTRY value localhost:4873: must be an http or https URL"))
+				})
+			})
+
+			context("when BP_YARN_PROJECT_PATHS is also set", func() {
+				it.Before(func() {
+					Expect(os.MkdirAll(filepath.Join(workingDir, "frontend"), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(workingDir, "frontend", "package.json"), []byte(`{}`), 0644)).To(Succeed())
+					Expect(os.Setenv("BP_YARN_PROJECT_PATHS", "frontend")).To(Succeed())
+				})
+
+				it.After(func() {
+					Expect(os.Unsetenv("BP_YARN_PROJECT_PATHS")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("BP_YARN_GLOBAL_PACKAGES cannot be combined with BP_YARN_PROJECT_PATHS"))
+				})
+			})
+
+			context("when the install fails", func() {
+				it.Before(func() {
+					yarnExecutable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+						if execution.Args[0] != "install" {
+							_, err := fmt.Fprintln(execution.Stdout, dependencyManager.ResolveCall.Returns.Dependency.Version)
+							return err
+						}
+
+						_, _ = fmt.Fprintln(execution.Stderr, "Couldn't find package \"serve\" on the \"npm\" registry.")
+						return errors.New("exit status 1")
+					}
+				})
+
+				it("returns an error with the captured output", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("failed to install global packages: exit status 1\nCouldn't find package \"serve\" on the \"npm\" registry.\n"))
+				})
+			})
+
+			context("when two packages provide the same executable", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_GLOBAL_PACKAGES", "serve-handler@6.1.5,@example/tool@1.0.0,serve@14.2.3")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					stub := yarnExecutable.ExecuteCall.Stub
+					yarnExecutable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+						err := stub(execution)
+						if execution.Args[0] == "install" {
+							Expect(os.WriteFile(filepath.Join(execution.Dir, "node_modules", "serve-handler", "package.json"),
+								[]byte(`{"bin": {"serve": "index.js"}}`), 0644)).To(Succeed())
+						}
+						return err
+					}
+
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("global packages serve-handler and serve both provide the executable serve"))
+				})
+			})
+
+			context("when an executable points outside of its package", func() {
+				it("returns an error", func() {
+					stub := yarnExecutable.ExecuteCall.Stub
+					yarnExecutable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+						err := stub(execution)
+						if execution.Args[0] == "install" {
+							Expect(os.WriteFile(filepath.Join(execution.Dir, "node_modules", "serve", "package.json"),
+								[]byte(`{"bin": {"serve": "../../../yarn/bin/yarn"}}`), 0644)).To(Succeed())
+						}
+						return err
+					}
+
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("executable serve of global package serve points outside of the package: ../../../yarn/bin/yarn"))
+					Expect(filepath.Join(layersDir, "yarn-global", "bin", "serve")).NotTo(BeAnExistingFile())
+				})
+			})
+
+			context("when an executable name is a path", func() {
+				it("returns an error", func() {
+					stub := yarnExecutable.ExecuteCall.Stub
+					yarnExecutable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+						err := stub(execution)
+						if execution.Args[0] == "install" {
+							Expect(os.WriteFile(filepath.Join(execution.Dir, "node_modules", "serve", "package.json"),
+								[]byte(`{"bin": {"../serve": "build/main.js"}}`), 0644)).To(Succeed())
+						}
+						return err
+					}
+
+					_, err := build(buildContext)
+					Expect(err).To(MatchError(`global package serve declares the invalid executable name "../serve"`))
+				})
+			})
+
+			context("when a package was not installed", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_GLOBAL_PACKAGES", "missing@1.0.0")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError(fmt.Sprintf("global package missing was not installed to %s", filepath.Join(layersDir, "yarn-global", "node_modules", "missing"))))
+				})
+			})
+		})
+	})
+
+	context("when checking Node.js compatibility", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(filepath.Join(cnbDir, "buildpack.toml"), []byte(`
+[[metadata.dependencies]]
+  id = "berry"
+  version = "4.14.1"
+
+[[metadata.dependencies]]
+  id = "berry"
+  version = "4.14.1"
+
+[[metadata.dependencies]]
+  id = "berry"
+  version = "3.8.7"
+
+[[metadata.node-engines]]
+  id = "berry"
+  node = ">=18.12.0"
+  version = "4.14.1"
+
+[[metadata.node-engines]]
+  id = "berry"
+  node = ">=14.15.0"
+  version = "3.8.7"
+`), 0600)).To(Succeed())
+
+			Expect(os.WriteFile(filepath.Join(workingDir, "package.json"),
+				[]byte(`{"packageManager":"yarn@4.14.1"}`), os.ModePerm)).To(Succeed())
+
+			dependencyManager.ResolveCall.Returns.Dependency = postal.Dependency{
+				ID:       "berry",
+				Checksum: "sha256:berry-dependency-sha",
+				Version:  "4.14.1",
+			}
+
+			Expect(os.Setenv("BP_YARN_SKIP_VERSION_CHECK", "true")).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_SKIP_VERSION_CHECK")).To(Succeed())
+		})
+
+		it("installs the dependency when node satisfies its engines.node range", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(node.ExecuteCall.Receives.Execution.Args).To(Equal([]string{"--version"}))
+			Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+			Expect(buffer.String()).To(ContainSubstring("Node.js 20.11.0 satisfies berry 4.14.1 (engines.node >=18.12.0)"))
+		})
+
+		context("when NODE_HOME is set", func() {
+			it.Before(func() {
+				Expect(os.Setenv("NODE_HOME", "/some/node-home")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("NODE_HOME")).To(Succeed())
+			})
+
+			it("runs node from NODE_HOME", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(node.ExecuteCall.Receives.Execution.Env).To(ContainElement(HavePrefix("PATH=/some/node-home/bin")))
+			})
+		})
+
+		context("when the build plan records the node version", func() {
+			it.Before(func() {
+				buildContext.Plan.Entries[0].Metadata = map[string]interface{}{
+					"node-version": "v16.20.2",
+				}
+			})
+
+			it("uses it instead of running node", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("but Node.js 16.20.2 is installed")))
+
+				Expect(node.ExecuteCall.CallCount).To(Equal(0))
+			})
+		})
+
+		context("when buildpack.toml records no range for the version", func() {
+			it.Before(func() {
+				dependencyManager.ResolveCall.Returns.Dependency.Version = "4.15.0"
+			})
+
+			it("logs that the check is skipped", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(node.ExecuteCall.CallCount).To(Equal(0))
+				Expect(buffer.String()).To(ContainSubstring("Skipping: buildpack.toml records no engines.node range for berry 4.15.0"))
+			})
+		})
+
+		context("when node does not satisfy the engines.node range", func() {
+			it.Before(func() {
+				node.ExecuteCall.Stub = func(execution pexec.Execution) error {
+					_, err := fmt.Fprintln(execution.Stdout, "v16.20.2")
+					return err
+				}
+			})
+
+			it("fails the build and logs the compatibility matrix", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("berry 4.14.1 requires Node.js >=18.12.0, but Node.js 16.20.2 is installed: use a compatible Node.js version or set BP_YARN_NODE_COMPATIBILITY=warn"))
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(0))
+				Expect(buffer.String()).To(ContainSubstring("Node.js compatibility of available berry versions:"))
+				Expect(buffer.String()).To(MatchRegexp(`4\.14\.1\s+>=18\.12\.0\s+incompatible`))
+				Expect(buffer.String()).To(MatchRegexp(`3\.8\.7\s+>=14\.15\.0\s+compatible`))
+			})
+
+			context("when BP_YARN_NODE_COMPATIBILITY is warn", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_NODE_COMPATIBILITY", "warn")).To(Succeed())
+				})
+
+				it.After(func() {
+					Expect(os.Unsetenv("BP_YARN_NODE_COMPATIBILITY")).To(Succeed())
+				})
+
+				it("logs the compatibility matrix and installs the dependency", func() {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+					Expect(buffer.String()).To(ContainSubstring("Node.js 16.20.2 does not satisfy berry 4.14.1 (engines.node >=18.12.0)"))
+				})
+			})
+
+			context("when BP_YARN_NODE_COMPATIBILITY is off", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_NODE_COMPATIBILITY", "off")).To(Succeed())
+				})
+
+				it.After(func() {
+					Expect(os.Unsetenv("BP_YARN_NODE_COMPATIBILITY")).To(Succeed())
+				})
+
+				it("does not run the check", func() {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(node.ExecuteCall.CallCount).To(Equal(0))
+				})
+			})
+		})
+
+		context("when node is not on the PATH", func() {
+			it.Before(func() {
+				node.ExecuteCall.Stub = nil
+				node.ExecuteCall.Returns.Error = &exec.Error{Name: "node", Err: exec.ErrNotFound}
+			})
+
+			it("skips the check", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(buffer.String()).To(ContainSubstring("Checking Node.js compatibility"))
+				Expect(buffer.String()).To(ContainSubstring("Skipping: node is not available on the PATH"))
+			})
+		})
+	})
+
+	context("when BP_YARN_ADVISORY_POLICY is set", func() {
+		var advisoriesDir string
+
+		it.Before(func() {
+			var err error
+			advisoriesDir, err = os.MkdirTemp("", "advisories")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.WriteFile(filepath.Join(advisoriesDir, "GHSA-aaaa.json"), []byte(`{
+  "id": "GHSA-aaaa",
+  "aliases": ["CVE-2021-0001"],
+  "summary": "some yarn advisory",
+  "affected": [{
+    "package": {"ecosystem": "npm", "name": "yarn"},
+    "ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "1.22.20"}]}]
+  }]
+}`), 0600)).To(Succeed())
+
+			Expect(os.MkdirAll(filepath.Join(advisoriesDir, "npm"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(advisoriesDir, "npm", "more.json"), []byte(`[
+  {
+    "id": "GHSA-bbbb",
+    "summary": "some fixed advisory",
+    "affected": [{
+      "package": {"ecosystem": "npm", "name": "yarn"},
+      "ranges": [{"type": "SEMVER", "events": [{"introduced": "1.0.0"}, {"last_affected": "1.22.10"}]}]
+    }]
+  },
+  {
+    "id": "GHSA-cccc",
+    "summary": "some withdrawn advisory",
+    "withdrawn": "2024-01-01T00:00:00Z",
+    "affected": [{"package": {"ecosystem": "npm", "name": "yarn"}, "versions": ["1.22.19"]}]
+  },
+  {
+    "id": "GHSA-dddd",
+    "summary": "some berry advisory",
+    "affected": [{"package": {"ecosystem": "npm", "name": "@yarnpkg/cli"}, "versions": ["1.22.19"]}]
+  }
+]`), 0600)).To(Succeed())
+
+			dependencyManager.ResolveCall.Returns.Dependency.Version = "1.22.19"
+
+			Expect(os.Setenv("BP_YARN_ADVISORY_POLICY", "fail")).To(Succeed())
+			Expect(os.Setenv("BP_YARN_ADVISORY_DB", advisoriesDir)).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_ADVISORY_POLICY")).To(Succeed())
+			Expect(os.Unsetenv("BP_YARN_ADVISORY_DB")).To(Succeed())
+			Expect(os.RemoveAll(advisoriesDir)).To(Succeed())
+		})
+
+		it("fails the build when the resolved version is affected", func() {
+			_, err := build(buildContext)
+			Expect(err).To(MatchError("yarn 1.22.19 is affected by advisories GHSA-aaaa: use a fixed version, allow them with BP_YARN_ADVISORY_ALLOWLIST or set BP_YARN_ADVISORY_POLICY=warn"))
+
+			Expect(dependencyManager.DeliverCall.CallCount).To(Equal(0))
+			Expect(buffer.String()).To(ContainSubstring("Checking advisories for yarn 1.22.19"))
+			Expect(buffer.String()).To(ContainSubstring("GHSA-aaaa: some yarn advisory"))
+			Expect(buffer.String()).NotTo(ContainSubstring("GHSA-bbbb"))
+			Expect(buffer.String()).NotTo(ContainSubstring("GHSA-cccc"))
+			Expect(buffer.String()).NotTo(ContainSubstring("GHSA-dddd"))
+		})
+
+		context("when the resolved version is fixed", func() {
+			it.Before(func() {
+				dependencyManager.ResolveCall.Returns.Dependency.Version = "1.22.20"
+			})
+
+			it("installs the dependency", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("No advisories found"))
+			})
+		})
+
+		context("when the events of a range share a version", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(advisoriesDir, "GHSA-eeee.json"), []byte(`{
+  "id": "GHSA-eeee",
+  "summary": "some withdrawn range",
+  "affected": [{
+    "package": {"ecosystem": "npm", "name": "yarn"},
+    "ranges": [{"type": "SEMVER", "events": [{"introduced": "1.22.20", "fixed": "1.22.20"}]}]
+  }]
+}`), 0600)).To(Succeed())
+
+				dependencyManager.ResolveCall.Returns.Dependency.Version = "1.22.20"
+			})
+
+			it("evaluates introduced before fixed on every run", func() {
+				for range 20 {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				Expect(buffer.String()).NotTo(ContainSubstring("GHSA-eeee"))
+			})
+		})
+
+		context("when the advisory is allowlisted by an alias", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_ADVISORY_ALLOWLIST", "GHSA-zzzz, CVE-2021-0001")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_ADVISORY_ALLOWLIST")).To(Succeed())
+			})
+
+			it("installs the dependency", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("GHSA-aaaa (allowed): some yarn advisory"))
+			})
+		})
+
+		context("when the policy is warn", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_ADVISORY_POLICY", "warn")).To(Succeed())
+			})
+
+			it("logs the advisories and installs the dependency", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("GHSA-aaaa: some yarn advisory"))
+			})
+		})
+
+		context("when the policy is off", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_ADVISORY_POLICY", "off")).To(Succeed())
+			})
+
+			it("does not run the check", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(buffer.String()).NotTo(ContainSubstring("Checking advisories"))
+			})
+		})
+
+		context("when the advisories come from a binding", func() {
+			var platformDir string
+
+			it.Before(func() {
+				var err error
+				platformDir, err = os.MkdirTemp("", "platform")
+				Expect(err).NotTo(HaveOccurred())
+
+				bindingDir := filepath.Join(platformDir, "bindings", "advisories")
+				Expect(os.MkdirAll(bindingDir, os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(bindingDir, "type"), []byte("osv-advisories"), 0600)).To(Succeed())
+
+				content, err := os.ReadFile(filepath.Join(advisoriesDir, "GHSA-aaaa.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(bindingDir, "yarn.json"), content, 0600)).To(Succeed())
+
+				buildContext.Platform.Path = platformDir
+				Expect(os.Unsetenv("BP_YARN_ADVISORY_DB")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(platformDir)).To(Succeed())
+			})
+
+			it("checks the resolved version against them", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("yarn 1.22.19 is affected by advisories GHSA-aaaa")))
+			})
+		})
+
+		context("when BP_YARN_USE_SYSTEM is true", func() {
+			var (
+				binDir string
+				path   string
+			)
+
+			it.Before(func() {
+				var err error
+				binDir, err = os.MkdirTemp("", "bin")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(binDir, "yarn"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+				path = os.Getenv("PATH")
+				Expect(os.Setenv("PATH", binDir)).To(Succeed())
+				Expect(os.Setenv("BP_YARN_USE_SYSTEM", "true")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Setenv("PATH", path)).To(Succeed())
+				Expect(os.Unsetenv("BP_YARN_USE_SYSTEM")).To(Succeed())
+				Expect(os.RemoveAll(binDir)).To(Succeed())
+			})
+
+			it("checks the system version", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("yarn 1.22.19 is affected by advisories GHSA-aaaa")))
+			})
+		})
+
+		context("when no advisory database is given", func() {
+			it.Before(func() {
+				Expect(os.Unsetenv("BP_YARN_ADVISORY_DB")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("BP_YARN_ADVISORY_POLICY is fail but no advisory database was given: set BP_YARN_ADVISORY_DB or add an osv-advisories binding"))
+			})
+		})
+
+		context("when the advisory database cannot be parsed", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(advisoriesDir, "broken.json"), []byte("%%%"), 0600)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse advisories in")))
+			})
+		})
+	})
+
+	context("when the dependency declares licenses", func() {
+		it.Before(func() {
+			dependencyManager.ResolveCall.Returns.Dependency.Licenses = []string{"BSD-2-Clause"}
+
+			Expect(os.MkdirAll(filepath.Join(cnbDir, "licenses", "yarn"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(cnbDir, "licenses", "yarn", "LICENSE.md"), []byte("some-license-text"), 0644)).To(Succeed())
+		})
+
+		it("bundles the license files of the buildpack into the layer", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			content, err := os.ReadFile(filepath.Join(result.Layers[0].Path, "licenses", "LICENSE.md"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("some-license-text"))
+
+			Expect(sbomGenerator.GenerateFromDependencyCall.Receives.Dir).To(Equal(result.Layers[0].Path))
+		})
+
+		for _, mode := range []string{"dependency", "scan", "both"} {
+			context("when BP_YARN_SBOM_MODE is "+mode, func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_SBOM_MODE", mode)).To(Succeed())
+
+					var err error
+					sbomGenerator.GenerateFromDependencyCall.Returns.SBOM, err = sbom.GenerateFromDependency(dependencyManager.ResolveCall.Returns.Dependency, filepath.Join(layersDir, "yarn"))
+					Expect(err).NotTo(HaveOccurred())
+
+					// A scan finds the package.json of the npm package Yarn is
+					// published as.
+					sbomGenerator.GenerateCall.Returns.SBOM, err = sbom.GenerateFromDependency(postal.Dependency{
+						Name:    "yarn",
+						Version: "yarn-dependency-version",
+						PURL:    "pkg:npm/yarn@yarn-dependency-version",
+					}, filepath.Join(layersDir, "yarn"))
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				it.After(func() {
+					Expect(os.Unsetenv("BP_YARN_SBOM_MODE")).To(Succeed())
+				})
+
+				it("references the bundled license files from the SBOM", func() {
+					result, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					licenseFile := filepath.Join(result.Layers[0].Path, "licenses", "LICENSE.md")
+
+					formats := result.Layers[0].SBOM.Formats()
+					Expect(formats).To(HaveLen(2))
+					for _, format := range formats {
+						content, err := io.ReadAll(format.Content)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(string(content)).To(ContainSubstring(licenseFile), format.Extension)
+					}
+				})
+			})
+		}
+
+		context("when the tarball ships a license file", func() {
+			it.Before(func() {
+				dependencyManager.DeliverCall.Stub = func(dep postal.Dependency, cnbPath, layerPath, platformPath string) error {
+					err := os.WriteFile(filepath.Join(layerPath, "LICENSE"), []byte("shipped-license-text"), 0644)
+					if err != nil {
+						return err
+					}
+					return writeBinFiles(layerPath, 0755, "yarn", "yarn.js", "yarnpkg")
+				}
+			})
+
+			it("keeps it and bundles nothing", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(result.Layers[0].Path, "LICENSE"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("shipped-license-text"))
+				Expect(filepath.Join(result.Layers[0].Path, "licenses")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		context("when a cached layer was built without them", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(layersDir, "yarn.toml"), []byte(`[metadata]
+schema-version = 1
+dependency-id = "yarn"
+dependency-version = "yarn-dependency-version"
+dependency-sha = "sha256:yarn-dependency-sha"
+fixups = ["bin-layout"]
+`), 0600)).To(Succeed())
+
+				Expect(writeBinFiles(filepath.Join(layersDir, "yarn"), 0755, "yarn", "yarn.js", "yarnpkg")).To(Succeed())
+			})
+
+			it("adds them to the cached layer", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(0))
+				Expect(filepath.Join(layersDir, "yarn", "licenses", "LICENSE.md")).To(BeARegularFile())
+				Expect(result.Layers[0].Metadata).To(HaveKeyWithValue("fixups", []string{yarn.FixupBinLayout, yarn.FixupLicenses}))
+			})
+		})
+
+		context("when neither the tarball nor the buildpack ships a license file", func() {
+			it.Before(func() {
+				Expect(os.RemoveAll(filepath.Join(cnbDir, "licenses"))).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to prepare yarn layer: neither yarn yarn-dependency-version nor the buildpack ships a license file for it"))
+			})
+		})
+	})
+
+	context("when the same app is built twice", func() {
+		type entry struct {
+			Mode    os.FileMode
+			ModTime time.Time
+			Content string
+		}
+
+		snapshot := func(result packit.BuildResult) (map[string]entry, string) {
+			entries := map[string]entry{}
+			err := filepath.Walk(result.Layers[0].Path, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+
+				rel, err := filepath.Rel(result.Layers[0].Path, path)
+				if err != nil {
+					return err
+				}
+
+				e := entry{Mode: info.Mode(), ModTime: info.ModTime().UTC()}
+				switch {
+				case info.Mode()&os.ModeSymlink != 0:
+					e.Content, err = os.Readlink(path)
+				case info.Mode().IsRegular():
+					var content []byte
+					content, err = os.ReadFile(path)
+					e.Content = string(content)
+				}
+				entries[rel] = e
+
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			metadata := bytes.NewBuffer(nil)
+			Expect(toml.NewEncoder(metadata).Encode(result.Layers[0].Metadata)).To(Succeed())
+
+			return entries, metadata.String()
+		}
+
+		deliver := func(mode os.FileMode, modTime time.Time, names ...string) {
+			dependencyManager.DeliverCall.Stub = func(dep postal.Dependency, cnbPath, layerPath, platformPath string) error {
+				err := writeBinFiles(layerPath, mode|0100, names...)
+				if err != nil {
+					return err
+				}
+
+				Expect(os.MkdirAll(filepath.Join(layerPath, "lib"), 0700)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(layerPath, "lib", "cli.js"), []byte("cli"), mode)).To(Succeed())
+				Expect(os.Symlink("cli.js", filepath.Join(layerPath, "lib", "current.js"))).To(Succeed())
+
+				return filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+					if err != nil || info.Mode()&os.ModeSymlink != 0 {
+						return err
+					}
+					return os.Chtimes(path, modTime, modTime)
+				})
+			}
+		}
+
+		it.Before(func() {
+			buildContext.Plan.Entries[0].Metadata = map[string]interface{}{
+				"launch": true,
+			}
+		})
+
+		it("produces identical layers", func() {
+			deliver(0600, time.Now(), "yarn", "yarn.js", "yarnpkg")
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			first, firstMetadata := snapshot(result)
+
+			Expect(os.RemoveAll(layersDir)).To(Succeed())
+			Expect(os.MkdirAll(layersDir, os.ModePerm)).To(Succeed())
+
+			deliver(0664, time.Now().Add(time.Hour), "yarnpkg", "yarn.js", "yarn")
+			result, err = build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			second, secondMetadata := snapshot(result)
+
+			Expect(second).To(Equal(first))
+			Expect(secondMetadata).To(Equal(firstMetadata))
+
+			Expect(first["bin/yarn"].Mode).To(Equal(os.FileMode(0755)))
+			Expect(first["lib/cli.js"].Mode).To(Equal(os.FileMode(0644)))
+			Expect(first["lib"].Mode).To(Equal(os.ModeDir | 0755))
+			Expect(first["lib/current.js"].ModTime).To(Equal(yarn.DefaultSourceDateEpoch))
+			for path, e := range first {
+				Expect(e.ModTime).To(Equal(yarn.DefaultSourceDateEpoch), path)
+			}
+		})
+
+		context("when SOURCE_DATE_EPOCH is set", func() {
+			it.Before(func() {
+				Expect(os.Setenv("SOURCE_DATE_EPOCH", "1700000000")).To(Succeed())
+				deliver(0600, time.Now(), "yarn", "yarn.js", "yarnpkg")
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("SOURCE_DATE_EPOCH")).To(Succeed())
+			})
+
+			it("uses it as the modification time", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				entries, _ := snapshot(result)
+				for path, e := range entries {
+					Expect(e.ModTime).To(Equal(time.Unix(1700000000, 0).UTC()), path)
+				}
+			})
+		})
+	})
+
+	context("when the delivery fails transiently", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_YARN_DOWNLOAD_RETRIES", "1")).To(Succeed())
+			Expect(os.Setenv("BP_YARN_DOWNLOAD_BACKOFF", "1ms")).To(Succeed())
+
+			dependencyManager.DeliverCall.Stub = func(dep postal.Dependency, cnbPath, layerPath, platformPath string) error {
+				if dependencyManager.DeliverCall.CallCount == 1 {
+					Expect(os.WriteFile(filepath.Join(layerPath, "partial"), nil, 0644)).To(Succeed())
+					return yarn.DownloadError{Err: errors.New("failed to fetch dependency: unexpected status code 503")}
+				}
+				return writeBinFiles(layerPath, 0755, "yarn", "yarn.js", "yarnpkg")
+			}
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_DOWNLOAD_RETRIES")).To(Succeed())
+			Expect(os.Unsetenv("BP_YARN_DOWNLOAD_BACKOFF")).To(Succeed())
+		})
+
+		it("retries the delivery into a clean layer and logs each attempt", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(dependencyManager.DeliverCall.CallCount).To(Equal(2))
+			Expect(filepath.Join(result.Layers[0].Path, "partial")).NotTo(BeAnExistingFile())
+
+			Expect(buffer.String()).To(ContainSubstring("Attempt 1 of 2 failed during download after"))
+			Expect(buffer.String()).To(ContainSubstring("Retrying in 1ms"))
+			Expect(sleeper.SleepCall.CallCount).To(Equal(1))
+			Expect(sleeper.SleepCall.Receives.Duration).To(Equal(time.Millisecond))
+			Expect(buffer.String()).To(MatchRegexp(`Completed in \S+ on attempt 2 of 2`))
+		})
+	})
+
+	context("when there is a cached layer", func() {
+		var layerToml string
+
+		it.Before(func() {
+			layerToml = filepath.Join(layersDir, "yarn.toml")
+		})
+
+		context("when the cached layer metadata matches the current schema", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(layerToml, []byte(`[metadata]
+schema-version = 1
+dependency-id = "yarn"
+dependency-version = "yarn-dependency-version"
+dependency-sha = "sha256:yarn-dependency-sha"
+fixups = ["bin-layout", "licenses"]
+`), 0600)).To(Succeed())
+			})
+
+			it("reuses the cached layer", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(result.Layers).To(HaveLen(1))
+				Expect(result.Layers[0].Metadata).To(HaveKeyWithValue("schema-version", yarn.LayerMetadataSchemaVersion))
+				Expect(result.Layers[0].BuildEnv).To(HaveKeyWithValue("PAKETO_YARN_VERSION.override", "yarn-dependency-version"))
+				Expect(filepath.Join(layersDir, "yarn", "yarn.json")).To(BeARegularFile())
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(0))
+				Expect(buffer.String()).To(ContainSubstring("Reusing cached layer"))
+			})
+		})
+
+		context("when the cached layer was written before the schema was versioned", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(layerToml, []byte(`[metadata]
+dependency-sha = "sha256:yarn-dependency-sha"
+dependency-id = "yarn"
+`), 0600)).To(Succeed())
+
+				Expect(writeBinFiles(filepath.Join(layersDir, "yarn"), 0755, "yarn", "yarn.js", "yarnpkg")).To(Succeed())
+			})
+
+			it("migrates the cached layer", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(0))
+				Expect(result.Layers[0].Metadata).To(Equal(map[string]interface{}{
+					"schema-version":        yarn.LayerMetadataSchemaVersion,
+					"dependency-id":         "yarn",
+					"dependency-version":    "yarn-dependency-version",
+					yarn.DependencyCacheKey: "sha256:yarn-dependency-sha",
+					"fixups":                []string{yarn.FixupBinLayout, yarn.FixupLicenses},
+				}))
+				Expect(buffer.String()).To(ContainSubstring("Migrating cached layer"))
+				Expect(buffer.String()).To(ContainSubstring("Reusing cached layer"))
+			})
+
+			context("when the cached berry layer has a non-executable bin/yarn", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(layerToml, []byte(`[metadata]
+dependency-sha = "sha256:berry-dependency-sha"
+dependency-id = "berry"
+`), 0600)).To(Succeed())
+
+					yarnLayerPath := filepath.Join(layersDir, "yarn")
+					Expect(os.RemoveAll(yarnLayerPath)).To(Succeed())
+					Expect(writeBinFiles(yarnLayerPath, 0644, "yarn", "yarn.js")).To(Succeed())
+
+					Expect(os.WriteFile(filepath.Join(workingDir, "package.json"),
+						[]byte(`{"packageManager":"yarn@4.14.1"}`), os.ModePerm)).To(Succeed())
+
+					dependencyManager.ResolveCall.Returns.Dependency = postal.Dependency{
+						ID:       "berry",
+						Checksum: "sha256:berry-dependency-sha",
+						Version:  "4.14.1",
+					}
+				})
+
+				it("fixes up the cached layer in place", func() {
+					result, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(dependencyManager.DeliverCall.CallCount).To(Equal(0))
+					Expect(result.Layers[0].Metadata).To(HaveKeyWithValue("fixups", []string{yarn.FixupBinLayout, yarn.FixupLicenses}))
+
+					for _, name := range []string{"yarn", "yarn.js", "yarnpkg"} {
+						info, err := os.Stat(filepath.Join(result.Layers[0].Path, "bin", name))
+						Expect(err).NotTo(HaveOccurred())
+						Expect(info.Mode()&0111).NotTo(BeZero(), fmt.Sprintf("bin/%s should be executable", name))
+					}
+				})
+			})
+
+			context("when the cached layer is missing a required entrypoint", func() {
+				it.Before(func() {
+					Expect(os.Remove(filepath.Join(layersDir, "yarn", "bin", "yarnpkg"))).To(Succeed())
+				})
+
+				it("invalidates the cached layer", func() {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+					Expect(buffer.String()).To(ContainSubstring("Invalidating cached layer"))
+					Expect(buffer.String()).To(ContainSubstring("yarn dependency is missing required entrypoint bin/yarnpkg"))
+				})
+			})
+		})
+
+		context("when the cached layer was written with a different schema version", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(layerToml, []byte(`[metadata]
+schema-version = 99
+dependency-id = "yarn"
+dependency-sha = "sha256:yarn-dependency-sha"
+`), 0600)).To(Succeed())
+			})
+
+			it("invalidates the cached layer", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("layer metadata schema changed"))
+			})
+		})
+
+		context("when the cached layer is missing a required fixup", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(layerToml, []byte(`[metadata]
+schema-version = 1
+dependency-id = "yarn"
+dependency-sha = "sha256:yarn-dependency-sha"
+fixups = []
+`), 0600)).To(Succeed())
+
+				Expect(writeBinFiles(filepath.Join(layersDir, "yarn"), 0644, "yarn", "yarn.js", "yarnpkg")).To(Succeed())
+			})
+
+			it("applies the fixup to the cached layer", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(0))
+				Expect(result.Layers[0].Metadata).To(HaveKeyWithValue("fixups", []string{yarn.FixupBinLayout, yarn.FixupLicenses}))
+
+				info, err := os.Stat(filepath.Join(result.Layers[0].Path, "bin", "yarn"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode() & 0111).NotTo(BeZero())
+			})
+		})
+	})
+
+	context("when the artifact cache is enabled", func() {
+		var checksum string
+
+		it.Before(func() {
+			Expect(os.Setenv("BP_YARN_ARTIFACT_CACHE_ENTRIES", "2")).To(Succeed())
+
+			checksum = dependencyManager.ResolveCall.Returns.Dependency.Checksum
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_ARTIFACT_CACHE_ENTRIES")).To(Succeed())
+		})
+
+		it("delivers through the dependency manager and keeps an archive in a cache-only layer", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+
+			Expect(result.Layers).To(HaveLen(2))
+			Expect(filepath.Join(result.Layers[0].Path, "bin", "yarn")).To(BeARegularFile())
+
+			artifactLayer := result.Layers[1]
+			Expect(artifactLayer.Name).To(Equal(yarn.ArtifactCacheLayerName))
+			Expect(artifactLayer.Build).To(BeFalse())
+			Expect(artifactLayer.Launch).To(BeFalse())
+			Expect(artifactLayer.Cache).To(BeTrue())
+
+			Expect(filepath.Join(artifactLayer.Path, strings.Replace(checksum, ":", "-", 1))).To(BeARegularFile())
+		})
+
+		context("when the dependency is already in the artifact cache", func() {
+			it.Before(func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.RemoveAll(filepath.Join(layersDir, "yarn"))).To(Succeed())
+			})
+
+			it("installs the dependency from the cached archive without delivering it", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+				Expect(filepath.Join(result.Layers[0].Path, "bin", "yarn")).To(BeARegularFile())
+				Expect(buffer.String()).To(ContainSubstring("Restored " + checksum + " from artifact cache"))
+			})
+
+			context("when the cached archive is damaged", func() {
+				it.Before(func() {
+					entry := filepath.Join(layersDir, yarn.ArtifactCacheLayerName, strings.Replace(checksum, ":", "-", 1))
+					Expect(os.WriteFile(entry, []byte("damaged"), 0644)).To(Succeed())
+					buffer.Reset()
+				})
+
+				it("delivers the dependency again and does not report a cache hit", func() {
+					result, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(dependencyManager.DeliverCall.CallCount).To(Equal(2))
+					Expect(filepath.Join(result.Layers[0].Path, "bin", "yarn")).To(BeARegularFile())
+					Expect(buffer.String()).NotTo(ContainSubstring("from artifact cache"))
+				})
+			})
+		})
+
+		context("when the yarn layer is reused", func() {
+			it("marks the cached archive as used", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				layerToml, err := os.Create(filepath.Join(layersDir, "yarn.toml"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(toml.NewEncoder(layerToml).Encode(map[string]interface{}{"metadata": result.Layers[0].Metadata})).To(Succeed())
+				Expect(layerToml.Close()).To(Succeed())
+
+				index := filepath.Join(layersDir, yarn.ArtifactCacheLayerName, "index.json")
+				before, err := os.ReadFile(index)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buffer.String()).To(ContainSubstring("Reusing cached layer"))
+
+				after, err := os.ReadFile(index)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(after).NotTo(Equal(before))
+			})
+		})
+
+		context("when BP_YARN_ARTIFACT_CACHE_ENTRIES is invalid", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_ARTIFACT_CACHE_ENTRIES", "some-number")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_YARN_ARTIFACT_CACHE_ENTRIES")))
+			})
+		})
+	})
+
+	context("when BP_YARN_REPORT_PATH is set", func() {
+		var reportPath string
+
+		it.Before(func() {
+			reportPath = filepath.Join(layersDir, "reports", "yarn.json")
+			Expect(os.Setenv("BP_YARN_REPORT_PATH", reportPath)).To(Succeed())
+
+			now := time.Unix(0, 0)
+			build = yarn.Build(dependencyManager,
+				sbomGenerator,
+				yarn.NewVersionResolver(yarn.DefaultVersionSources()...),
+				node,
+				yarnExecutable,
+				chronos.NewClock(func() time.Time {
+					now = now.Add(time.Second)
+					return now
+				}),
+				sleeper,
+				scribe.NewEmitter(buffer))
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_REPORT_PATH")).To(Succeed())
+		})
+
+		readReport := func() yarn.BuildReport {
+			content, err := os.ReadFile(reportPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			var report yarn.BuildReport
+			Expect(json.Unmarshal(content, &report)).To(Succeed())
+			return report
+		}
+
+		it("writes a report of the installed dependency", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			report := readReport()
+			Expect(report.Installs).To(HaveLen(1))
+
+			install := report.Installs[0]
+			Expect(install.DependencyID).To(Equal("yarn"))
+			Expect(install.Flavor).To(Equal("classic"))
+			Expect(install.Version).To(Equal("yarn-dependency-version"))
+			Expect(install.Source).To(Equal("default"))
+			Expect(install.Cache).To(Equal(yarn.CacheMiss))
+			Expect(install.CacheSource).To(BeEmpty())
+			Expect(install.DeliveryDurationMS).To(BeNumerically(">", 0))
+			Expect(install.SBOMDurationMS).To(BeNumerically(">", 0))
+
+			Expect(report.Warnings).To(BeEmpty())
+			Expect(report.Error).To(BeEmpty())
+		})
+
+		context("when a source asks for a version", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package.json"),
+					[]byte(`{"packageManager":"yarn@1.22.22"}`), os.ModePerm)).To(Succeed())
+				Expect(os.Setenv("BP_YARN_VERSION", "1.22.*")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_VERSION")).To(Succeed())
+			})
+
+			it("reports the source that decided the resolution", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dependencyManager.ResolveCall.Receives.Version).To(Equal("1.22.*"))
+				Expect(readReport().Installs[0].Source).To(Equal("packageManager"))
+			})
+		})
+
+		context("when the layer is reused", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(layersDir, "yarn.toml"), []byte(`[metadata]
+schema-version = 1
+dependency-id = "yarn"
+dependency-version = "yarn-dependency-version"
+dependency-sha = "sha256:yarn-dependency-sha"
+fixups = ["bin-layout", "licenses"]
+`), 0600)).To(Succeed())
+			})
+
+			it("reports a cache hit", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				install := readReport().Installs[0]
+				Expect(install.Cache).To(Equal(yarn.CacheHit))
+				Expect(install.CacheSource).To(Equal(yarn.CacheSourceLayer))
+				Expect(install.DeliveryDurationMS).To(BeZero())
+			})
+		})
+
+		context("when there are warnings", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_DOWNLOAD_BACKOFF", "0s")).To(Succeed())
+
+				dependencyManager.DeliverCall.Stub = func(dep postal.Dependency, cnbPath, layerPath, platformPath string) error {
+					if dependencyManager.DeliverCall.CallCount == 1 {
+						return yarn.DownloadError{Err: errors.New("failed to fetch dependency: unexpected status code 503")}
+					}
+					return writeBinFiles(layerPath, 0755, "yarn", "yarn.js", "yarnpkg")
+				}
+
+				node.ExecuteCall.Stub = nil
+				node.ExecuteCall.Returns.Error = &exec.Error{Name: "node", Err: exec.ErrNotFound}
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_DOWNLOAD_BACKOFF")).To(Succeed())
+			})
+
+			it("records them", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readReport().Warnings).To(Equal([]string{
+					"attempt 1 of 3 to deliver yarn yarn-dependency-version failed during download: failed to fetch dependency: unexpected status code 503",
+					"skipped the verification of yarn yarn-dependency-version: node is not available on the PATH",
+				}))
+			})
+		})
+
+		context("when package.json declares a malformed package manager", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package.json"), []byte(`{"packageManager": "yarn"}`), 0600)).To(Succeed())
+			})
+
+			it("warns and builds with the remaining sources", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(buffer.String()).To(ContainSubstring("Ignoring the package manager declarations of package.json:"))
+
+				report := readReport()
+				Expect(report.Installs).To(HaveLen(1))
+				Expect(report.Installs[0].Source).To(Equal("default"))
+				Expect(report.Warnings).To(ConsistOf(ContainSubstring("ignored the package manager declarations of package.json:")))
+			})
+		})
+
+		context("when the build fails", func() {
+			it.Before(func() {
+				dependencyManager.ResolveCall.Returns.Error = errors.New("failed to resolve dependency")
+			})
+
+			it("records the error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to resolve dependency"))
+
+				report := readReport()
+				Expect(report.Installs).To(BeEmpty())
+				Expect(report.Error).To(Equal("failed to resolve dependency"))
+			})
+		})
+
+		context("when the report cannot be written", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(layersDir, "reports"), nil, 0600)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to write build report")))
+			})
+		})
+	})
+
+	context("when tracing is enabled", func() {
+		type fileSpan struct {
+			Name        string
+			SpanContext struct{ TraceID, SpanID string }
+			Parent      struct{ TraceID, SpanID string }
+		}
+
+		var tracesFile string
+
+		readSpans := func() map[string]fileSpan {
+			file, err := os.Open(tracesFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { Expect(file.Close()).To(Succeed()) }()
+
+			spans := map[string]fileSpan{}
+			decoder := json.NewDecoder(file)
+			for decoder.More() {
+				var span fileSpan
+				Expect(decoder.Decode(&span)).To(Succeed())
+				spans[span.Name] = span
+			}
+			return spans
+		}
+
+		it.Before(func() {
+			tracesFile = filepath.Join(layersDir, "traces", "spans.json")
+			Expect(os.Setenv("BP_YARN_TRACES_FILE", tracesFile)).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_TRACES_FILE")).To(Succeed())
+		})
+
+		it("writes a span for each build phase to the traces file", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			spans := readSpans()
+			Expect(spans).To(HaveLen(8))
+
+			root := spans["yarn.build"]
+			Expect(root.Parent.SpanID).To(Equal("0000000000000000"))
+			Expect(spans["yarn.resolve"].Parent.SpanID).To(Equal(root.SpanContext.SpanID))
+			Expect(spans["yarn.install"].Parent.SpanID).To(Equal(root.SpanContext.SpanID))
+
+			install := spans["yarn.install"]
+			for _, name := range []string{"yarn.cache", "yarn.deliver", "yarn.fixups", "yarn.sbom.generate", "yarn.sbom.format"} {
+				Expect(spans).To(HaveKey(name))
+				Expect(spans[name].Parent.SpanID).To(Equal(install.SpanContext.SpanID), name)
+				Expect(spans[name].SpanContext.TraceID).To(Equal(root.SpanContext.TraceID), name)
+			}
+		})
+
+		context("when TRACEPARENT is set", func() {
+			it.Before(func() {
+				Expect(os.Setenv("TRACEPARENT", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("TRACEPARENT")).To(Succeed())
+			})
+
+			it("continues the trace of the pipeline", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				root := readSpans()["yarn.build"]
+				Expect(root.SpanContext.TraceID).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+				Expect(root.Parent.SpanID).To(Equal("b7ad6b7169203331"))
+			})
+		})
+
+		context("when OTEL_TRACES_EXPORTER is none", func() {
+			it.Before(func() {
+				Expect(os.Setenv("OTEL_TRACES_EXPORTER", "none")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("OTEL_TRACES_EXPORTER")).To(Succeed())
+			})
+
+			it("does not trace the build", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tracesFile).NotTo(BeAnExistingFile())
+			})
+		})
+	})
+
+	context("when an OTLP endpoint is set", func() {
+		var (
+			server   *httptest.Server
+			requests chan *coltracepb.ExportTraceServiceRequest
+		)
+
+		it.Before(func() {
+			requests = make(chan *coltracepb.ExportTraceServiceRequest, 10)
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer func() { _ = r.Body.Close() }()
+
+				content, err := io.ReadAll(r.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				request := &coltracepb.ExportTraceServiceRequest{}
+				Expect(proto.Unmarshal(content, request)).To(Succeed())
+				requests <- request
+
+				w.Header().Set("Content-Type", "application/x-protobuf")
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			Expect(os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)).To(Succeed())
+		})
+
+		it.After(func() {
+			server.Close()
+			Expect(os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")).To(Succeed())
+		})
+
+		it("exports the spans to the collector", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			var names []string
+			for len(requests) > 0 {
+				request := <-requests
+				for _, resourceSpans := range request.ResourceSpans {
+					for _, scopeSpans := range resourceSpans.ScopeSpans {
+						Expect(scopeSpans.Scope.Name).To(Equal("github.com/paketo-buildpacks/yarn"))
+						for _, span := range scopeSpans.Spans {
+							names = append(names, span.Name)
+						}
+					}
+				}
+			}
+
+			Expect(names).To(ConsistOf(
+				"yarn.build",
+				"yarn.resolve",
+				"yarn.install",
+				"yarn.cache",
+				"yarn.deliver",
+				"yarn.fixups",
+				"yarn.sbom.generate",
+				"yarn.sbom.format",
+			))
+		})
+
+		context("when the collector cannot be reached", func() {
+			it.Before(func() {
+				server.Close()
+				Expect(os.Setenv("OTEL_EXPORTER_OTLP_TIMEOUT", "100")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("OTEL_EXPORTER_OTLP_TIMEOUT")).To(Succeed())
+			})
+
+			it("logs the failure and completes the build", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(buffer.String()).To(ContainSubstring("Failed to export traces"))
+			})
+		})
+
+		context("when the OTLP protocol is not supported", func() {
+			it.Before(func() {
+				Expect(os.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("OTEL_EXPORTER_OTLP_PROTOCOL")).To(Succeed())
+			})
+
+			it("logs a warning and builds without traces", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(buffer.String()).To(ContainSubstring("Tracing is disabled: unsupported OTLP protocol grpc: only http/protobuf is supported"))
+				Expect(requests).To(BeEmpty())
+			})
+		})
+
+		context("when OTEL_SDK_DISABLED is invalid", func() {
+			it.Before(func() {
+				Expect(os.Setenv("OTEL_SDK_DISABLED", "maybe")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("OTEL_SDK_DISABLED")).To(Succeed())
+			})
+
+			it("logs a warning and builds without traces", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(buffer.String()).To(ContainSubstring("Tracing is disabled: failed to parse OTEL_SDK_DISABLED value maybe"))
+				Expect(requests).To(BeEmpty())
+			})
+		})
+	})
+
+	context("when the app uses Yarn Berry via packageManager in package.json", func() {
+		it.Before(func() {
+			err := os.WriteFile(filepath.Join(workingDir, "package.json"),
+				[]byte(`{"packageManager":"yarn@4.14.1"}`), os.ModePerm)
+			Expect(err).NotTo(HaveOccurred())
+
+			dependencyManager.ResolveCall.Returns.Dependency = postal.Dependency{
+				ID:       "berry",
+				Name:     "berry-dependency-name",
+				Checksum: "sha256:berry-dependency-sha",
+				Stacks:   []string{"some-stack"},
+				URI:      "berry-dependency-uri",
+				Version:  "4.14.1",
+			}
+
+			// Simulate the @yarnpkg/cli-dist tgz delivery: bin/yarn and bin/yarn.js
+			// are extracted with 0644 and there is no bin/yarnpkg.
+			dependencyManager.DeliverCall.Stub = func(dep postal.Dependency, cnbPath, layerPath, platformPath string) error {
+				return writeBinFiles(layerPath, 0644, "yarn", "yarn.js")
+			}
+		})
+
+		it("resolves the berry dependency and completes the bin layout", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("berry"))
+
+			layer := result.Layers[0]
+			Expect(layer.Metadata).To(Equal(map[string]interface{}{
+				"schema-version":        yarn.LayerMetadataSchemaVersion,
+				"dependency-id":         "berry",
+				"dependency-version":    "4.14.1",
+				yarn.DependencyCacheKey: "sha256:berry-dependency-sha",
+				"fixups":                []string{yarn.FixupBinLayout, yarn.FixupLicenses},
+			}))
+
+			for _, name := range []string{"yarn", "yarn.js", "yarnpkg"} {
+				info, err := os.Stat(filepath.Join(layer.Path, "bin", name))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode()&0111).NotTo(BeZero(), fmt.Sprintf("bin/%s should be executable", name))
+			}
+
+			// bin/yarn was shipped by the dependency and must not be replaced.
+			content, err := os.ReadFile(filepath.Join(layer.Path, "bin", "yarn"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("#!/bin/sh\n"))
+
+			content, err = os.ReadFile(filepath.Join(layer.Path, "bin", "yarnpkg"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring(`exec node "$(dirname "$0")/yarn.js" "$@"`))
+		})
+	})
+
+	context("when the app uses packageManager yarn@2.x (classic threshold)", func() {
+		it.Before(func() {
+			err := os.WriteFile(filepath.Join(workingDir, "package.json"),
+				[]byte(`{"packageManager":"yarn@2.4.3"}`), os.ModePerm)
+			Expect(err).NotTo(HaveOccurred())
+
+			dependencyManager.ResolveCall.Returns.Dependency = postal.Dependency{
+				ID:       "berry",
+				Version:  "2.4.3",
+				Checksum: "sha256:berry-2-sha",
+			}
+		})
+
+		it("resolves berry for major version 2", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("berry"))
+		})
+	})
+
+	context("when the app declares packageManager yarn@1.22.22 (classic)", func() {
+		it.Before(func() {
+			err := os.WriteFile(filepath.Join(workingDir, "package.json"),
+				[]byte(`{"packageManager":"yarn@1.22.22"}`), os.ModePerm)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it("still resolves the classic yarn dependency", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("yarn"))
+		})
+	})
+
+	context("when package.json has no packageManager field", func() {
+		it("defaults to classic yarn", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("yarn"))
+		})
+	})
+
+	context("when the project declares no packageManager but has a .yarnrc.yml", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(filepath.Join(workingDir, ".yarnrc.yml"), []byte("nodeLinker: node-modules\n"), 0600)).To(Succeed())
+		})
+
+		it("defaults to classic yarn", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("yarn"))
+		})
+
+		context("when BP_YARN_DETECT_FLAVOR is true", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_DETECT_FLAVOR", "true")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_DETECT_FLAVOR")).To(Succeed())
+			})
+
+			it("resolves the berry dependency", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("berry"))
+			})
+		})
+	})
+
+	context("when the project declares no packageManager but has a Berry lockfile", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_YARN_DETECT_FLAVOR", "true")).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(workingDir, "yarn.lock"), []byte(`# This file is generated by running "yarn install" inside your project.
+
+__metadata:
+  version: 8
+  cacheKey: 10c0
+`), 0600)).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_DETECT_FLAVOR")).To(Succeed())
+		})
+
+		it("resolves the berry dependency when BP_YARN_DETECT_FLAVOR is true", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("berry"))
+		})
+	})
+
+	context("when the project declares a classic packageManager and has a .yarnrc.yml", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(filepath.Join(workingDir, "package.json"),
+				[]byte(`{"packageManager":"yarn@1.22.22"}`), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(workingDir, ".yarnrc.yml"), nil, 0600)).To(Succeed())
+		})
+
+		it("resolves the classic yarn dependency", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("yarn"))
+		})
+	})
+
+	context("when a version resolver is given", func() {
+		var versionResolver *fakes.VersionResolver
+
+		it.Before(func() {
+			versionResolver = &fakes.VersionResolver{}
+			versionResolver.ResolveCall.Returns.VersionResolution = yarn.VersionResolution{
+				DependencyID: "berry",
+				Constraint:   "4.*",
+				Trail:        []string{"some-decision"},
+			}
+
+			buildContext.Plan.Entries[0].Metadata = map[string]interface{}{"version": "1.22.*"}
+
+			build = yarn.Build(dependencyManager,
+				sbomGenerator,
+				versionResolver,
+				node,
+				yarnExecutable,
+				chronos.DefaultClock,
+				sleeper,
+				scribe.NewEmitter(buffer).WithLevel("DEBUG"))
+		})
+
+		it("resolves the dependency and version it chooses", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(versionResolver.ResolveCall.CallCount).To(Equal(1))
+			Expect(versionResolver.ResolveCall.Receives.Project.Path).To(Equal(workingDir))
+			Expect(versionResolver.ResolveCall.Receives.Entries).To(Equal(buildContext.Plan.Entries))
+			Expect(versionResolver.ResolveCall.Receives.Pins).To(BeFalse())
+
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("berry"))
+			Expect(dependencyManager.ResolveCall.Receives.Version).To(Equal("4.*"))
+			Expect(buffer.String()).To(ContainSubstring("some-decision"))
+		})
+
+		context("when BP_YARN_PROJECT_PATHS lists several projects", func() {
+			it.Before(func() {
+				for _, dir := range []string{"frontend", "backend"} {
+					Expect(os.MkdirAll(filepath.Join(workingDir, dir), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(workingDir, dir, "package.json"), []byte(`{}`), 0600)).To(Succeed())
+				}
+
+				Expect(os.Setenv("BP_YARN_PROJECT_PATHS", "frontend,backend")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_PROJECT_PATHS")).To(Succeed())
+			})
+
+			it("asks the resolver to honor the pins of each project", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(versionResolver.ResolveCall.CallCount).To(Equal(2))
+				Expect(versionResolver.ResolveCall.Receives.Project.Path).To(Equal(filepath.Join(workingDir, "backend")))
+				Expect(versionResolver.ResolveCall.Receives.Pins).To(BeTrue())
+			})
+		})
+
+		context("when the version cannot be resolved", func() {
+			it.Before(func() {
+				versionResolver.ResolveCall.Returns.Error = errors.New("failed to resolve version")
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to resolve version"))
+				Expect(dependencyManager.ResolveCall.CallCount).To(Equal(0))
+			})
+		})
+	})
+
+	context("when BP_SBOM_FORMATS selects a subset of the formats", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_SBOM_FORMATS", "CycloneDX")).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_SBOM_FORMATS")).To(Succeed())
+		})
+
+		it("only generates the selected formats", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			formats := result.Layers[0].SBOM.Formats()
+			Expect(formats).To(HaveLen(1))
+			Expect(formats[0].Extension).To(Equal("cdx.json"))
+		})
+
+		context("when the formats are given as media types", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_SBOM_FORMATS", fmt.Sprintf("%s, %s", sbom.SPDXFormat, sbom.SPDXFormat))).To(Succeed())
+			})
+
+			it("generates each of them once", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				formats := result.Layers[0].SBOM.Formats()
+				Expect(formats).To(HaveLen(1))
+				Expect(formats[0].Extension).To(Equal("spdx.json"))
+			})
+		})
+	})
+
+	context("when BP_YARN_SBOM_MODE is scan", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_YARN_SBOM_MODE", "scan")).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_SBOM_MODE")).To(Succeed())
+		})
+
+		it("scans the installed files", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(sbomGenerator.GenerateFromDependencyCall.CallCount).To(Equal(0))
+			Expect(sbomGenerator.GenerateCall.CallCount).To(Equal(1))
+			Expect(sbomGenerator.GenerateCall.Receives.Dir).To(Equal(filepath.Join(layersDir, "yarn")))
+			Expect(result.Layers[0].SBOM.Formats()).To(HaveLen(2))
+		})
+	})
+
+	context("when BP_YARN_SBOM_MODE is both", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_YARN_SBOM_MODE", "both")).To(Succeed())
+
+			var err error
+			sbomGenerator.GenerateCall.Returns.SBOM, err = sbom.GenerateFromDependency(postal.Dependency{
+				Name:    "serve",
+				Version: "14.2.3",
+				PURL:    "pkg:npm/serve@14.2.3",
+			}, filepath.Join(layersDir, "yarn"))
+			Expect(err).NotTo(HaveOccurred())
+
+			sbomGenerator.GenerateFromDependencyCall.Returns.SBOM, err = sbom.GenerateFromDependency(postal.Dependency{
+				Name:    "Yarn",
+				Version: "1.22.22",
+				PURL:    "pkg:generic/yarn@1.22.22",
+			}, filepath.Join(layersDir, "yarn"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_SBOM_MODE")).To(Succeed())
+		})
+
+		it("merges the scanned files with the dependency entry", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(sbomGenerator.GenerateCall.CallCount).To(Equal(1))
+			Expect(sbomGenerator.GenerateCall.Receives.Dir).To(Equal(filepath.Join(layersDir, "yarn")))
+			Expect(sbomGenerator.GenerateFromDependencyCall.CallCount).To(Equal(1))
+			Expect(sbomGenerator.GenerateFromDependencyCall.Receives.Dependency).To(Equal(dependencyManager.ResolveCall.Returns.Dependency))
+
+			formats := result.Layers[0].SBOM.Formats()
+			Expect(formats).To(HaveLen(2))
+			for _, format := range formats {
+				content, err := io.ReadAll(format.Content)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(ContainSubstring("pkg:npm/serve@14.2.3"))
+				Expect(string(content)).To(ContainSubstring("pkg:generic/yarn@1.22.22"))
+			}
+		})
+	})
+
+	context("when BP_YARN_SBOM_OUTPUT_DIR is set", func() {
+		var outputDir string
+
+		it.Before(func() {
+			outputDir = filepath.Join(layersDir, "ci", "sbom")
+			Expect(os.Setenv("BP_YARN_SBOM_OUTPUT_DIR", outputDir)).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_SBOM_OUTPUT_DIR")).To(Succeed())
+		})
+
+		it("writes a copy of each formatted SBOM to the directory", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, format := range result.Layers[0].SBOM.Formats() {
+				expected, err := io.ReadAll(format.Content)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(expected).NotTo(BeEmpty())
+
+				content, err := os.ReadFile(filepath.Join(outputDir, fmt.Sprintf("yarn-yarn-dependency-version.sbom.%s", format.Extension)))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(content).To(MatchJSON(expected))
+			}
+
+			Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Writing SBOM copies to %s", outputDir)))
+		})
+	})
+
+	context("when BP_YARN_PROJECT_PATH points at a subdirectory", func() {
+		it.Before(func() {
+			Expect(os.MkdirAll(filepath.Join(workingDir, "frontend"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(workingDir, "frontend", "package.json"),
+				[]byte(`{"packageManager":"yarn@4.14.1"}`), os.ModePerm)).To(Succeed())
+			Expect(os.Setenv("BP_YARN_PROJECT_PATH", "frontend")).To(Succeed())
+
+			dependencyManager.ResolveCall.Returns.Dependency = postal.Dependency{
+				ID:       "berry",
+				Checksum: "sha256:berry-dependency-sha",
+				Version:  "4.14.1",
+			}
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_PROJECT_PATH")).To(Succeed())
+		})
+
+		it("reads the package.json of the project", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("berry"))
+			Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Using Yarn project at %s", filepath.Join(workingDir, "frontend"))))
+		})
+
+		context("when the project path does not exist", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_PROJECT_PATH", "backend")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring(`invalid BP_YARN_PROJECT_PATH "backend"`)))
+			})
+		})
+	})
+
+	context("when there is no package.json in the working directory", func() {
+		it("defaults to classic yarn", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dependencyManager.ResolveCall.Receives.Id).To(Equal("yarn"))
+		})
+	})
+
+	context("failure cases", func() {
+		context("when the yarn layer cannot be retrieved", func() {
+			it.Before(func() {
+				err := os.WriteFile(filepath.Join(layersDir, "yarn.toml"), nil, 0000)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse layer content metadata")))
+			})
+		})
+
+		context("when the dependency cannot be resolved", func() {
+			it.Before(func() {
+				dependencyManager.ResolveCall.Returns.Error = errors.New("failed to resolve dependency")
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to resolve dependency"))
+			})
+		})
+
+		context("when the layers directory cannot be written to", func() {
+			it.Before(func() {
+				Expect(os.Chmod(layersDir, 4444)).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Chmod(layersDir, os.ModePerm)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("permission denied")))
+			})
+		})
+
+		context("when the dependency cannot be installed", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_DOWNLOAD_BACKOFF", "0")).To(Succeed())
+				dependencyManager.DeliverCall.Stub = nil
+				dependencyManager.DeliverCall.Returns.Error = errors.New("failed to install dependency")
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_DOWNLOAD_BACKOFF")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to deliver dependency during extraction after 3 attempt(s): failed to install dependency"))
+
+				var deliveryErr yarn.DeliveryError
+				Expect(errors.As(err, &deliveryErr)).To(BeTrue())
+				Expect(deliveryErr.Phase).To(Equal(yarn.DeliveryPhaseExtraction))
+				Expect(dependencyManager.DeliverCall.CallCount).To(Equal(3))
+			})
+
+			context("when the download fails", func() {
+				it.Before(func() {
+					dependencyManager.DeliverCall.Returns.Error = yarn.DownloadError{Err: errors.New("failed to fetch dependency: failed to make request: connection refused")}
+				})
+
+				it("reports the download phase", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError(ContainSubstring("failed to deliver dependency during download after 3 attempt(s)")))
+				})
+			})
+
+			context("when the checksum does not match", func() {
+				it.Before(func() {
+					dependencyManager.DeliverCall.Returns.Error = yarn.ChecksumError{Err: errors.New("failed to validate dependency: checksum does not match")}
+				})
+
+				it("does not retry the delivery", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError(ContainSubstring("failed to deliver dependency during checksum verification after 1 attempt(s)")))
+					Expect(dependencyManager.DeliverCall.CallCount).To(Equal(1))
+				})
+			})
+		})
+
+		context("when the dependency is missing a required entrypoint", func() {
+			it.Before(func() {
+				dependencyManager.DeliverCall.Stub = func(dep postal.Dependency, cnbPath, layerPath, platformPath string) error {
+					return writeBinFiles(layerPath, 0755, "yarn", "yarn.js")
+				}
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to prepare yarn layer: yarn dependency is missing required entrypoint bin/yarnpkg"))
+			})
+		})
+
+		context("when BP_YARN_NODE_COMPATIBILITY is invalid", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_NODE_COMPATIBILITY", "sometimes")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_NODE_COMPATIBILITY")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to parse BP_YARN_NODE_COMPATIBILITY value sometimes: must be one of fail, warn or off"))
+			})
+		})
+
+		context("when SOURCE_DATE_EPOCH is invalid", func() {
+			it.Before(func() {
+				Expect(os.Setenv("SOURCE_DATE_EPOCH", "yesterday")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("SOURCE_DATE_EPOCH")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to parse SOURCE_DATE_EPOCH value yesterday: must be a number of seconds since the Unix epoch"))
+			})
+		})
+
+		context("when BP_YARN_ADVISORY_POLICY is invalid", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_ADVISORY_POLICY", "sometimes")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_ADVISORY_POLICY")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to parse BP_YARN_ADVISORY_POLICY value sometimes: must be one of fail, warn or off"))
+			})
+		})
+
+		context("when BP_YARN_DOWNLOAD_RETRIES is invalid", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_DOWNLOAD_RETRIES", "-1")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_DOWNLOAD_RETRIES")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_YARN_DOWNLOAD_RETRIES")))
+			})
+		})
+
+		context("when generating the SBOM returns an error", func() {
+			it.Before(func() {
+				buildContext.BuildpackInfo = packit.BuildpackInfo{SBOMFormats: []string{"random-format"}}
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("unsupported SBOM format: 'random-format'"))
+			})
+		})
+
+		context("when formatting the SBOM returns an error", func() {
+			it.Before(func() {
+				sbomGenerator.GenerateFromDependencyCall.Returns.Error = errors.New("failed to generate SBOM")
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to generate SBOM")))
+			})
+		})
+
+		context("when the installed yarn reports a different version", func() {
+			it.Before(func() {
+				yarnExecutable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+					_, err := fmt.Fprintln(execution.Stdout, "1.0.0")
+					return err
+				}
+			})
+
+			it("returns an error with the captured output", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring(`failed to verify yarn installation: installed yarn reported version "1.0.0", expected "yarn-dependency-version"`)))
+			})
+		})
+
+		context("when the installed yarn cannot be run", func() {
+			it.Before(func() {
+				yarnExecutable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+					_, _ = fmt.Fprintln(execution.Stderr, "permission denied: bin/yarn")
+					return errors.New("exit status 126")
+				}
+			})
+
+			it("returns an error with the captured output", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to run installed yarn: exit status 126")))
+				Expect(err).To(MatchError(ContainSubstring("permission denied: bin/yarn")))
+			})
+		})
+
+		context("when BP_YARN_SKIP_VERSION_CHECK is set incorrectly", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_SKIP_VERSION_CHECK", "not-a-bool")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_SKIP_VERSION_CHECK")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_YARN_SKIP_VERSION_CHECK")))
+			})
+		})
+
+		context("when BP_YARN_SBOM_MODE is invalid", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_SBOM_MODE", "everything")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_SBOM_MODE")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to parse BP_YARN_SBOM_MODE value everything: must be one of dependency, scan or both"))
+			})
+		})
+
+		context("when BP_SBOM_FORMATS lists an unsupported format", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_SBOM_FORMATS", "cyclonedx,swid")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_SBOM_FORMATS")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(`failed to parse BP_SBOM_FORMATS value "cyclonedx,swid": unsupported format "swid": must be one of cyclonedx, spdx`))
+			})
+		})
+
+		context("when BP_SBOM_FORMATS lists no format", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_SBOM_FORMATS", " , ")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_SBOM_FORMATS")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(`failed to parse BP_SBOM_FORMATS value " , ": must list at least one format`))
+			})
+		})
+
+		context("when BP_YARN_SBOM_OUTPUT_DIR is relative", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_SBOM_OUTPUT_DIR", "sbom")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_SBOM_OUTPUT_DIR")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(`invalid BP_YARN_SBOM_OUTPUT_DIR "sbom": must be an absolute path`))
+			})
+		})
+
+		context("when BP_DISABLE_SBOM is set incorrectly", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_DISABLE_SBOM", "not-a-bool")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_DISABLE_SBOM")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_DISABLE_SBOM")))
+			})
+		})
+	})
+}
+
+func writeBinFiles(layerPath string, mode os.FileMode, names ...string) error {
+	err := os.MkdirAll(filepath.Join(layerPath, "bin"), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = os.WriteFile(filepath.Join(layerPath, "bin", name), []byte("#!/bin/sh\n"), mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
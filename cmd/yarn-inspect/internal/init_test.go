@@ -0,0 +1,15 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitYarnInspect(t *testing.T) {
+	suite := spec.New("yarn-inspect", spec.Report(report.Terminal{}), spec.Parallel())
+	suite("ParseArgs", testParseArgs)
+	suite("Write", testWrite)
+	suite.Run(t)
+}
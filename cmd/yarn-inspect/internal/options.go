@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2"
+)
+
+// DefaultStack is the stack the dependencies are resolved for when -stack is
+// not given.
+const DefaultStack = "io.buildpacks.stacks.jammy"
+
+// Options are the command line options of yarn-inspect.
+type Options struct {
+	App       string
+	Buildpack string
+	Stack     string
+	Env       map[string]string
+	Plan      packit.BuildpackPlan
+	Format    string
+}
+
+// ParseArgs parses the command line arguments. Usage and parse errors are
+// written to output; -h returns flag.ErrHelp.
+func ParseArgs(args []string, output io.Writer) (Options, error) {
+	options := Options{Env: map[string]string{}}
+
+	flags := flag.NewFlagSet("yarn-inspect", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Usage = func() {
+		fmt.Fprintln(output, "Usage: yarn-inspect [options]")
+		fmt.Fprintln(output)
+		fmt.Fprintln(output, "Explains which Yarn the buildpack would install for an app, without downloading it.")
+		fmt.Fprintln(output)
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&options.App, "app", ".", "the app directory")
+	flags.StringVar(&options.Buildpack, "buildpack", "buildpack.toml", "the buildpack.toml to resolve the dependencies from")
+	flags.StringVar(&options.Stack, "stack", DefaultStack, "the stack to resolve the dependencies for")
+	flags.StringVar(&options.Format, "format", "text", "the output format, text or json")
+
+	flags.Func("env", "an environment variable of the build as `KEY=VALUE`, may be repeated", func(value string) error {
+		name, val, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("must be KEY=VALUE")
+		}
+
+		options.Env[name] = val
+		return nil
+	})
+
+	flags.Func("plan-entry", "the metadata of a yarn build plan entry as a JSON object, for example '{\"version\":\"4.*\"}', may be repeated", func(value string) error {
+		var metadata map[string]interface{}
+		err := json.Unmarshal([]byte(value), &metadata)
+		if err != nil {
+			return fmt.Errorf("must be a JSON object: %w", err)
+		}
+
+		options.Plan.Entries = append(options.Plan.Entries, packit.BuildpackPlanEntry{Name: "yarn", Metadata: metadata})
+		return nil
+	})
+
+	err := flags.Parse(args)
+	if err != nil {
+		return Options{}, err
+	}
+
+	if flags.NArg() > 0 {
+		return Options{}, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	if options.Format != "text" && options.Format != "json" {
+		return Options{}, fmt.Errorf("invalid -format %s: must be text or json", options.Format)
+	}
+
+	// The project paths in the output are absolute, as they are in a build.
+	options.App, err = filepath.Abs(options.App)
+	if err != nil {
+		return Options{}, err
+	}
+
+	options.Buildpack, err = filepath.Abs(options.Buildpack)
+	if err != nil {
+		return Options{}, err
+	}
+
+	return options, nil
+}
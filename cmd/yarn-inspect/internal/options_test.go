@@ -0,0 +1,111 @@
+package internal_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/yarn/cmd/yarn-inspect/internal"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testParseArgs(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		output *bytes.Buffer
+	)
+
+	it.Before(func() {
+		output = bytes.NewBuffer(nil)
+	})
+
+	it("defaults to the current directory and its buildpack.toml", func() {
+		wd, err := os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+
+		options, err := internal.ParseArgs(nil, output)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(options).To(Equal(internal.Options{
+			App:       wd,
+			Buildpack: filepath.Join(wd, "buildpack.toml"),
+			Stack:     internal.DefaultStack,
+			Env:       map[string]string{},
+			Format:    "text",
+		}))
+	})
+
+	it("parses the options", func() {
+		options, err := internal.ParseArgs([]string{
+			"-app", "/some/app",
+			"-buildpack", "/some/buildpack.toml",
+			"-stack", "some-stack",
+			"-env", "BP_YARN_PROJECT_PATH=frontend",
+			"-env", "BP_YARN_USE_SYSTEM=",
+			"-plan-entry", `{"version": "4.*", "version-source": "BP_YARN_VERSION"}`,
+			"-plan-entry", `{"launch": true}`,
+			"-format", "json",
+		}, output)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(options).To(Equal(internal.Options{
+			App:       "/some/app",
+			Buildpack: "/some/buildpack.toml",
+			Stack:     "some-stack",
+			Env: map[string]string{
+				"BP_YARN_PROJECT_PATH": "frontend",
+				"BP_YARN_USE_SYSTEM":   "",
+			},
+			Plan: packit.BuildpackPlan{
+				Entries: []packit.BuildpackPlanEntry{
+					{Name: "yarn", Metadata: map[string]interface{}{"version": "4.*", "version-source": "BP_YARN_VERSION"}},
+					{Name: "yarn", Metadata: map[string]interface{}{"launch": true}},
+				},
+			},
+			Format: "json",
+		}))
+	})
+
+	context("when -h is given", func() {
+		it("prints the usage", func() {
+			_, err := internal.ParseArgs([]string{"-h"}, output)
+			Expect(err).To(MatchError(flag.ErrHelp))
+			Expect(output.String()).To(ContainSubstring("Usage: yarn-inspect [options]"))
+			Expect(output.String()).To(ContainSubstring("-plan-entry"))
+		})
+	})
+
+	context("failure cases", func() {
+		context("when an -env value has no name", func() {
+			it("returns an error", func() {
+				_, err := internal.ParseArgs([]string{"-env", "=value"}, output)
+				Expect(err).To(MatchError(`invalid value "=value" for flag -env: must be KEY=VALUE`))
+			})
+		})
+
+		context("when a -plan-entry is not a JSON object", func() {
+			it("returns an error", func() {
+				_, err := internal.ParseArgs([]string{"-plan-entry", "4.*"}, output)
+				Expect(err).To(MatchError(ContainSubstring(`invalid value "4.*" for flag -plan-entry: must be a JSON object`)))
+			})
+		})
+
+		context("when the format is unknown", func() {
+			it("returns an error", func() {
+				_, err := internal.ParseArgs([]string{"-format", "yaml"}, output)
+				Expect(err).To(MatchError("invalid -format yaml: must be text or json"))
+			})
+		})
+
+		context("when there are positional arguments", func() {
+			it("returns an error", func() {
+				_, err := internal.ParseArgs([]string{"some-app"}, output)
+				Expect(err).To(MatchError("unexpected arguments: some-app"))
+			})
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/paketo-buildpacks/yarn"
+)
+
+// Write writes the inspection to output in the given format, text or json.
+func Write(output io.Writer, inspection yarn.Inspection, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(inspection)
+	}
+
+	for i, project := range inspection.Projects {
+		if i > 0 {
+			fmt.Fprintln(output)
+		}
+
+		fmt.Fprintf(output, "Project %s\n", project.Path)
+		fmt.Fprintf(output, "  Dependency: %s\n", project.DependencyID)
+		fmt.Fprintf(output, "  Constraint: %s\n", project.Constraint)
+
+		switch {
+		case project.System:
+			fmt.Fprintln(output, "  Version:    the yarn on the PATH")
+		case project.Error != "":
+			fmt.Fprintf(output, "  Error:      %s\n", project.Error)
+		default:
+			fmt.Fprintf(output, "  Version:    %s\n", project.Version)
+			fmt.Fprintf(output, "  Checksum:   %s\n", project.Checksum)
+			fmt.Fprintf(output, "  URI:        %s\n", project.URI)
+		}
+
+		fmt.Fprintln(output, "  Decisions:")
+		for _, decision := range project.Trail {
+			fmt.Fprintf(output, "    - %s\n", decision)
+		}
+	}
+
+	return nil
+}
+
+// Failed reports whether the dependency of any of the projects could not be
+// resolved.
+func Failed(inspection yarn.Inspection) bool {
+	for _, project := range inspection.Projects {
+		if project.Error != "" {
+			return true
+		}
+	}
+
+	return false
+}
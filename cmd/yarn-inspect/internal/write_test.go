@@ -0,0 +1,106 @@
+package internal_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/paketo-buildpacks/yarn"
+	"github.com/paketo-buildpacks/yarn/cmd/yarn-inspect/internal"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testWrite(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		output     *bytes.Buffer
+		inspection yarn.Inspection
+	)
+
+	it.Before(func() {
+		output = bytes.NewBuffer(nil)
+		inspection = yarn.Inspection{
+			Projects: []yarn.ProjectInspection{
+				{
+					Path:         "/workspace/legacy",
+					DependencyID: "yarn",
+					Constraint:   "default",
+					Version:      "1.22.22",
+					Checksum:     "sha256:some-sha",
+					URI:          "some-uri",
+					Trail:        []string{"first decision", "second decision"},
+				},
+				{
+					Path:         "/workspace/frontend",
+					DependencyID: "berry",
+					Constraint:   ">=4.18",
+					Trail:        []string{"some decision"},
+					Error:        "some-error",
+				},
+			},
+		}
+	})
+
+	it("writes the inspection as text", func() {
+		Expect(internal.Write(output, inspection, "text")).To(Succeed())
+		Expect(output.String()).To(Equal(`Project /workspace/legacy
+  Dependency: yarn
+  Constraint: default
+  Version:    1.22.22
+  Checksum:   sha256:some-sha
+  URI:        some-uri
+  Decisions:
+    - first decision
+    - second decision
+
+Project /workspace/frontend
+  Dependency: berry
+  Constraint: >=4.18
+  Error:      some-error
+  Decisions:
+    - some decision
+`))
+		Expect(internal.Failed(inspection)).To(BeTrue())
+	})
+
+	it("writes the inspection as JSON", func() {
+		Expect(internal.Write(output, inspection, "json")).To(Succeed())
+		Expect(output.String()).To(MatchJSON(`{
+			"projects": [
+				{
+					"path": "/workspace/legacy",
+					"dependency-id": "yarn",
+					"constraint": "default",
+					"version": "1.22.22",
+					"checksum": "sha256:some-sha",
+					"uri": "some-uri",
+					"trail": ["first decision", "second decision"]
+				},
+				{
+					"path": "/workspace/frontend",
+					"dependency-id": "berry",
+					"constraint": ">=4.18",
+					"trail": ["some decision"],
+					"error": "some-error"
+				}
+			]
+		}`))
+		Expect(output.String()).To(ContainSubstring(`">=4.18"`))
+	})
+
+	context("when the yarn on the PATH is used", func() {
+		it.Before(func() {
+			inspection.Projects = []yarn.ProjectInspection{
+				{Path: "/workspace", DependencyID: "yarn", Constraint: "1.*", System: true},
+			}
+		})
+
+		it("says so", func() {
+			Expect(internal.Write(output, inspection, "text")).To(Succeed())
+			Expect(output.String()).To(ContainSubstring("  Version:    the yarn on the PATH\n"))
+			Expect(internal.Failed(inspection)).To(BeFalse())
+		})
+	})
+}
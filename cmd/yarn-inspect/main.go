@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/yarn"
+	"github.com/paketo-buildpacks/yarn/cmd/yarn-inspect/internal"
+)
+
+func main() {
+	options, err := internal.ParseArgs(os.Args[1:], os.Stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// The resolution reads its configuration from the environment, as it
+	// does during a build.
+	for name, value := range options.Env {
+		err = os.Setenv(name, value)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// Resolving a dependency only reads buildpack.toml, so nothing is
+	// downloaded.
+	inspection, err := yarn.Inspect(postal.NewService(yarn.NewTransport()), yarn.NewVersionResolver(yarn.DefaultVersionSources()...), options.App, options.Buildpack, options.Stack, options.Plan)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	err = internal.Write(os.Stdout, inspection, options.Format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if internal.Failed(inspection) {
+		os.Exit(1)
+	}
+}
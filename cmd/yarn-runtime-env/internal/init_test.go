@@ -0,0 +1,14 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitYarnRuntimeEnv(t *testing.T) {
+	suite := spec.New("yarn-runtime-env", spec.Report(report.Terminal{}), spec.Parallel())
+	suite("Run", testRun)
+	suite.Run(t)
+}
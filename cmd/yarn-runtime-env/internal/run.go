@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
+)
+
+// BindingType is the type of the bindings whose entries are applied as Yarn
+// settings at launch.
+const BindingType = "yarn"
+
+// bindingEntryName matches the binding entries that are applied: the names
+// of the environment variables Yarn reads its settings from.
+var bindingEntryName = regexp.MustCompile(`^YARN_[A-Z0-9_]+$`)
+
+// homeDirs are the base directories Yarn writes to outside of its own
+// folders, with the directory under $TMPDIR/yarn they are moved to when they
+// cannot be written to. Yarn Classic records its update checks in ~/.yarnrc
+// and keeps its config, cache and link folders in the XDG base directories.
+var homeDirs = map[string]string{
+	"HOME":            "home",
+	"XDG_CACHE_HOME":  "xdg-cache",
+	"XDG_CONFIG_HOME": "xdg-config",
+	"XDG_DATA_HOME":   "xdg-data",
+}
+
+// Run writes the environment that makes Yarn usable on a read-only root
+// filesystem and with an arbitrary UID to output, in the TOML format of
+// exec.d. Both Yarn Classic and Yarn Berry read the cache and global folders
+// from YARN_CACHE_FOLDER and YARN_GLOBAL_FOLDER, which are pointed at
+// $TMPDIR/yarn; the global folder also holds what Berry otherwise writes to
+// ~/.yarn/berry. Berry writes its install state next to the project unless
+// YARN_INSTALL_STATE_PATH moves it, so it is moved to $TMPDIR/yarn as well.
+// The YARN_* entries of the bindings of type yarn are added too, so that
+// settings such as YARN_NPM_AUTH_TOKEN can be supplied at runtime. Variables
+// already set in the environment are left alone, except for HOME and the XDG
+// base directories: those are moved under $TMPDIR/yarn, which is created,
+// when they cannot be written to, and HOME when it is not set.
+func Run(environment map[string]string, bindings []servicebindings.Binding, output io.Writer) error {
+	tmpDir := environment["TMPDIR"]
+	if tmpDir == "" {
+		tmpDir = "/tmp"
+	}
+	yarnDir := filepath.Join(tmpDir, "yarn")
+
+	env := map[string]string{
+		"YARN_CACHE_FOLDER":       filepath.Join(yarnDir, "cache"),
+		"YARN_GLOBAL_FOLDER":      filepath.Join(yarnDir, "global"),
+		"YARN_INSTALL_STATE_PATH": filepath.Join(yarnDir, "install-state.gz"),
+	}
+
+	for _, binding := range bindings {
+		var names []string
+		for name := range binding.Entries {
+			if bindingEntryName.MatchString(name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value, err := binding.Entries[name].ReadString()
+			if err != nil {
+				return fmt.Errorf("failed to read entry %s of binding %s: %w", name, binding.Name, err)
+			}
+
+			env[name] = strings.TrimRight(value, "\r\n")
+		}
+	}
+
+	for name := range env {
+		if environment[name] != "" {
+			delete(env, name)
+		}
+	}
+
+	for name, dir := range homeDirs {
+		value := environment[name]
+		if (value == "" && name != "HOME") || (value != "" && writable(value)) {
+			continue
+		}
+
+		path := filepath.Join(yarnDir, dir)
+		err := os.MkdirAll(path, 0700)
+		if err != nil {
+			return fmt.Errorf("failed to create %s for %s: %w", path, name, err)
+		}
+
+		env[name] = path
+	}
+
+	return toml.NewEncoder(output).Encode(env)
+}
+
+// writable reports whether a file can be created in dir.
+func writable(dir string) bool {
+	file, err := os.CreateTemp(dir, ".yarn-runtime-env-*")
+	if err != nil {
+		return false
+	}
+
+	_ = file.Close()
+	_ = os.Remove(file.Name())
+
+	return true
+}
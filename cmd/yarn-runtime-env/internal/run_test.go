@@ -0,0 +1,165 @@
+package internal_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
+	"github.com/paketo-buildpacks/yarn/cmd/yarn-runtime-env/internal"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testRun(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		environment map[string]string
+		bindings    []servicebindings.Binding
+		output      *bytes.Buffer
+	)
+
+	it.Before(func() {
+		environment = map[string]string{"HOME": t.TempDir()}
+		output = bytes.NewBuffer(nil)
+	})
+
+	it("points the Yarn folders and the Berry install state at /tmp", func() {
+		Expect(internal.Run(environment, bindings, output)).To(Succeed())
+		Expect(output.String()).To(Equal(`YARN_CACHE_FOLDER = "/tmp/yarn/cache"
+YARN_GLOBAL_FOLDER = "/tmp/yarn/global"
+YARN_INSTALL_STATE_PATH = "/tmp/yarn/install-state.gz"
+`))
+	})
+
+	context("when TMPDIR is set", func() {
+		it.Before(func() {
+			environment["TMPDIR"] = "/some/tmp"
+		})
+
+		it("points the Yarn folders at it", func() {
+			Expect(internal.Run(environment, bindings, output)).To(Succeed())
+			Expect(output.String()).To(ContainSubstring(`YARN_CACHE_FOLDER = "/some/tmp/yarn/cache"`))
+			Expect(output.String()).To(ContainSubstring(`YARN_GLOBAL_FOLDER = "/some/tmp/yarn/global"`))
+			Expect(output.String()).To(ContainSubstring(`YARN_INSTALL_STATE_PATH = "/some/tmp/yarn/install-state.gz"`))
+		})
+	})
+
+	context("when HOME cannot be written to", func() {
+		var tmpDir string
+
+		it.Before(func() {
+			tmpDir = t.TempDir()
+			environment["TMPDIR"] = tmpDir
+
+			Expect(os.Chmod(environment["HOME"], 0555)).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Chmod(environment["HOME"], 0755)).To(Succeed())
+		})
+
+		it("points HOME at a directory it creates under TMPDIR", func() {
+			Expect(internal.Run(environment, bindings, output)).To(Succeed())
+
+			home := filepath.Join(tmpDir, "yarn", "home")
+			Expect(output.String()).To(ContainSubstring(fmt.Sprintf("HOME = %q", home)))
+			Expect(home).To(BeADirectory())
+			Expect(os.WriteFile(filepath.Join(home, ".yarnrc"), nil, 0600)).To(Succeed())
+		})
+
+		context("when the XDG base directories are set", func() {
+			it.Before(func() {
+				environment["XDG_CACHE_HOME"] = filepath.Join(environment["HOME"], ".cache")
+				environment["XDG_CONFIG_HOME"] = t.TempDir()
+			})
+
+			it("moves the ones that cannot be written to", func() {
+				Expect(internal.Run(environment, bindings, output)).To(Succeed())
+				Expect(output.String()).To(ContainSubstring(fmt.Sprintf("XDG_CACHE_HOME = %q", filepath.Join(tmpDir, "yarn", "xdg-cache"))))
+				Expect(output.String()).NotTo(ContainSubstring("XDG_CONFIG_HOME"))
+				Expect(output.String()).NotTo(ContainSubstring("XDG_DATA_HOME"))
+			})
+		})
+	})
+
+	context("when HOME is not set", func() {
+		it.Before(func() {
+			delete(environment, "HOME")
+			environment["TMPDIR"] = t.TempDir()
+		})
+
+		it("points HOME at a directory under TMPDIR", func() {
+			Expect(internal.Run(environment, bindings, output)).To(Succeed())
+			Expect(output.String()).To(ContainSubstring(fmt.Sprintf("HOME = %q", filepath.Join(environment["TMPDIR"], "yarn", "home"))))
+		})
+	})
+
+	context("when the user has set a folder", func() {
+		it.Before(func() {
+			environment["YARN_CACHE_FOLDER"] = "/some/cache"
+		})
+
+		it("leaves it alone", func() {
+			Expect(internal.Run(environment, bindings, output)).To(Succeed())
+			Expect(output.String()).To(Equal(`YARN_GLOBAL_FOLDER = "/tmp/yarn/global"
+YARN_INSTALL_STATE_PATH = "/tmp/yarn/install-state.gz"
+`))
+		})
+	})
+
+	context("when there are yarn bindings", func() {
+		it.Before(func() {
+			bindings = []servicebindings.Binding{
+				{
+					Name: "some-binding",
+					Type: "yarn",
+					Entries: map[string]*servicebindings.Entry{
+						"YARN_NPM_AUTH_TOKEN":   servicebindings.NewWithValue([]byte("some-token\n")),
+						"YARN_GLOBAL_FOLDER":    servicebindings.NewWithValue([]byte("/some/global")),
+						"YARN_ENABLE_TELEMETRY": servicebindings.NewWithValue([]byte("0")),
+						"type":                  servicebindings.NewWithValue([]byte("yarn")),
+					},
+				},
+			}
+		})
+
+		it("applies their YARN_* entries", func() {
+			Expect(internal.Run(environment, bindings, output)).To(Succeed())
+			Expect(output.String()).To(Equal(`YARN_CACHE_FOLDER = "/tmp/yarn/cache"
+YARN_ENABLE_TELEMETRY = "0"
+YARN_GLOBAL_FOLDER = "/some/global"
+YARN_INSTALL_STATE_PATH = "/tmp/yarn/install-state.gz"
+YARN_NPM_AUTH_TOKEN = "some-token"
+`))
+		})
+
+		context("when the user has set one of them", func() {
+			it.Before(func() {
+				environment["YARN_ENABLE_TELEMETRY"] = "1"
+			})
+
+			it("leaves it alone", func() {
+				Expect(internal.Run(environment, bindings, output)).To(Succeed())
+				Expect(output.String()).NotTo(ContainSubstring("YARN_ENABLE_TELEMETRY"))
+			})
+		})
+
+		context("failure cases", func() {
+			context("when an entry cannot be read", func() {
+				it.Before(func() {
+					bindings[0].Entries["YARN_NPM_AUTH_TOKEN"] = servicebindings.NewEntry("/no/such/entry")
+				})
+
+				it("returns an error", func() {
+					err := internal.Run(environment, bindings, output)
+					Expect(err).To(MatchError(ContainSubstring("failed to read entry YARN_NPM_AUTH_TOKEN of binding some-binding")))
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
+	"github.com/paketo-buildpacks/yarn/cmd/yarn-runtime-env/internal"
+)
+
+// platformDir is where the bindings are found at launch when neither
+// SERVICE_BINDING_ROOT nor CNB_BINDINGS is set.
+const platformDir = "/platform"
+
+func main() {
+	environment := map[string]string{}
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		environment[name] = value
+	}
+
+	bindings, err := servicebindings.NewResolver().Resolve(internal.BindingType, "", platformDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to resolve %s bindings: %s\n", internal.BindingType, err)
+		os.Exit(1)
+	}
+
+	// The exec.d interface reads the environment from file descriptor 3.
+	err = internal.Run(environment, bindings, os.NewFile(3, "/dev/fd/3"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -1,8 +1,15 @@
 package yarn
 
 const (
-	YarnLayerName      = "yarn"
-	YarnDependency     = "yarn"
-	BerryDependency    = "berry"
-	DependencyCacheKey = "dependency-sha"
+	YarnLayerName           = "yarn"
+	ArtifactCacheLayerName  = "yarn-artifacts"
+	GlobalPackagesLayerName = "yarn-global"
+	YarnDependency          = "yarn"
+	BerryDependency         = "berry"
+	DependencyCacheKey      = "dependency-sha"
+
+	// RuntimeEnvExecutable is the exec.d executable, built from
+	// cmd/yarn-runtime-env, that launch layers use to point Yarn at writable
+	// folders.
+	RuntimeEnvExecutable = "yarn-runtime-env"
 )
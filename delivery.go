@@ -0,0 +1,307 @@
+package yarn
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/cargo"
+	"github.com/paketo-buildpacks/packit/v2/chronos"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+const (
+	defaultDownloadRetries = 2
+	defaultDownloadBackoff = 2 * time.Second
+	defaultDownloadTimeout = 5 * time.Minute
+)
+
+// DeliveryPhase identifies the part of a dependency delivery that failed.
+type DeliveryPhase string
+
+const (
+	DeliveryPhaseDownload   DeliveryPhase = "download"
+	DeliveryPhaseChecksum   DeliveryPhase = "checksum verification"
+	DeliveryPhaseExtraction DeliveryPhase = "extraction"
+)
+
+// DeliveryError is returned when a dependency could not be delivered after
+// all attempts were exhausted.
+type DeliveryError struct {
+	Phase    DeliveryPhase
+	Attempts int
+	Err      error
+}
+
+func (e DeliveryError) Error() string {
+	return fmt.Sprintf("failed to deliver dependency during %s after %d attempt(s): %s", e.Phase, e.Attempts, e.Err)
+}
+
+func (e DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// DownloadError is returned by DeliveryService when a dependency could not be
+// fetched.
+type DownloadError struct {
+	Err error
+}
+
+func (e DownloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumError is returned by DeliveryService when the checksum of a fetched
+// dependency does not match the one in buildpack.toml.
+type ChecksumError struct {
+	Err error
+}
+
+func (e ChecksumError) Error() string {
+	return e.Err.Error()
+}
+
+func (e ChecksumError) Unwrap() error {
+	return e.Err
+}
+
+// SleeperFunc adapts a function such as time.Sleep to a Sleeper.
+type SleeperFunc func(duration time.Duration)
+
+func (f SleeperFunc) Sleep(duration time.Duration) {
+	f(duration)
+}
+
+// DeliveryPolicy controls how often a failed dependency delivery is retried,
+// how long to wait between attempts and how long a single download may take.
+// The wait doubles after every failed attempt. A Timeout of 0 disables the
+// per-attempt timeout.
+type DeliveryPolicy struct {
+	Retries int
+	Backoff time.Duration
+	Timeout time.Duration
+}
+
+// deliveryPolicyFromEnv reads the delivery policy from
+// BP_YARN_DOWNLOAD_RETRIES, BP_YARN_DOWNLOAD_BACKOFF and
+// BP_YARN_DOWNLOAD_TIMEOUT.
+func deliveryPolicyFromEnv() (DeliveryPolicy, error) {
+	policy := DeliveryPolicy{
+		Retries: defaultDownloadRetries,
+		Backoff: defaultDownloadBackoff,
+		Timeout: defaultDownloadTimeout,
+	}
+
+	if value, ok := os.LookupEnv("BP_YARN_DOWNLOAD_RETRIES"); ok {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return DeliveryPolicy{}, fmt.Errorf("failed to parse BP_YARN_DOWNLOAD_RETRIES value %s: must be a non-negative integer", value)
+		}
+		policy.Retries = retries
+	}
+
+	if value, ok := os.LookupEnv("BP_YARN_DOWNLOAD_BACKOFF"); ok {
+		backoff, err := time.ParseDuration(value)
+		if err != nil || backoff < 0 {
+			return DeliveryPolicy{}, fmt.Errorf("failed to parse BP_YARN_DOWNLOAD_BACKOFF value %s: must be a non-negative duration", value)
+		}
+		policy.Backoff = backoff
+	}
+
+	if value, ok := os.LookupEnv("BP_YARN_DOWNLOAD_TIMEOUT"); ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return DeliveryPolicy{}, fmt.Errorf("failed to parse BP_YARN_DOWNLOAD_TIMEOUT value %s: must be a non-negative duration", value)
+		}
+		policy.Timeout = timeout
+	}
+
+	return policy, nil
+}
+
+// Deliverer delivers a dependency into a layer, like postal.Service.
+type Deliverer interface {
+	Deliver(dependency postal.Dependency, cnbPath, layerPath, platformPath string) error
+}
+
+// DelivererFunc adapts a function to a Deliverer.
+type DelivererFunc func(dependency postal.Dependency, cnbPath, layerPath, platformPath string) error
+
+func (f DelivererFunc) Deliver(dependency postal.Dependency, cnbPath, layerPath, platformPath string) error {
+	return f(dependency, cnbPath, layerPath, platformPath)
+}
+
+// deliverWithRetries delivers the dependency into layerPath, retrying
+// download and extraction failures according to the policy. Checksum
+// mismatches are not retried since fetching the same artifact again will not
+// change its contents.
+func deliverWithRetries(
+	dependencyManager Deliverer,
+	dependency postal.Dependency,
+	cnbPath, layerPath, platformPath string,
+	policy DeliveryPolicy,
+	clock chronos.Clock,
+	sleeper Sleeper,
+	logger scribe.Emitter,
+	report *BuildReport,
+) error {
+	attempts := policy.Retries + 1
+	backoff := policy.Backoff
+
+	for attempt := 1; ; attempt++ {
+		duration, err := clock.Measure(func() error {
+			return dependencyManager.Deliver(dependency, cnbPath, layerPath, platformPath)
+		})
+		if err == nil {
+			if attempt == 1 {
+				logger.Action("Completed in %s", duration.Round(time.Millisecond))
+			} else {
+				logger.Action("Completed in %s on attempt %d of %d", duration.Round(time.Millisecond), attempt, attempts)
+			}
+			return nil
+		}
+
+		phase := deliveryPhase(err)
+		logger.Action("Attempt %d of %d failed during %s after %s: %s", attempt, attempts, phase, duration.Round(time.Millisecond), err)
+		report.Warn("attempt %d of %d to deliver %s %s failed during %s: %s", attempt, attempts, dependency.ID, dependency.Version, phase, err)
+
+		if attempt == attempts || phase == DeliveryPhaseChecksum {
+			return DeliveryError{Phase: phase, Attempts: attempt, Err: err}
+		}
+
+		// Clear out anything extracted by the failed attempt so that the next
+		// one starts from an empty layer.
+		err = resetDir(layerPath)
+		if err != nil {
+			return fmt.Errorf("failed to clean up after delivery attempt: %w", err)
+		}
+
+		if backoff > 0 {
+			logger.Action("Retrying in %s", backoff)
+			sleeper.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// deliveryPhase classifies a delivery error. Errors that are neither a
+// DownloadError nor a ChecksumError are attributed to the extraction.
+func deliveryPhase(err error) DeliveryPhase {
+	var checksumErr ChecksumError
+	if errors.As(err, &checksumErr) {
+		return DeliveryPhaseChecksum
+	}
+
+	var downloadErr DownloadError
+	if errors.As(err, &downloadErr) {
+		return DeliveryPhaseDownload
+	}
+
+	return DeliveryPhaseExtraction
+}
+
+// DeliveryService is a postal.Service whose Deliver tells the failures of a
+// delivery apart. postal.Service formats the errors of its transport and of
+// the checksum verification into plain messages, so DeliveryService watches
+// the archive as it is fetched and returns a DownloadError when it could not
+// be fetched in full, and a ChecksumError when its checksum does not match.
+type DeliveryService struct {
+	postal.Service
+
+	transport postal.Transport
+}
+
+func NewDeliveryService(transport postal.Transport) DeliveryService {
+	return DeliveryService{
+		Service:   postal.NewService(transport),
+		transport: transport,
+	}
+}
+
+func (s DeliveryService) Deliver(dependency postal.Dependency, cnbPath, layerPath, platformPath string) error {
+	fetch := &watchedTransport{transport: s.transport}
+
+	err := postal.NewService(fetch).Deliver(dependency, cnbPath, layerPath, platformPath)
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case !fetch.dropped, fetch.err != nil:
+		return DownloadError{Err: err}
+
+	case fetch.done && !fetch.matches(dependencyChecksum(dependency)):
+		return ChecksumError{Err: err}
+	}
+
+	return err
+}
+
+// watchedTransport records whether the archive it fetches could be read to
+// the end and hashes its contents.
+type watchedTransport struct {
+	transport postal.Transport
+
+	dropped bool
+	done    bool
+	err     error
+	hashes  map[string]hash.Hash
+}
+
+func (t *watchedTransport) Drop(root, uri string) (io.ReadCloser, error) {
+	t.dropped = true
+
+	bundle, err := t.transport.Drop(root, uri)
+	if err != nil {
+		t.err = err
+		return nil, err
+	}
+
+	t.hashes = map[string]hash.Hash{"sha256": sha256.New(), "sha512": sha512.New()}
+
+	return watchedReader{ReadCloser: bundle, transport: t}, nil
+}
+
+// matches reports whether the fetched archive has the given checksum.
+func (t *watchedTransport) matches(checksum string) bool {
+	hash, ok := t.hashes[cargo.Checksum(checksum).Algorithm()]
+	if !ok {
+		return true
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)) == cargo.Checksum(checksum).Hash()
+}
+
+type watchedReader struct {
+	io.ReadCloser
+
+	transport *watchedTransport
+}
+
+func (r watchedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	for _, hash := range r.transport.hashes {
+		hash.Write(p[:n])
+	}
+
+	switch {
+	case err == io.EOF:
+		r.transport.done = true
+	case err != nil:
+		r.transport.err = err
+	}
+
+	return n, err
+}
@@ -0,0 +1,147 @@
+package yarn_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/yarn"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+// archiveTransport serves archives by URI and counts the downloads.
+type archiveTransport struct {
+	archives map[string][]byte
+	drops    *int
+}
+
+func (t archiveTransport) Drop(_, uri string) (io.ReadCloser, error) {
+	*t.drops++
+
+	archive, ok := t.archives[uri]
+	if !ok {
+		return nil, errors.New("failed to download " + uri)
+	}
+
+	return io.NopCloser(bytes.NewReader(archive)), nil
+}
+
+func testDeliveryService(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		layerPath  string
+		drops      int
+		transport  archiveTransport
+		dependency postal.Dependency
+		service    yarn.DeliveryService
+	)
+
+	it.Before(func() {
+		var err error
+		layerPath, err = os.MkdirTemp("", "layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		archive := tarball(t, map[string]string{"bin/yarn.js": "some-content"})
+		transport = archiveTransport{archives: map[string][]byte{"some-uri": archive}, drops: &drops}
+		dependency = postal.Dependency{
+			ID:       "yarn",
+			Name:     "yarn.tgz",
+			URI:      "some-uri",
+			Checksum: fmt.Sprintf("sha256:%x", sha256.Sum256(archive)),
+		}
+
+		service = yarn.NewDeliveryService(transport)
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(layerPath)).To(Succeed())
+	})
+
+	it("delivers the dependency", func() {
+		Expect(service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-path")).To(Succeed())
+		Expect(filepath.Join(layerPath, "bin", "yarn.js")).To(BeARegularFile())
+	})
+
+	context("failure cases", func() {
+		context("when the dependency cannot be fetched", func() {
+			it("returns a DownloadError", func() {
+				dependency.URI = "some-missing-uri"
+
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-path")
+				Expect(err).To(MatchError(ContainSubstring("failed to fetch dependency: failed to download some-missing-uri")))
+
+				var downloadErr yarn.DownloadError
+				Expect(errors.As(err, &downloadErr)).To(BeTrue())
+			})
+		})
+
+		context("when the checksum does not match", func() {
+			it("returns a ChecksumError", func() {
+				dependency.Checksum = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("something-else")))
+
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-path")
+				Expect(err).To(MatchError(ContainSubstring("checksum does not match")))
+
+				var checksumErr yarn.ChecksumError
+				Expect(errors.As(err, &checksumErr)).To(BeTrue())
+			})
+		})
+
+		context("when the archive cannot be extracted", func() {
+			it("returns neither a DownloadError nor a ChecksumError", func() {
+				truncated := transport.archives["some-uri"][:20]
+				transport.archives["some-uri"] = truncated
+				dependency.Checksum = fmt.Sprintf("sha256:%x", sha256.Sum256(truncated))
+
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-path")
+				Expect(err).To(HaveOccurred())
+
+				var downloadErr yarn.DownloadError
+				Expect(errors.As(err, &downloadErr)).To(BeFalse())
+
+				var checksumErr yarn.ChecksumError
+				Expect(errors.As(err, &checksumErr)).To(BeFalse())
+			})
+		})
+	})
+}
+
+// tarball returns a gzipped tar archive holding the given files.
+func tarball(t *testing.T, files map[string]string) []byte {
+	buffer := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buffer)
+	tw := tar.NewWriter(gw)
+
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content))})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = tw.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
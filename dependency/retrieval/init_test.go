@@ -0,0 +1,15 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitRetrieval(t *testing.T) {
+	suite := spec.New("retrieval", spec.Report(report.Terminal{}), spec.Parallel())
+	suite("LicenseFiles", testLicenseFiles)
+	suite("NodeRanges", testNodeRanges)
+	suite.Run(t)
+}
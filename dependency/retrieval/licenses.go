@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/paketo-buildpacks/libdependency/versionology"
+)
+
+// licenseFile is the upstream license file of a Yarn flavor whose tarball
+// does not ship one. The buildpack carries the file, and the build copies it
+// into the yarn layer.
+type licenseFile struct {
+	// path is where the buildpack carries the file, relative to buildpack.toml.
+	path string
+
+	// url returns where the upstream repository publishes the file for a
+	// release.
+	url func(version string) string
+}
+
+// licenseFiles lists the license files the buildpack carries, by dependency
+// ID. The Classic tarball ships its LICENSE and needs none.
+var licenseFiles = map[string]licenseFile{
+	berryDependencyID: {
+		path: "licenses/berry/LICENSE.md",
+		url: func(version string) string {
+			return fmt.Sprintf("https://raw.githubusercontent.com/yarnpkg/berry/%s%s/LICENSE.md", berryTagPrefix, version)
+		},
+	},
+}
+
+// LicenseGetter fetches the content at a URL.
+type LicenseGetter interface {
+	Get(url string, options ...RequestOption) ([]byte, error)
+}
+
+// ensureLicenseFiles makes sure that the buildpack carries the upstream
+// license file of every generated dependency that needs one. A missing file
+// is downloaded from the release of the dependency, and an existing one must
+// match it. Each file must also be listed in the include-files of the
+// buildpack, or the packaged buildpack would not contain it.
+func ensureLicenseFiles(getter LicenseGetter, buildpackDir string, includeFiles []string, dependencies []versionology.Dependency) error {
+	checked := map[string]bool{}
+	for _, dependency := range dependencies {
+		file, ok := licenseFiles[dependency.ID]
+		version := dependency.ConfigMetadataDependency.Version
+		if !ok || checked[dependency.ID+"@"+version] {
+			continue
+		}
+		checked[dependency.ID+"@"+version] = true
+
+		err := ensureLicenseFile(getter, buildpackDir, file, dependency.ID, version)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(includeFiles, file.path) {
+			return fmt.Errorf("license file %s is not listed in the include-files of buildpack.toml", file.path)
+		}
+	}
+
+	return nil
+}
+
+func ensureLicenseFile(getter LicenseGetter, buildpackDir string, file licenseFile, id, version string) error {
+	upstream, err := getter.Get(file.url(version))
+	if err != nil {
+		return fmt.Errorf("could not get license file of %s %s: %w", id, version, err)
+	}
+
+	path := filepath.Join(buildpackDir, file.path)
+	existing, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not read license file %s: %w", path, err)
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("could not create licenses directory: %w", err)
+		}
+
+		err = os.WriteFile(path, upstream, 0644)
+		if err != nil {
+			return fmt.Errorf("could not write license file %s: %w", path, err)
+		}
+
+		fmt.Printf("Wrote license file of %s %s to %s\n", id, version, path)
+		return nil
+	}
+
+	// The files are compared word by word, as the line wrapping is not
+	// significant.
+	if !slices.Equal(strings.Fields(string(existing)), strings.Fields(string(upstream))) {
+		return fmt.Errorf("license file %s does not match the one of %s %s, update it to the upstream file", path, id, version)
+	}
+
+	return nil
+}
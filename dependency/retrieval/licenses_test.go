@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/libdependency/versionology"
+	"github.com/paketo-buildpacks/packit/v2/cargo"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+type fakeLicenseGetter struct {
+	urls    []string
+	content string
+	err     error
+}
+
+func (g *fakeLicenseGetter) Get(url string, _ ...RequestOption) ([]byte, error) {
+	g.urls = append(g.urls, url)
+	return []byte(g.content), g.err
+}
+
+func testLicenseFiles(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		buildpackDir string
+		getter       *fakeLicenseGetter
+		includeFiles []string
+		dependencies []versionology.Dependency
+	)
+
+	it.Before(func() {
+		var err error
+		buildpackDir, err = os.MkdirTemp("", "buildpack")
+		Expect(err).NotTo(HaveOccurred())
+
+		getter = &fakeLicenseGetter{content: "BSD 2-Clause License\n\nsome license text"}
+		includeFiles = []string{"buildpack.toml", "licenses/berry/LICENSE.md"}
+
+		dependency := func(id, version, arch string) versionology.Dependency {
+			return versionology.Dependency{
+				ConfigMetadataDependency: cargo.ConfigMetadataDependency{ID: id, Version: version, Arch: arch},
+			}
+		}
+		dependencies = []versionology.Dependency{
+			dependency("berry", "4.14.1", "amd64"),
+			dependency("berry", "4.14.1", "arm64"),
+			dependency("yarn", "1.22.22", "amd64"),
+		}
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+	})
+
+	it("writes a missing license file from the release", func() {
+		err := ensureLicenseFiles(getter, buildpackDir, includeFiles, dependencies)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(getter.urls).To(Equal([]string{
+			"https://raw.githubusercontent.com/yarnpkg/berry/@yarnpkg/cli/4.14.1/LICENSE.md",
+		}))
+
+		content, err := os.ReadFile(filepath.Join(buildpackDir, "licenses", "berry", "LICENSE.md"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("BSD 2-Clause License\n\nsome license text"))
+	})
+
+	context("when the license file matches the release", func() {
+		it.Before(func() {
+			Expect(os.MkdirAll(filepath.Join(buildpackDir, "licenses", "berry"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildpackDir, "licenses", "berry", "LICENSE.md"), []byte("BSD 2-Clause License\nsome license\ntext\n"), 0644)).To(Succeed())
+		})
+
+		it("leaves it as it is", func() {
+			err := ensureLicenseFiles(getter, buildpackDir, includeFiles, dependencies)
+			Expect(err).NotTo(HaveOccurred())
+
+			content, err := os.ReadFile(filepath.Join(buildpackDir, "licenses", "berry", "LICENSE.md"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("BSD 2-Clause License\nsome license\ntext\n"))
+		})
+	})
+
+	context("failure cases", func() {
+		context("when the license file does not match the release", func() {
+			it.Before(func() {
+				Expect(os.MkdirAll(filepath.Join(buildpackDir, "licenses", "berry"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildpackDir, "licenses", "berry", "LICENSE.md"), []byte("other license text"), 0644)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				err := ensureLicenseFiles(getter, buildpackDir, includeFiles, dependencies)
+				Expect(err).To(MatchError(ContainSubstring("does not match the one of berry 4.14.1")))
+			})
+		})
+
+		context("when the license file cannot be fetched", func() {
+			it.Before(func() {
+				getter.err = errors.New("some-error")
+			})
+
+			it("returns an error", func() {
+				err := ensureLicenseFiles(getter, buildpackDir, includeFiles, dependencies)
+				Expect(err).To(MatchError("could not get license file of berry 4.14.1: some-error"))
+			})
+		})
+
+		context("when the license file is not in the include-files", func() {
+			it.Before(func() {
+				includeFiles = []string{"buildpack.toml"}
+			})
+
+			it("returns an error", func() {
+				err := ensureLicenseFiles(getter, buildpackDir, includeFiles, dependencies)
+				Expect(err).To(MatchError("license file licenses/berry/LICENSE.md is not listed in the include-files of buildpack.toml"))
+			})
+		})
+	})
+}
@@ -33,6 +33,23 @@ type Asset struct {
 	BrowserDownloadUrl string `json:"browser_download_url"`
 }
 
+// DependencyMetadata is a generated dependency entry together with the
+// engines.node range of the release. The update-dependencies workflow appends
+// the range to the node-engines table of buildpack.toml so that the build can
+// check Node.js compatibility.
+type DependencyMetadata struct {
+	versionology.Dependency
+	EnginesNode string `json:"engines-node,omitempty"`
+}
+
+// nodeRanges collects the engines.node range of every generated release,
+// keyed by dependency ID and version.
+type nodeRanges map[string]string
+
+func (r nodeRanges) key(id, version string) string {
+	return fmt.Sprintf("%s@%s", id, version)
+}
+
 type YarnMetadata struct {
 	SemverVersion *semver.Version
 }
@@ -45,9 +62,18 @@ func main() {
 	buildpackTomlPath, output := retrieve.FetchArgs()
 	validate(buildpackTomlPath, output)
 
+	err := run(buildpackTomlPath, output)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run generates the metadata of the new Yarn and Berry releases and writes it
+// to output.
+func run(buildpackTomlPath, output string) error {
 	config, err := buildpackConfig.ParseBuildpackToml(buildpackTomlPath)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Default to linux/amd64 if no targets are specified (mirrors NewMetadataWithPlatforms).
@@ -56,18 +82,19 @@ func main() {
 	}
 
 	var allDependencies []versionology.Dependency
+	ranges := nodeRanges{}
 
 	for _, job := range []struct {
 		id       string
 		versions retrieve.GetAllVersionsFunc
 		meta     retrieve.GenerateMetadataWithPlatformFunc
 	}{
-		{yarnDependencyID, getClassicVersions, generateClassicMetadata},
-		{berryDependencyID, getBerryVersions, generateBerryMetadata},
+		{yarnDependencyID, getClassicVersions, generateClassicMetadata(ranges)},
+		{berryDependencyID, getBerryVersions, generateBerryMetadata(ranges)},
 	} {
 		newVersions, err := retrieve.GetNewVersionsForId(job.id, config, job.versions)
 		if err != nil {
-			panic(fmt.Errorf("could not get new versions for %s: %w", job.id, err))
+			return fmt.Errorf("could not get new versions for %s: %w", job.id, err)
 		}
 		for _, target := range config.Targets {
 			platform := retrieve.Platform{OS: target.OS, Arch: target.Arch}
@@ -75,14 +102,26 @@ func main() {
 		}
 	}
 
-	metadataJSON, err := json.Marshal(allDependencies)
+	err = ensureLicenseFiles(NewWebClient(), filepath.Dir(buildpackTomlPath), config.Metadata.IncludeFiles, allDependencies)
+	if err != nil {
+		return fmt.Errorf("could not ensure license files: %w", err)
+	}
+
+	metadata, err := withNodeRanges(allDependencies, ranges)
+	if err != nil {
+		return err
+	}
+
+	metadataJSON, err := json.Marshal(metadata)
 	if err != nil {
-		panic(fmt.Errorf("unable to marshal metadata JSON: %w", err))
+		return fmt.Errorf("unable to marshal metadata JSON: %w", err)
 	}
 	if err = os.WriteFile(output, metadataJSON, os.ModePerm); err != nil {
-		panic(fmt.Errorf("cannot write to %s: %w", output, err))
+		return fmt.Errorf("cannot write to %s: %w", output, err)
 	}
 	fmt.Printf("Wrote metadata to %s\n", output)
+
+	return nil
 }
 
 // validate function, is an exact copy of livedependency/retrieve/validate function
@@ -98,7 +137,25 @@ func validate(buildpackTomlPath, metadataFile string) {
 	}
 }
 
-func generateClassicMetadata(versionFetcher versionology.VersionFetcher, platform retrieve.Platform) ([]versionology.Dependency, error) {
+func generateClassicMetadata(ranges nodeRanges) retrieve.GenerateMetadataWithPlatformFunc {
+	return func(versionFetcher versionology.VersionFetcher, platform retrieve.Platform) ([]versionology.Dependency, error) {
+		version := versionFetcher.Version().String()
+
+		dependencies, err := createClassicMetadata(versionFetcher, platform)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ranges.record(yarnDependencyID, "yarn", version)
+		if err != nil {
+			return nil, err
+		}
+
+		return dependencies, nil
+	}
+}
+
+func createClassicMetadata(versionFetcher versionology.VersionFetcher, platform retrieve.Platform) ([]versionology.Dependency, error) {
 	version := versionFetcher.Version().String()
 	tagName := "v" + version
 
@@ -126,18 +183,85 @@ func generateClassicMetadata(versionFetcher versionology.VersionFetcher, platfor
 	return nil, fmt.Errorf("could not find yarn version %s", version)
 }
 
-func generateBerryMetadata(versionFetcher versionology.VersionFetcher, platform retrieve.Platform) ([]versionology.Dependency, error) {
-	version := versionFetcher.Version().String()
+func generateBerryMetadata(ranges nodeRanges) retrieve.GenerateMetadataWithPlatformFunc {
+	return func(versionFetcher versionology.VersionFetcher, platform retrieve.Platform) ([]versionology.Dependency, error) {
+		version := versionFetcher.Version().String()
 
-	dependency, err := createBerryDependencyVersion(version, platform)
+		dependency, enginesNode, err := createBerryDependencyVersion(version, platform)
+		if err != nil {
+			return nil, fmt.Errorf("could not create berry version: %w", err)
+		}
+
+		err = validateEnginesNode(berryDependencyID, version, enginesNode)
+		if err != nil {
+			return nil, err
+		}
+		ranges[ranges.key(berryDependencyID, version)] = enginesNode
+
+		return []versionology.Dependency{{
+			ConfigMetadataDependency: dependency,
+			SemverVersion:            versionFetcher.Version(),
+		}}, nil
+	}
+}
+
+// withNodeRanges pairs every generated dependency with the engines.node range
+// recorded for its release.
+func withNodeRanges(dependencies []versionology.Dependency, ranges nodeRanges) ([]DependencyMetadata, error) {
+	var metadata []DependencyMetadata
+	for _, dependency := range dependencies {
+		version := dependency.ConfigMetadataDependency.Version
+
+		enginesNode, ok := ranges[ranges.key(dependency.ID, version)]
+		if !ok {
+			return nil, fmt.Errorf("no engines.node range was recorded for %s %s", dependency.ID, version)
+		}
+
+		metadata = append(metadata, DependencyMetadata{
+			Dependency:  dependency,
+			EnginesNode: enginesNode,
+		})
+	}
+
+	return metadata, nil
+}
+
+// record fetches the engines.node range of the given release of an npm
+// package, validates it and stores it for the dependency. Ranges are the
+// same for every platform, so each release is only fetched once.
+func (r nodeRanges) record(id, packageName, version string) error {
+	if _, ok := r[r.key(id, version)]; ok {
+		return nil
+	}
+
+	npmMeta, err := getNpmMetadata(NewWebClient(), packageName, version)
+	if err != nil {
+		return fmt.Errorf("could not get npm registry metadata: %w", err)
+	}
+
+	err = validateEnginesNode(id, version, npmMeta.EnginesNode)
 	if err != nil {
-		return nil, fmt.Errorf("could not create berry version: %w", err)
+		return err
 	}
 
-	return []versionology.Dependency{{
-		ConfigMetadataDependency: dependency,
-		SemverVersion:            versionFetcher.Version(),
-	}}, nil
+	r[r.key(id, version)] = npmMeta.EnginesNode
+	return nil
+}
+
+// validateEnginesNode checks that an engines.node range was published for the
+// release and that it can be parsed by the semver library the build uses to
+// check it.
+func validateEnginesNode(id, version, enginesNode string) error {
+	if enginesNode == "" {
+		return fmt.Errorf("npm registry did not return engines.node for %s %s", id, version)
+	}
+
+	_, err := semver.NewConstraint(enginesNode)
+	if err != nil {
+		return fmt.Errorf("invalid engines.node range %q for %s %s: %w", enginesNode, id, version, err)
+	}
+
+	return nil
 }
 
 func getClassicVersions() (versionology.VersionFetcherArray, error) {
@@ -263,7 +387,7 @@ func createDependencyVersion(version, tagName string, platform retrieve.Platform
 // createBerryDependencyVersion builds a ConfigMetadataDependency for a Berry version.
 // Downloads the @yarnpkg/cli-dist npm tarball which contains the ready-to-run
 // bin/yarn.js bundle (strip-components=1 places bin/ into the layer).
-func createBerryDependencyVersion(version string, platform retrieve.Platform) (cargo.ConfigMetadataDependency, error) {
+func createBerryDependencyVersion(version string, platform retrieve.Platform) (cargo.ConfigMetadataDependency, string, error) {
 	webClient := NewWebClient()
 
 	downloadURL := fmt.Sprintf(
@@ -273,30 +397,30 @@ func createBerryDependencyVersion(version string, platform retrieve.Platform) (c
 
 	tempDir, err := os.MkdirTemp("", "berry")
 	if err != nil {
-		return cargo.ConfigMetadataDependency{}, fmt.Errorf("failed to create temp directory: %w", err)
+		return cargo.ConfigMetadataDependency{}, "", fmt.Errorf("failed to create temp directory: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 
 	tgzPath := filepath.Join(tempDir, fmt.Sprintf("cli-dist-%s.tgz", version))
 	if err = webClient.Download(downloadURL, tgzPath); err != nil {
-		return cargo.ConfigMetadataDependency{}, fmt.Errorf("could not download Berry cli-dist: %w", err)
+		return cargo.ConfigMetadataDependency{}, "", fmt.Errorf("could not download Berry cli-dist: %w", err)
 	}
 
-	npmMeta, err := getNpmMetadata(webClient, version)
+	npmMeta, err := getNpmMetadata(webClient, "@yarnpkg/cli-dist", version)
 	if err != nil {
-		return cargo.ConfigMetadataDependency{}, fmt.Errorf("could not get npm registry metadata: %w", err)
+		return cargo.ConfigMetadataDependency{}, "", fmt.Errorf("could not get npm registry metadata: %w", err)
 	}
 	actualSHA1, err := getSHA1(tgzPath)
 	if err != nil {
-		return cargo.ConfigMetadataDependency{}, fmt.Errorf("could not compute SHA1: %w", err)
+		return cargo.ConfigMetadataDependency{}, "", fmt.Errorf("could not compute SHA1: %w", err)
 	}
 	if actualSHA1 != npmMeta.Shasum {
-		return cargo.ConfigMetadataDependency{}, fmt.Errorf("SHA1 mismatch for cli-dist-%s.tgz: expected %s, got %s", version, npmMeta.Shasum, actualSHA1)
+		return cargo.ConfigMetadataDependency{}, "", fmt.Errorf("SHA1 mismatch for cli-dist-%s.tgz: expected %s, got %s", version, npmMeta.Shasum, actualSHA1)
 	}
 
 	dependencySHA, err := getSHA256(tgzPath)
 	if err != nil {
-		return cargo.ConfigMetadataDependency{}, fmt.Errorf("could not compute SHA256: %w", err)
+		return cargo.ConfigMetadataDependency{}, "", fmt.Errorf("could not compute SHA256: %w", err)
 	}
 
 	return cargo.ConfigMetadataDependency{
@@ -315,7 +439,7 @@ func createBerryDependencyVersion(version string, platform retrieve.Platform) (c
 		Stacks:          []string{"io.buildpacks.stacks.bionic", "io.buildpacks.stacks.jammy", "*"},
 		URI:             downloadURL,
 		Version:         version,
-	}, nil
+	}, npmMeta.EnginesNode, nil
 }
 
 func verifyASC(asc, path string, pgpKeys ...string) error {
@@ -381,14 +505,16 @@ func getSHA1(path string) (string, error) {
 }
 
 type npmMetadata struct {
-	Shasum  string
-	License string
+	Shasum      string
+	License     string
+	EnginesNode string
 }
 
-// getNpmMetadata fetches shasum and license for a Berry version from the npm registry.
-// The cli-dist tarball has no LICENSE file, so license comes from package metadata.
-func getNpmMetadata(webClient WebClient, version string) (npmMetadata, error) {
-	registryURL := fmt.Sprintf("https://registry.npmjs.org/@yarnpkg/cli-dist/%s", version)
+// getNpmMetadata fetches shasum, license and engines.node for a version of an
+// npm package from the npm registry. The cli-dist tarball has no LICENSE file,
+// so license comes from package metadata.
+func getNpmMetadata(webClient WebClient, packageName, version string) (npmMetadata, error) {
+	registryURL := fmt.Sprintf("https://registry.npmjs.org/%s/%s", packageName, version)
 	body, err := webClient.Get(registryURL)
 	if err != nil {
 		return npmMetadata{}, fmt.Errorf("could not fetch npm registry metadata: %w", err)
@@ -399,6 +525,9 @@ func getNpmMetadata(webClient WebClient, version string) (npmMetadata, error) {
 		Dist    struct {
 			Shasum string `json:"shasum"`
 		} `json:"dist"`
+		Engines struct {
+			Node string `json:"node"`
+		} `json:"engines"`
 	}
 	if err := json.Unmarshal(body, &metadata); err != nil {
 		return npmMetadata{}, fmt.Errorf("could not parse npm registry metadata: %w", err)
@@ -411,7 +540,8 @@ func getNpmMetadata(webClient WebClient, version string) (npmMetadata, error) {
 	}
 
 	return npmMetadata{
-		Shasum:  metadata.Dist.Shasum,
-		License: metadata.License,
+		Shasum:      metadata.Dist.Shasum,
+		License:     metadata.License,
+		EnginesNode: metadata.Engines.Node,
 	}, nil
 }
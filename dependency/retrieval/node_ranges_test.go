@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/paketo-buildpacks/libdependency/versionology"
+	"github.com/paketo-buildpacks/packit/v2/cargo"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testNodeRanges(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		dependency func(id, version, arch string) versionology.Dependency
+	)
+
+	it.Before(func() {
+		dependency = func(id, version, arch string) versionology.Dependency {
+			return versionology.Dependency{
+				ConfigMetadataDependency: cargo.ConfigMetadataDependency{ID: id, Version: version, Arch: arch},
+			}
+		}
+	})
+
+	context("withNodeRanges", func() {
+		it("pairs every platform of a release with its range", func() {
+			ranges := nodeRanges{}
+			ranges[ranges.key("berry", "4.14.1")] = ">=18.12.0"
+			ranges[ranges.key("yarn", "1.22.22")] = ">=4.0.0"
+
+			metadata, err := withNodeRanges([]versionology.Dependency{
+				dependency("berry", "4.14.1", "amd64"),
+				dependency("berry", "4.14.1", "arm64"),
+				dependency("yarn", "1.22.22", "amd64"),
+			}, ranges)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(metadata).To(HaveLen(3))
+			Expect(metadata[0].EnginesNode).To(Equal(">=18.12.0"))
+			Expect(metadata[1].EnginesNode).To(Equal(">=18.12.0"))
+			Expect(metadata[2].EnginesNode).To(Equal(">=4.0.0"))
+			Expect(metadata[1].Dependency).To(Equal(dependency("berry", "4.14.1", "arm64")))
+		})
+
+		it("does not mix up releases of different dependencies", func() {
+			ranges := nodeRanges{}
+			ranges[ranges.key("yarn", "4.14.1")] = ">=4.0.0"
+
+			_, err := withNodeRanges([]versionology.Dependency{dependency("berry", "4.14.1", "amd64")}, ranges)
+			Expect(err).To(MatchError("no engines.node range was recorded for berry 4.14.1"))
+		})
+	})
+
+	context("validateEnginesNode", func() {
+		it("accepts a range the build can parse", func() {
+			Expect(validateEnginesNode("berry", "4.14.1", ">=18.12.0")).To(Succeed())
+			Expect(validateEnginesNode("berry", "3.8.7", ">=14.15.0 || ^12.22.0")).To(Succeed())
+		})
+
+		it("rejects a missing range", func() {
+			Expect(validateEnginesNode("yarn", "1.22.22", "")).To(MatchError("npm registry did not return engines.node for yarn 1.22.22"))
+		})
+
+		it("rejects a range the build cannot parse", func() {
+			Expect(validateEnginesNode("yarn", "1.22.22", "node-four")).To(MatchError(ContainSubstring(`invalid engines.node range "node-four" for yarn 1.22.22`)))
+		})
+	})
+}
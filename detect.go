@@ -4,6 +4,18 @@ import "github.com/paketo-buildpacks/packit/v2"
 
 func Detect() packit.DetectFunc {
 	return func(context packit.DetectContext) (packit.DetectResult, error) {
+		// Fail early when BP_YARN_PROJECT_PATH or BP_YARN_PROJECT_PATHS do not
+		// point at directories inside the app, rather than during the build.
+		_, err := FindProject(context.WorkingDir)
+		if err != nil {
+			return packit.DetectResult{}, err
+		}
+
+		_, err = FindProjects(context.WorkingDir)
+		if err != nil {
+			return packit.DetectResult{}, err
+		}
+
 		return packit.DetectResult{
 			Plan: packit.BuildPlan{
 				Provides: []packit.BuildPlanProvision{
@@ -2,6 +2,7 @@ package yarn_test
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/paketo-buildpacks/packit/v2"
@@ -44,4 +45,111 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 			},
 		}))
 	})
+
+	context("when BP_YARN_PROJECT_PATH is set", func() {
+		it.Before(func() {
+			Expect(os.MkdirAll(filepath.Join(workingDir, "frontend"), os.ModePerm)).To(Succeed())
+			Expect(os.Setenv("BP_YARN_PROJECT_PATH", "frontend")).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_PROJECT_PATH")).To(Succeed())
+		})
+
+		it("provides yarn as a dependency", func() {
+			result, err := detect(packit.DetectContext{
+				WorkingDir: workingDir,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Plan.Provides).To(Equal([]packit.BuildPlanProvision{{Name: "yarn"}}))
+		})
+
+		context("failure cases", func() {
+			context("when the project path does not exist", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_PROJECT_PATH", "backend")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := detect(packit.DetectContext{
+						WorkingDir: workingDir,
+					})
+					Expect(err).To(MatchError(ContainSubstring(`invalid BP_YARN_PROJECT_PATH "backend":`)))
+					Expect(err).To(MatchError(ContainSubstring("does not exist")))
+				})
+			})
+
+			context("when the project path is not a directory", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "package.json"), nil, 0600)).To(Succeed())
+					Expect(os.Setenv("BP_YARN_PROJECT_PATH", "package.json")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := detect(packit.DetectContext{
+						WorkingDir: workingDir,
+					})
+					Expect(err).To(MatchError(ContainSubstring("is not a directory")))
+				})
+			})
+
+			context("when the project path leaves the app directory", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_PROJECT_PATH", "frontend/../..")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := detect(packit.DetectContext{
+						WorkingDir: workingDir,
+					})
+					Expect(err).To(MatchError(`invalid BP_YARN_PROJECT_PATH "frontend/../..": must be inside the app directory`))
+				})
+			})
+
+			context("when the project path is a symlink out of the app directory", func() {
+				it.Before(func() {
+					Expect(os.Symlink(os.TempDir(), filepath.Join(workingDir, "outside"))).To(Succeed())
+					Expect(os.Setenv("BP_YARN_PROJECT_PATH", "outside")).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := detect(packit.DetectContext{
+						WorkingDir: workingDir,
+					})
+					Expect(err).To(MatchError(`invalid BP_YARN_PROJECT_PATH "outside": must be inside the app directory`))
+				})
+			})
+
+			context("when the project path is absolute", func() {
+				it.Before(func() {
+					Expect(os.Setenv("BP_YARN_PROJECT_PATH", filepath.Join(workingDir, "frontend"))).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := detect(packit.DetectContext{
+						WorkingDir: workingDir,
+					})
+					Expect(err).To(MatchError(ContainSubstring("must be relative to the app directory")))
+				})
+			})
+		})
+	})
+
+	context("when BP_YARN_PROJECT_PATHS lists a path outside of the app", func() {
+		it.Before(func() {
+			Expect(os.MkdirAll(filepath.Join(workingDir, "frontend"), os.ModePerm)).To(Succeed())
+			Expect(os.Setenv("BP_YARN_PROJECT_PATHS", "frontend,../legacy")).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_PROJECT_PATHS")).To(Succeed())
+		})
+
+		it("returns an error", func() {
+			_, err := detect(packit.DetectContext{
+				WorkingDir: workingDir,
+			})
+			Expect(err).To(MatchError(ContainSubstring(`invalid BP_YARN_PROJECT_PATHS "../legacy"`)))
+		})
+	})
 }
@@ -0,0 +1,27 @@
+package fakes
+
+import (
+	"sync"
+	"time"
+)
+
+type Sleeper struct {
+	SleepCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			Duration time.Duration
+		}
+		Stub func(time.Duration)
+	}
+}
+
+func (f *Sleeper) Sleep(param1 time.Duration) {
+	f.SleepCall.mutex.Lock()
+	defer f.SleepCall.mutex.Unlock()
+	f.SleepCall.CallCount++
+	f.SleepCall.Receives.Duration = param1
+	if f.SleepCall.Stub != nil {
+		f.SleepCall.Stub(param1)
+	}
+}
@@ -0,0 +1,38 @@
+package fakes
+
+import (
+	"sync"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/yarn"
+)
+
+type VersionResolver struct {
+	ResolveCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			Project yarn.Project
+			Entries []packit.BuildpackPlanEntry
+			Pins    bool
+		}
+		Returns struct {
+			VersionResolution yarn.VersionResolution
+			Error             error
+		}
+		Stub func(yarn.Project, []packit.BuildpackPlanEntry, bool) (yarn.VersionResolution, error)
+	}
+}
+
+func (f *VersionResolver) Resolve(param1 yarn.Project, param2 []packit.BuildpackPlanEntry, param3 bool) (yarn.VersionResolution, error) {
+	f.ResolveCall.mutex.Lock()
+	defer f.ResolveCall.mutex.Unlock()
+	f.ResolveCall.CallCount++
+	f.ResolveCall.Receives.Project = param1
+	f.ResolveCall.Receives.Entries = param2
+	f.ResolveCall.Receives.Pins = param3
+	if f.ResolveCall.Stub != nil {
+		return f.ResolveCall.Stub(param1, param2, param3)
+	}
+	return f.ResolveCall.Returns.VersionResolution, f.ResolveCall.Returns.Error
+}
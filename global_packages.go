@@ -0,0 +1,380 @@
+package yarn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// GlobalPackagesCacheKey is the layer metadata key that records which
+// packages, installed with which Yarn, the global packages layer holds.
+const GlobalPackagesCacheKey = "packages-sha"
+
+// GlobalPackage is a package from BP_YARN_GLOBAL_PACKAGES.
+type GlobalPackage struct {
+	Name    string
+	Version string
+}
+
+func (p GlobalPackage) String() string {
+	return fmt.Sprintf("%s@%s", p.Name, p.Version)
+}
+
+// globalPackages are the packages to install into the global packages layer
+// and the registry to install them from, if not the default one.
+type globalPackages struct {
+	Packages []GlobalPackage
+	Registry string
+}
+
+// globalPackagesFromEnv reads BP_YARN_GLOBAL_PACKAGES, a list of name@version
+// entries separated by commas or whitespace, and BP_YARN_GLOBAL_REGISTRY, the
+// URL of the registry to install them from.
+func globalPackagesFromEnv() (globalPackages, error) {
+	var g globalPackages
+
+	value := os.Getenv("BP_YARN_GLOBAL_PACKAGES")
+	names := map[string]bool{}
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' }) {
+		// Scoped packages start with an @, so the version follows the last one.
+		i := strings.LastIndex(entry, "@")
+		if i <= 0 || i == len(entry)-1 {
+			return globalPackages{}, fmt.Errorf("failed to parse BP_YARN_GLOBAL_PACKAGES entry %s: must be name@version", entry)
+		}
+
+		p := GlobalPackage{Name: entry[:i], Version: entry[i+1:]}
+		if names[p.Name] {
+			return globalPackages{}, fmt.Errorf("failed to parse BP_YARN_GLOBAL_PACKAGES: package %s is listed more than once", p.Name)
+		}
+		names[p.Name] = true
+
+		g.Packages = append(g.Packages, p)
+	}
+
+	if registry := os.Getenv("BP_YARN_GLOBAL_REGISTRY"); registry != "" {
+		u, err := url.Parse(registry)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return globalPackages{}, fmt.Errorf("failed to parse BP_YARN_GLOBAL_REGISTRY value %s: must be an http or https URL", registry)
+		}
+		g.Registry = registry
+	}
+
+	return g, nil
+}
+
+// cacheKey identifies the contents of the layer: the same packages installed
+// from the same registry with the same Yarn. The order of the packages does
+// not matter.
+func (g globalPackages) cacheKey(dependency postal.Dependency) string {
+	var entries []string
+	for _, p := range g.Packages {
+		entries = append(entries, p.String())
+	}
+	sort.Strings(entries)
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s@%s\nregistry=%s", strings.Join(entries, "\n"), dependency.ID, dependency.Version, g.Registry)))
+	return hex.EncodeToString(sum[:])
+}
+
+// install installs the packages into the global packages layer with the Yarn
+// in yarnBinDir, described by dependency, and links their executables into
+// the bin directory of the layer. The packages are installed as the
+// dependencies of a package.json in the layer, which works the same for Yarn
+// Classic, which has `yarn global add`, and Yarn Berry, which does not. A
+// layer that already holds the same packages installed with the same Yarn is
+// reused.
+func (g globalPackages) install(
+	layers packit.Layers,
+	yarn Executable,
+	yarnBinDir string,
+	dependency postal.Dependency,
+	sbomGenerator SBOMGenerator,
+	options sbomOptions,
+	logger scribe.Emitter,
+	tracer phaseTracer,
+	launch, build bool,
+) (packit.Layer, error) {
+	layer, err := layers.Get(GlobalPackagesLayerName)
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	_, span := tracer.start("yarn.global_packages", attribute.Int("yarn.global_packages.count", len(g.Packages)))
+	layer, err = g.installLayer(layer, yarn, yarnBinDir, dependency, sbomGenerator, options, logger)
+	endSpan(span, err)
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	layer.Launch, layer.Build, layer.Cache = launch, build, build
+
+	return layer, nil
+}
+
+func (g globalPackages) installLayer(
+	layer packit.Layer,
+	yarn Executable,
+	yarnBinDir string,
+	dependency postal.Dependency,
+	sbomGenerator SBOMGenerator,
+	options sbomOptions,
+	logger scribe.Emitter,
+) (packit.Layer, error) {
+	key := g.cacheKey(dependency)
+	if cached, ok := layer.Metadata[GlobalPackagesCacheKey].(string); ok && cached == key {
+		logger.Process("Reusing cached layer %s", layer.Path)
+		logger.Break()
+		return layer, nil
+	}
+
+	logger.Process("Installing global packages")
+	for _, p := range g.Packages {
+		logger.Subprocess("%s", p)
+	}
+
+	layer, err := layer.Reset()
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	dependencies := map[string]string{}
+	for _, p := range g.Packages {
+		dependencies[p.Name] = p.Version
+	}
+
+	content, err := json.MarshalIndent(map[string]interface{}{
+		"name":         "yarn-global-packages",
+		"private":      true,
+		"dependencies": dependencies,
+	}, "", "  ")
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	err = os.WriteFile(filepath.Join(layer.Path, "package.json"), content, 0644)
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to write package.json for global packages: %w", err)
+	}
+
+	// The download cache would only bloat the layer.
+	cacheDir, err := os.MkdirTemp("", "yarn-global-cache")
+	if err != nil {
+		return packit.Layer{}, err
+	}
+	defer func() { _ = os.RemoveAll(cacheDir) }()
+
+	args, env := g.installCommand(dependency, cacheDir)
+
+	buffer := bytes.NewBuffer(nil)
+	err = yarn.Execute(pexec.Execution{
+		Args:   args,
+		Dir:    layer.Path,
+		Env:    prependPath(append(os.Environ(), env...), yarnBinDir),
+		Stdout: buffer,
+		Stderr: buffer,
+	})
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to install global packages: %w\n%s", err, buffer.String())
+	}
+
+	executables, err := g.linkExecutables(layer.Path)
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	for _, executable := range executables {
+		logger.Action("Linked %s", executable)
+	}
+	logger.Break()
+
+	layer.SBOM, err = globalPackagesSBOM(sbomGenerator, layer.Path, options, logger)
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	layer.Metadata = map[string]interface{}{
+		GlobalPackagesCacheKey: key,
+	}
+
+	return layer, nil
+}
+
+// installCommand returns the arguments and environment that make the Yarn
+// described by dependency install the dependencies of the package.json in the
+// layer into a node_modules directory, using the registry if one is set.
+func (g globalPackages) installCommand(dependency postal.Dependency, cacheDir string) ([]string, []string) {
+	env := []string{fmt.Sprintf("YARN_CACHE_FOLDER=%s", cacheDir)}
+
+	if dependency.ID != BerryDependency {
+		// Yarn Classic also reads the npm configuration, so the registry is
+		// set there as well to keep an .npmrc from pointing it elsewhere.
+		if g.Registry != "" {
+			env = append(env,
+				fmt.Sprintf("YARN_REGISTRY=%s", g.Registry),
+				fmt.Sprintf("npm_config_registry=%s", g.Registry),
+			)
+		}
+
+		return []string{"install", "--non-interactive", "--no-progress"}, env
+	}
+
+	env = append(env,
+		"YARN_NODE_LINKER=node-modules",
+		"YARN_ENABLE_GLOBAL_CACHE=false",
+		"YARN_ENABLE_IMMUTABLE_INSTALLS=false",
+		"YARN_ENABLE_TELEMETRY=false",
+	)
+
+	if g.Registry != "" {
+		env = append(env, fmt.Sprintf("YARN_NPM_REGISTRY_SERVER=%s", g.Registry))
+
+		// Yarn Berry refuses plain http registries, such as a local stand-in,
+		// unless their host is allowed explicitly.
+		if u, err := url.Parse(g.Registry); err == nil && u.Scheme == "http" {
+			env = append(env, fmt.Sprintf("YARN_UNSAFE_HTTP_WHITELIST=%s", u.Hostname()))
+		}
+	}
+
+	return []string{"install"}, env
+}
+
+// linkExecutables links the executables declared in the bin field of each of
+// the installed packages into the bin directory of the layer, which is put on
+// the PATH. Executables of their dependencies are left out. It returns the
+// names of the linked executables. An executable must be a file within its
+// package, so that a package cannot link files elsewhere in the image onto
+// the PATH.
+func (g globalPackages) linkExecutables(layerPath string) ([]string, error) {
+	binDir := filepath.Join(layerPath, "bin")
+	err := os.MkdirAll(binDir, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bin directory for global packages: %w", err)
+	}
+
+	providers := map[string]string{}
+	var executables []string
+	for _, p := range g.Packages {
+		packageDir := filepath.Join(layerPath, "node_modules", filepath.FromSlash(p.Name))
+		bins, err := readBins(p.Name, filepath.Join(packageDir, "package.json"))
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for name := range bins {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if other, ok := providers[name]; ok {
+				return nil, fmt.Errorf("global packages %s and %s both provide the executable %s", other, p.Name, name)
+			}
+			providers[name] = p.Name
+
+			if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+				return nil, fmt.Errorf("global package %s declares the invalid executable name %q", p.Name, name)
+			}
+
+			target := filepath.Join(packageDir, filepath.FromSlash(bins[name]))
+			if rel, err := filepath.Rel(packageDir, target); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return nil, fmt.Errorf("executable %s of global package %s points outside of the package: %s", name, p.Name, bins[name])
+			}
+
+			err = os.Chmod(target, 0755)
+			if err != nil {
+				return nil, fmt.Errorf("failed to link executable %s of %s: %w", name, p.Name, err)
+			}
+
+			relative, err := filepath.Rel(binDir, target)
+			if err != nil {
+				return nil, err
+			}
+
+			err = os.Symlink(relative, filepath.Join(binDir, name))
+			if err != nil {
+				return nil, fmt.Errorf("failed to link executable %s of %s: %w", name, p.Name, err)
+			}
+
+			executables = append(executables, name)
+		}
+	}
+
+	return executables, nil
+}
+
+// readBins returns the executables declared in the bin field of the
+// package.json at packageJSON, by name. A bin given as a single path is named after
+// the package, without its scope.
+func readBins(name, packageJSON string) (map[string]string, error) {
+	content, err := os.ReadFile(packageJSON)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("global package %s was not installed to %s", name, filepath.Dir(packageJSON))
+		}
+		return nil, fmt.Errorf("failed to read package.json of global package %s: %w", name, err)
+	}
+
+	var pkg struct {
+		Bin json.RawMessage `json:"bin"`
+	}
+	err = json.Unmarshal(content, &pkg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse package.json of global package %s: %w", name, err)
+	}
+
+	if len(pkg.Bin) == 0 {
+		return nil, nil
+	}
+
+	var single string
+	if json.Unmarshal(pkg.Bin, &single) == nil {
+		return map[string]string{path.Base(name): single}, nil
+	}
+
+	var bins map[string]string
+	err = json.Unmarshal(pkg.Bin, &bins)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bin of global package %s: %w", name, err)
+	}
+
+	return bins, nil
+}
+
+// globalPackagesSBOM describes the packages found in the layer, in the
+// formats selected by the options. It returns nil when SBOM generation is
+// disabled with BP_DISABLE_SBOM.
+func globalPackagesSBOM(sbomGenerator SBOMGenerator, dir string, options sbomOptions, logger scribe.Emitter) (packit.SBOMFormatter, error) {
+	sbomDisabled, err := lookupBoolEnv("BP_DISABLE_SBOM")
+	if err != nil {
+		return nil, err
+	}
+
+	if sbomDisabled {
+		return nil, nil
+	}
+
+	logger.GeneratingSBOM(dir)
+	content, err := sbomGenerator.Generate(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.FormattingSBOM(options.Formats...)
+	return content.InFormats(options.Formats...)
+}
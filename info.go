@@ -0,0 +1,78 @@
+package yarn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+)
+
+// InfoFileName is the name of the file in the yarn layer that describes the
+// installed Yarn to later buildpacks. Its path is published in
+// PAKETO_YARN_INFO.
+const InfoFileName = "yarn.json"
+
+// Info is the content of the yarn.json file.
+type Info struct {
+	Installs []InstallInfo `json:"installs"`
+}
+
+// InstallInfo describes one installed Yarn and the projects that use it. Path
+// is the directory holding its bin directory.
+type InstallInfo struct {
+	Flavor       string   `json:"flavor"`
+	Version      string   `json:"version"`
+	DependencyID string   `json:"dependency-id"`
+	Path         string   `json:"path"`
+	Projects     []string `json:"projects"`
+}
+
+func newInstallInfo(dependency postal.Dependency, path string, projects ...string) InstallInfo {
+	return InstallInfo{
+		Flavor:       Flavor(dependency.ID),
+		Version:      dependency.Version,
+		DependencyID: dependency.ID,
+		Path:         path,
+		Projects:     projects,
+	}
+}
+
+// publishInfo writes the yarn.json file into the layer and points
+// PAKETO_YARN_INFO at it in the build environment. When a single Yarn is
+// installed, its details are also published as PAKETO_YARN_FLAVOR,
+// PAKETO_YARN_VERSION, PAKETO_YARN_DEPENDENCY_ID and PAKETO_YARN_HOME, so that
+// later buildpacks need not detect Yarn again. The variables are not prefixed
+// with YARN_, since Yarn Berry reads every YARN_ variable as a setting and
+// rejects the ones it does not know, and not with BP_YARN_, which would
+// clobber the configuration of this buildpack, such as BP_YARN_VERSION.
+func publishInfo(layer packit.Layer, installs []InstallInfo) (packit.Layer, error) {
+	content, err := json.MarshalIndent(Info{Installs: installs}, "", "  ")
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to encode %s: %w", InfoFileName, err)
+	}
+
+	err = os.MkdirAll(layer.Path, os.ModePerm)
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to create %s: %w", layer.Path, err)
+	}
+
+	path := filepath.Join(layer.Path, InfoFileName)
+	err = os.WriteFile(path, append(content, '\n'), 0644)
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to write %s: %w", InfoFileName, err)
+	}
+
+	layer.BuildEnv.Override("PAKETO_YARN_INFO", path)
+
+	if len(installs) == 1 {
+		layer.BuildEnv.Override("PAKETO_YARN_FLAVOR", installs[0].Flavor)
+		layer.BuildEnv.Override("PAKETO_YARN_VERSION", installs[0].Version)
+		layer.BuildEnv.Override("PAKETO_YARN_DEPENDENCY_ID", installs[0].DependencyID)
+		layer.BuildEnv.Override("PAKETO_YARN_HOME", installs[0].Path)
+	}
+
+	return layer, nil
+}
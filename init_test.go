@@ -9,7 +9,15 @@ import (
 
 func TestUnitYarn(t *testing.T) {
 	suite := spec.New("yarn", spec.Report(report.Terminal{}), spec.Parallel())
+	suite("ArtifactCache", testArtifactCache)
 	suite("Build", testBuild, spec.Sequential())
-	suite("Detect", testDetect)
+	suite("DeliveryService", testDeliveryService)
+	suite("Detect", testDetect, spec.Sequential())
+	suite("Inspect", testInspect, spec.Sequential())
+	suite("MergeSBOMs", testMergeSBOMs)
+	suite("NodeEngines", testNodeEngines)
+	suite("ReferenceLicenseFiles", testReferenceLicenseFiles)
+	suite("Transport", testTransport, spec.Sequential())
+	suite("VersionResolver", testVersionResolver, spec.Sequential())
 	suite.Run(t)
 }
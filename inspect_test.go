@@ -0,0 +1,274 @@
+package yarn_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/yarn"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testInspect(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		workingDir    string
+		cnbDir        string
+		buildpackTOML string
+		plan          packit.BuildpackPlan
+		service       postal.Service
+		resolver      yarn.SourceResolver
+
+		platform = fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
+	)
+
+	it.Before(func() {
+		var err error
+		workingDir, err = os.MkdirTemp("", "working-dir")
+		Expect(err).NotTo(HaveOccurred())
+
+		cnbDir, err = os.MkdirTemp("", "cnb")
+		Expect(err).NotTo(HaveOccurred())
+
+		buildpackTOML = filepath.Join(cnbDir, "buildpack.toml")
+		Expect(os.WriteFile(buildpackTOML, []byte(`
+[metadata]
+  [metadata.default-versions]
+    yarn = "1.22.19"
+
+  [[metadata.dependencies]]
+    id = "yarn"
+    version = "1.22.19"
+    checksum = "sha256:yarn-1.22.19-sha"
+    uri = "yarn-1.22.19-uri"
+    stacks = ["*"]
+
+  [[metadata.dependencies]]
+    id = "yarn"
+    version = "1.22.22"
+    checksum = "sha256:yarn-1.22.22-sha"
+    uri = "yarn-1.22.22-uri"
+    stacks = ["*"]
+
+  [[metadata.dependencies]]
+    id = "berry"
+    version = "4.17.1"
+    checksum = "sha256:berry-4.17.1-sha"
+    uri = "berry-4.17.1-uri"
+    stacks = ["*"]
+
+  [[metadata.dependencies]]
+    id = "berry"
+    version = "4.18.0"
+    checksum = "sha256:berry-4.18.0-sha"
+    uri = "berry-4.18.0-uri"
+    stacks = ["some-stack"]
+`), 0644)).To(Succeed())
+
+		plan = packit.BuildpackPlan{}
+		service = postal.NewService(yarn.NewTransport())
+		resolver = yarn.NewVersionResolver(yarn.DefaultVersionSources()...)
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(workingDir)).To(Succeed())
+		Expect(os.RemoveAll(cnbDir)).To(Succeed())
+	})
+
+	it("resolves the default version of Yarn Classic and explains why", func() {
+		inspection, err := yarn.Inspect(service, resolver, workingDir, buildpackTOML, "some-stack", plan)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(inspection.Projects).To(Equal([]yarn.ProjectInspection{
+			{
+				Path:         workingDir,
+				DependencyID: "yarn",
+				Constraint:   "default",
+				Version:      "1.22.19",
+				Checksum:     "sha256:yarn-1.22.19-sha",
+				URI:          "yarn-1.22.19-uri",
+				Trail: []string{
+					"No source requires a version of Yarn, so Yarn Classic is used",
+					"The default version of yarn in buildpack.toml is 1.22.19",
+					"buildpack.toml offers yarn 1.22.19, 1.22.22 for stack some-stack on " + platform,
+					"yarn 1.22.19 is the highest of them that satisfies 1.22.19",
+				},
+			},
+		}))
+	})
+
+	context("when package.json pins Yarn Berry", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(filepath.Join(workingDir, "package.json"), []byte(`{"packageManager":"yarn@4.17.1"}`), 0644)).To(Succeed())
+		})
+
+		it("resolves the highest version of Yarn Berry for the stack, like the build", func() {
+			inspection, err := yarn.Inspect(service, resolver, workingDir, buildpackTOML, "some-stack", plan)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(inspection.Projects).To(HaveLen(1))
+			project := inspection.Projects[0]
+			Expect(project.DependencyID).To(Equal("berry"))
+			Expect(project.Version).To(Equal("4.18.0"))
+			Expect(project.Trail).To(ContainElements(
+				"The packageManager yarn@4.17.1 in "+filepath.Join(workingDir, "package.json")+" pins Yarn 4.17.1",
+				"The packageManager has the highest priority and selects Yarn Berry",
+				"Yarn Berry is resolved with its default version in buildpack.toml",
+				"buildpack.toml has no default version of berry, so any version matches",
+				"buildpack.toml offers berry 4.17.1, 4.18.0 for stack some-stack on "+platform,
+				"berry 4.18.0 is the highest of them that satisfies *",
+			))
+		})
+
+		context("when the stack has fewer versions", func() {
+			it("resolves the highest version available on it", func() {
+				inspection, err := yarn.Inspect(service, resolver, workingDir, buildpackTOML, "other-stack", plan)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(inspection.Projects[0].Version).To(Equal("4.17.1"))
+				Expect(inspection.Projects[0].Trail).To(ContainElement("buildpack.toml offers berry 4.17.1 for stack other-stack on " + platform))
+			})
+		})
+	})
+
+	context("when the build plan requires a version", func() {
+		it.Before(func() {
+			plan.Entries = []packit.BuildpackPlanEntry{
+				{
+					Name:     "yarn",
+					Metadata: map[string]interface{}{"version": "1.22.*", "version-source": "BP_YARN_VERSION"},
+				},
+			}
+		})
+
+		it("resolves it", func() {
+			inspection, err := yarn.Inspect(service, resolver, workingDir, buildpackTOML, "some-stack", plan)
+			Expect(err).NotTo(HaveOccurred())
+
+			project := inspection.Projects[0]
+			Expect(project.Constraint).To(Equal("1.22.*"))
+			Expect(project.Version).To(Equal("1.22.22"))
+			Expect(project.Trail[0]).To(Equal("The build plan requires Yarn 1.22.*, from BP_YARN_VERSION"))
+		})
+	})
+
+	context("when BP_YARN_PROJECT_PATHS lists several projects", func() {
+		it.Before(func() {
+			for dir, content := range map[string]string{
+				"legacy":   `{}`,
+				"frontend": `{"packageManager":"yarn@4.17.1+sha512.abcdef"}`,
+			} {
+				Expect(os.MkdirAll(filepath.Join(workingDir, dir), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(workingDir, dir, "package.json"), []byte(content), 0644)).To(Succeed())
+			}
+
+			Expect(os.Setenv("BP_YARN_PROJECT_PATHS", "legacy,frontend")).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_PROJECT_PATHS")).To(Succeed())
+		})
+
+		it("resolves the version pinned by each project", func() {
+			inspection, err := yarn.Inspect(service, resolver, workingDir, buildpackTOML, "some-stack", plan)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(inspection.Projects).To(HaveLen(2))
+			Expect(inspection.Projects[0].Path).To(Equal(filepath.Join(workingDir, "legacy")))
+			Expect(inspection.Projects[0].Version).To(Equal("1.22.19"))
+			Expect(inspection.Projects[1].Path).To(Equal(filepath.Join(workingDir, "frontend")))
+			Expect(inspection.Projects[1].DependencyID).To(Equal("berry"))
+			Expect(inspection.Projects[1].Version).To(Equal("4.17.1"))
+			Expect(inspection.Projects[1].Trail).To(ContainElements(
+				"The packageManager yarn@4.17.1+sha512.abcdef in "+filepath.Join(workingDir, "frontend", "package.json")+" pins Yarn 4.17.1",
+				"Yarn Berry is resolved with the constraint 4.17.1 of the packageManager",
+			))
+		})
+
+		context("when BP_YARN_USE_SYSTEM is also set", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_USE_SYSTEM", "true")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_USE_SYSTEM")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := yarn.Inspect(service, resolver, workingDir, buildpackTOML, "some-stack", plan)
+				Expect(err).To(MatchError("BP_YARN_USE_SYSTEM cannot be combined with BP_YARN_PROJECT_PATHS"))
+			})
+		})
+	})
+
+	context("when BP_YARN_USE_SYSTEM is true", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_YARN_USE_SYSTEM", "true")).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_USE_SYSTEM")).To(Succeed())
+		})
+
+		it("reports the constraint the system yarn must satisfy", func() {
+			inspection, err := yarn.Inspect(service, resolver, workingDir, buildpackTOML, "some-stack", plan)
+			Expect(err).NotTo(HaveOccurred())
+
+			project := inspection.Projects[0]
+			Expect(project.System).To(BeTrue())
+			Expect(project.Constraint).To(Equal("1.*"))
+			Expect(project.Version).To(BeEmpty())
+			Expect(project.Trail).To(ContainElement("BP_YARN_USE_SYSTEM is set, so the yarn on the PATH is used if it satisfies 1.*"))
+		})
+	})
+
+	context("when no version satisfies the constraint", func() {
+		it.Before(func() {
+			plan.Entries = []packit.BuildpackPlanEntry{
+				{Name: "yarn", Metadata: map[string]interface{}{"version": "1.21.*"}},
+			}
+		})
+
+		it("reports the error with the trail", func() {
+			inspection, err := yarn.Inspect(service, resolver, workingDir, buildpackTOML, "some-stack", plan)
+			Expect(err).NotTo(HaveOccurred())
+
+			project := inspection.Projects[0]
+			Expect(project.Version).To(BeEmpty())
+			Expect(project.Error).To(ContainSubstring(`failed to satisfy "yarn" dependency version constraint "1.21.*"`))
+			Expect(project.Trail).To(ContainElement("buildpack.toml offers yarn 1.22.19, 1.22.22 for stack some-stack on " + platform))
+		})
+	})
+
+	context("failure cases", func() {
+		context("when BP_YARN_USE_SYSTEM is not a boolean", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_USE_SYSTEM", "sometimes")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_USE_SYSTEM")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := yarn.Inspect(service, resolver, workingDir, buildpackTOML, "some-stack", plan)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_YARN_USE_SYSTEM value sometimes")))
+			})
+		})
+
+		context("when buildpack.toml cannot be read", func() {
+			it("reports the error", func() {
+				inspection, err := yarn.Inspect(service, resolver, workingDir, filepath.Join(cnbDir, "missing.toml"), "some-stack", plan)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(inspection.Projects[0].Error).To(ContainSubstring("failed to read dependencies from"))
+			})
+		})
+	})
+}
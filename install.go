@@ -0,0 +1,278 @@
+package yarn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/chronos"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// installer installs resolved Yarn dependencies into layers. A layer is
+// reused when its metadata shows that it already holds the dependency;
+// otherwise the dependency is restored from the artifact cache or delivered,
+// fixed up, verified and described in an SBOM.
+type installer struct {
+	dependencyManager DependencyManager
+	sbomGenerator     SBOMGenerator
+	node              Executable
+	yarn              Executable
+	clock             chronos.Clock
+	sleeper           Sleeper
+	logger            scribe.Emitter
+	report            *BuildReport
+	tracer            phaseTracer
+
+	deliverer     Deliverer
+	artifactCache *ArtifactCache
+	cnbPath       string
+	platformPath  string
+	sbomOptions   sbomOptions
+	launch        bool
+	build         bool
+}
+
+// newInstaller returns an installer configured from the build context and
+// the delivery and artifact cache settings in the environment.
+func newInstaller(
+	context packit.BuildContext,
+	dependencyManager DependencyManager,
+	sbomGenerator SBOMGenerator,
+	node Executable,
+	yarn Executable,
+	clock chronos.Clock,
+	sleeper Sleeper,
+	logger scribe.Emitter,
+	report *BuildReport,
+	tracer phaseTracer,
+	launch, build bool,
+) (installer, error) {
+	deliveryPolicy, err := deliveryPolicyFromEnv()
+	if err != nil {
+		return installer{}, err
+	}
+
+	maxCachedArtifacts, maxArtifactCacheSize, err := artifactCacheConfig()
+	if err != nil {
+		return installer{}, err
+	}
+
+	sbomOptions, err := sbomOptionsFromEnv(context.BuildpackInfo.SBOMFormats)
+	if err != nil {
+		return installer{}, err
+	}
+
+	// Every delivery goes through the dependency manager of the build,
+	// retried according to the delivery policy, and the artifact cache only
+	// wraps it.
+	var deliverer Deliverer = DelivererFunc(func(dependency postal.Dependency, cnbPath, layerPath, platformPath string) error {
+		return deliverWithRetries(dependencyManager, dependency, cnbPath, layerPath, platformPath, deliveryPolicy, clock, sleeper, logger, report)
+	})
+
+	var artifactCache *ArtifactCache
+	if maxCachedArtifacts > 0 {
+		artifactCacheLayer, err := context.Layers.Get(ArtifactCacheLayerName)
+		if err != nil {
+			return installer{}, err
+		}
+
+		cache, err := NewArtifactCache(artifactCacheLayer, deliverer, maxCachedArtifacts, maxArtifactCacheSize, clock)
+		if err != nil {
+			return installer{}, err
+		}
+		artifactCache = &cache
+	}
+
+	return installer{
+		dependencyManager: dependencyManager,
+		sbomGenerator:     sbomGenerator,
+		node:              node,
+		yarn:              yarn,
+		clock:             clock,
+		sleeper:           sleeper,
+		logger:            logger,
+		report:            report,
+		tracer:            tracer,
+		deliverer:         deliverer,
+		artifactCache:     artifactCache,
+		cnbPath:           context.CNBPath,
+		platformPath:      context.Platform.Path,
+		sbomOptions:       sbomOptions,
+		launch:            launch,
+		build:             build,
+	}, nil
+}
+
+// Install installs the dependency into the given subdirectory of the layer
+// and returns the layer ready to be included in the build result. How the
+// dependency was provided is recorded in the build report, and each phase of
+// the install is traced. source names the version source that decided the
+// resolution of the dependency.
+func (i installer) Install(layer packit.Layer, dependency postal.Dependency, source, subdir string) (packit.Layer, error) {
+	tracer, span := i.tracer.start("yarn.install", dependencyAttributes(dependency)...)
+	layer, err := i.install(tracer, layer, dependency, source, subdir)
+	endSpan(span, err)
+
+	return layer, err
+}
+
+func (i installer) install(tracer phaseTracer, layer packit.Layer, dependency postal.Dependency, source, subdir string) (packit.Layer, error) {
+	installDir := filepath.Join(layer.Path, subdir)
+	installReport := newInstallReport(dependency, source)
+	layerMetadata := NewLayerMetadata(dependency, requiredFixups())
+
+	cacheTracer, cacheSpan := tracer.start("yarn.cache")
+	cachedMetadata := ParseLayerMetadata(layer.Metadata)
+	reusable, reason := cachedMetadata.Reusable(layerMetadata)
+	if reusable && len(cachedMetadata.MissingFixups(layerMetadata)) > 0 {
+		i.logger.Process("Migrating cached layer %s", layer.Path)
+
+		err := traceFixups(cacheTracer, installDir, i.cnbPath, dependency)
+		if err != nil {
+			reusable, reason = false, err.Error()
+		}
+	}
+
+	if reusable {
+		cacheSpan.SetAttributes(attribute.String("yarn.cache", CacheHit))
+	} else {
+		cacheSpan.SetAttributes(attribute.String("yarn.cache", CacheMiss), attribute.String("yarn.cache.reason", reason))
+	}
+	endSpan(cacheSpan, nil)
+
+	if reusable {
+		i.logger.Process("Reusing cached layer %s", layer.Path)
+		i.logger.Break()
+
+		layer.Launch, layer.Build, layer.Cache = i.launch, i.build, i.build
+		layer.Metadata = layerMetadata.Map()
+
+		// The archive of a reused layer is still in use, so it must not be
+		// the next one the artifact cache evicts.
+		if i.artifactCache != nil {
+			err := i.artifactCache.Touch(dependencyChecksum(dependency))
+			if err != nil {
+				return packit.Layer{}, err
+			}
+		}
+
+		installReport.Cache, installReport.CacheSource = CacheHit, CacheSourceLayer
+		i.report.addInstall(installReport)
+
+		return layer, nil
+	}
+
+	if len(layer.Metadata) > 0 {
+		i.logger.Process("Invalidating cached layer %s: %s", layer.Path, reason)
+	}
+
+	i.logger.Process("Executing build process")
+
+	layer, err := layer.Reset()
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	layer.Launch, layer.Build, layer.Cache = i.launch, i.build, i.build
+
+	err = os.MkdirAll(installDir, os.ModePerm)
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to create install directory: %w", err)
+	}
+
+	i.logger.Subprocess("Installing Yarn")
+
+	_, deliverSpan := tracer.start("yarn.deliver")
+	err = i.deliver(dependency, installDir, &installReport)
+	deliverSpan.SetAttributes(attribute.Bool("yarn.artifact_cache.hit", installReport.CacheSource == CacheSourceArtifactCache))
+	endSpan(deliverSpan, err)
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	err = traceFixups(tracer, installDir, i.cnbPath, dependency)
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to prepare yarn layer: %w", err)
+	}
+
+	i.logger.Break()
+
+	skipVersionCheck, err := lookupBoolEnv("BP_YARN_SKIP_VERSION_CHECK")
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	if !skipVersionCheck {
+		i.logger.Subprocess("Verifying Yarn installation")
+		err = checkInstalledVersion(i.node, i.yarn, installDir, dependency.Version)
+		if err != nil {
+			if !errors.Is(err, errNodeNotFound) {
+				return packit.Layer{}, fmt.Errorf("failed to verify yarn installation: %w", err)
+			}
+			i.logger.Action("Skipping: %s", err)
+			i.report.Warn("skipped the verification of %s %s: %s", dependency.ID, dependency.Version, err)
+		} else {
+			i.logger.Action("yarn --version reports %s", dependency.Version)
+		}
+		i.logger.Break()
+	}
+
+	duration, err := i.clock.Measure(func() error {
+		layer.SBOM, err = generateSBOM(i.sbomGenerator, dependency, installDir, i.sbomOptions, i.clock, i.logger, tracer)
+		return err
+	})
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	installReport.SBOMDurationMS = duration.Milliseconds()
+	i.report.addInstall(installReport)
+
+	layer.Metadata = layerMetadata.Map()
+
+	return layer, nil
+}
+
+// deliver delivers the dependency into installDir, through the artifact cache
+// when it is enabled. The install is reported as an artifact cache hit only
+// when the cache served the archive.
+func (i installer) deliver(dependency postal.Dependency, installDir string, installReport *InstallReport) error {
+	var cached bool
+	duration, err := i.clock.Measure(func() error {
+		if i.artifactCache == nil {
+			return i.deliverer.Deliver(dependency, i.cnbPath, installDir, i.platformPath)
+		}
+
+		var err error
+		cached, err = i.artifactCache.Deliver(dependency, i.cnbPath, installDir, i.platformPath)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if cached {
+		i.logger.Action("Restored %s from artifact cache", dependency.Checksum)
+		installReport.Cache, installReport.CacheSource = CacheHit, CacheSourceArtifactCache
+		return nil
+	}
+
+	installReport.Cache = CacheMiss
+	installReport.DeliveryDurationMS = duration.Milliseconds()
+
+	return nil
+}
+
+// traceFixups applies the fixups to installDir in a span of its own.
+func traceFixups(tracer phaseTracer, installDir, cnbPath string, dependency postal.Dependency) error {
+	_, span := tracer.start("yarn.fixups")
+	err := applyFixups(installDir, cnbPath, dependency)
+	endSpan(span, err)
+
+	return err
+}
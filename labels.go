@@ -0,0 +1,67 @@
+package yarn
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+)
+
+const defaultImageLabelPrefix = "io.paketo.yarn"
+
+// Flavor returns the flavor of Yarn provided by the given dependency:
+// "berry" for Yarn 2 and later, "classic" for Yarn 1.
+func Flavor(dependencyID string) string {
+	if dependencyID == BerryDependency {
+		return "berry"
+	}
+
+	return "classic"
+}
+
+// imageLabels returns the image labels describing the installed Yarn
+// dependencies. Labels are enabled by default; BP_YARN_IMAGE_LABELS=false
+// turns them off and BP_YARN_IMAGE_LABEL_PREFIX replaces the io.paketo.yarn
+// prefix. When several versions are installed, each label lists the values of
+// all of them, comma separated, in install order.
+func imageLabels(dependencies ...postal.Dependency) (map[string]string, error) {
+	enabled := true
+	if _, ok := os.LookupEnv("BP_YARN_IMAGE_LABELS"); ok {
+		var err error
+		enabled, err = lookupBoolEnv("BP_YARN_IMAGE_LABELS")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !enabled || len(dependencies) == 0 {
+		return nil, nil
+	}
+
+	prefix := defaultImageLabelPrefix
+	if value, ok := os.LookupEnv("BP_YARN_IMAGE_LABEL_PREFIX"); ok {
+		prefix = strings.TrimSuffix(value, ".")
+		if prefix == "" || strings.ContainsAny(prefix, " \t\n=") {
+			return nil, fmt.Errorf("failed to parse BP_YARN_IMAGE_LABEL_PREFIX value %q: must be a non-empty label key without whitespace or '='", value)
+		}
+	}
+
+	var versions, flavors, sources []string
+	for _, dependency := range dependencies {
+		source := dependency.Source
+		if source == "" {
+			source = dependency.URI
+		}
+
+		versions = append(versions, dependency.Version)
+		flavors = append(flavors, Flavor(dependency.ID))
+		sources = append(sources, source)
+	}
+
+	return map[string]string{
+		prefix + ".version": strings.Join(versions, ","),
+		prefix + ".flavor":  strings.Join(flavors, ","),
+		prefix + ".source":  strings.Join(sources, ","),
+	}, nil
+}
@@ -0,0 +1,142 @@
+package yarn
+
+import (
+	"slices"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+)
+
+// LayerMetadataSchemaVersion is the version of the metadata schema written to
+// the yarn layer. It must be incremented whenever a change to the buildpack
+// makes layers cached by earlier versions unsafe to reuse.
+const LayerMetadataSchemaVersion = 1
+
+const (
+	schemaVersionKey     = "schema-version"
+	dependencyIDKey      = "dependency-id"
+	dependencyVersionKey = "dependency-version"
+	fixupsKey            = "fixups"
+)
+
+// LayerMetadata describes the contents of the yarn layer. It is persisted as
+// the layer metadata and compared against on subsequent builds to decide
+// whether a cached layer can be reused.
+type LayerMetadata struct {
+	SchemaVersion int
+	DependencyID  string
+	Version       string
+	Checksum      string
+	Fixups        []string
+}
+
+// NewLayerMetadata returns the metadata for a fresh install of the given
+// dependency, listing the post-install fixups that must be applied to it.
+func NewLayerMetadata(dependency postal.Dependency, fixups []string) LayerMetadata {
+	return LayerMetadata{
+		SchemaVersion: LayerMetadataSchemaVersion,
+		DependencyID:  dependency.ID,
+		Version:       dependency.Version,
+		Checksum:      dependency.Checksum,
+		Fixups:        fixups,
+	}
+}
+
+// ParseLayerMetadata reads the metadata of a previously built layer. Layers
+// written before the schema was versioned only carry the dependency checksum
+// and ID; they are reported with a schema version of 0.
+func ParseLayerMetadata(metadata map[string]interface{}) LayerMetadata {
+	var m LayerMetadata
+
+	switch version := metadata[schemaVersionKey].(type) {
+	case int64:
+		m.SchemaVersion = int(version)
+	case int:
+		m.SchemaVersion = version
+	}
+
+	m.DependencyID, _ = metadata[dependencyIDKey].(string)
+	m.Version, _ = metadata[dependencyVersionKey].(string)
+	m.Checksum, _ = metadata[DependencyCacheKey].(string)
+
+	switch fixups := metadata[fixupsKey].(type) {
+	case []interface{}:
+		for _, fixup := range fixups {
+			if s, ok := fixup.(string); ok {
+				m.Fixups = append(m.Fixups, s)
+			}
+		}
+	case []string:
+		m.Fixups = append(m.Fixups, fixups...)
+	}
+
+	return m
+}
+
+// Map returns the metadata in the form stored on a packit.Layer. The layer
+// TOML is written with sorted keys, and the fixups are sorted as well, so the
+// same metadata always serializes to the same bytes.
+func (m LayerMetadata) Map() map[string]interface{} {
+	fixups := []string{}
+	if m.Fixups != nil {
+		fixups = slices.Clone(m.Fixups)
+		slices.Sort(fixups)
+	}
+
+	return map[string]interface{}{
+		schemaVersionKey:     m.SchemaVersion,
+		dependencyIDKey:      m.DependencyID,
+		dependencyVersionKey: m.Version,
+		DependencyCacheKey:   m.Checksum,
+		fixupsKey:            fixups,
+	}
+}
+
+// Reusable reports whether a layer described by the cached metadata can stand
+// in for a fresh install described by expected, once any fixups reported by
+// MissingFixups have been applied to it. When it cannot, the returned string
+// explains why.
+//
+// Layers from before the schema was versioned are migrated when they hold the
+// same dependency. Layers from any other schema version are invalidated.
+func (m LayerMetadata) Reusable(expected LayerMetadata) (bool, string) {
+	if m.Checksum == "" || !postal.Checksum(expected.Checksum).MatchString(m.Checksum) {
+		return false, "dependency checksum changed"
+	}
+
+	if m.SchemaVersion != expected.SchemaVersion && m.SchemaVersion != 0 {
+		return false, "layer metadata schema changed"
+	}
+
+	return true, ""
+}
+
+// MissingFixups returns the fixups listed in expected that have not been
+// applied to the layer described by the cached metadata.
+func (m LayerMetadata) MissingFixups(expected LayerMetadata) []string {
+	var missing []string
+	for _, fixup := range expected.Fixups {
+		if !slices.Contains(m.Fixups, fixup) {
+			missing = append(missing, fixup)
+		}
+	}
+
+	return missing
+}
+
+// requiredFixups returns the post-install fixups that a layer must have had
+// applied before it can be reused.
+func requiredFixups() []string {
+	return []string{FixupBinLayout, FixupLicenses}
+}
+
+// applyFixups applies the post-install fixups for the given dependency to
+// the layer. Every fixup is idempotent, so they can also be applied to a
+// cached layer that was built without them.
+func applyFixups(layerPath, cnbPath string, dependency postal.Dependency) error {
+	err := ensureLayout(layerPath, dependency.ID)
+	if err != nil {
+		return err
+	}
+
+	return bundleLicenses(cnbPath, layerPath, dependency)
+}
@@ -0,0 +1,105 @@
+package yarn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FixupBinLayout records that the bin directory of the layer has been checked
+// against the expected layout, made executable and completed with any
+// missing shims.
+const FixupBinLayout = "bin-layout"
+
+// shim runs the bundled yarn.js entrypoint with the node found on the PATH.
+// It stands in for launcher scripts missing from a dependency.
+const shim = `#!/bin/sh
+exec node "$(dirname "$0")/yarn.js" "$@"
+`
+
+type binLayout struct {
+	Entrypoints []string
+	Shims       []string
+}
+
+// layouts describes what the bin directory of each flavor of Yarn must
+// contain. Entrypoints have to be shipped by the dependency; shims are created
+// when the dependency does not ship them.
+var layouts = map[string]binLayout{
+	YarnDependency: {
+		Entrypoints: []string{"yarn", "yarn.js", "yarnpkg"},
+	},
+	BerryDependency: {
+		Entrypoints: []string{"yarn.js"},
+		Shims:       []string{"yarn", "yarnpkg"},
+	},
+}
+
+// ensureLayout checks that the layer contains the entrypoints for the given
+// flavor of Yarn, creates any missing shims and makes every file in the bin
+// directory executable. The @yarnpkg/cli-dist tarball, for example, ships
+// bin/yarn as 0644.
+func ensureLayout(layerPath, dependencyID string) error {
+	layout, ok := layouts[dependencyID]
+	if !ok {
+		return fmt.Errorf("no bin layout is known for dependency %q", dependencyID)
+	}
+
+	binDir := filepath.Join(layerPath, "bin")
+
+	for _, entrypoint := range layout.Entrypoints {
+		info, err := os.Stat(filepath.Join(binDir, entrypoint))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("%s dependency is missing required entrypoint bin/%s", dependencyID, entrypoint)
+			}
+			return fmt.Errorf("failed to inspect bin/%s: %w", entrypoint, err)
+		}
+
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s dependency entrypoint bin/%s is not a regular file", dependencyID, entrypoint)
+		}
+	}
+
+	for _, name := range layout.Shims {
+		path := filepath.Join(binDir, name)
+
+		_, err := os.Lstat(path)
+		if err == nil {
+			continue
+		}
+
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to inspect bin/%s: %w", name, err)
+		}
+
+		err = os.WriteFile(path, []byte(shim), 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create bin/%s shim: %w", name, err)
+		}
+	}
+
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		return fmt.Errorf("failed to read bin directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to inspect bin/%s: %w", entry.Name(), err)
+		}
+
+		err = os.Chmod(filepath.Join(binDir, entry.Name()), info.Mode().Perm()|0111)
+		if err != nil {
+			return fmt.Errorf("failed to make bin/%s executable: %w", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
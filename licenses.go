@@ -0,0 +1,107 @@
+package yarn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+)
+
+// LicensesDir is the directory that holds the license files a Yarn tarball
+// does not ship itself: in the buildpack, under a directory named after the
+// dependency ID, as published upstream, and in the yarn layer, where they are
+// copied to. The Classic tarball ships its LICENSE, the Berry cli-dist
+// tarball ships none.
+const LicensesDir = "licenses"
+
+// FixupLicenses records that the license files of the dependency are present
+// in the layer.
+const FixupLicenses = "licenses"
+
+// shippedLicenseFiles are the names under which a Yarn tarball ships its own
+// license file at the root of the layer.
+var shippedLicenseFiles = []string{"LICENSE", "LICENSE.md", "LICENSE.txt"}
+
+// bundleLicenses makes sure that installDir holds the license file of a
+// dependency that declares licenses. When the tarball did not ship one, the
+// upstream license files the buildpack carries for the dependency are copied
+// into the licenses directory of installDir. A dependency without a license
+// file fails the install, as the image would otherwise ship Yarn without its
+// license.
+func bundleLicenses(cnbPath, installDir string, dependency postal.Dependency) error {
+	if len(dependency.Licenses) == 0 {
+		return nil
+	}
+
+	for _, name := range shippedLicenseFiles {
+		_, err := os.Stat(filepath.Join(installDir, name))
+		if err == nil {
+			return nil
+		}
+	}
+
+	sourceDir := filepath.Join(cnbPath, LicensesDir, dependency.ID)
+	entries, err := os.ReadDir(sourceDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read license files of %s: %w", dependency.ID, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			files = append(files, entry.Name())
+		}
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("neither %s %s nor the buildpack ships a license file for it", dependency.ID, dependency.Version)
+	}
+
+	err = os.MkdirAll(filepath.Join(installDir, LicensesDir), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create licenses directory: %w", err)
+	}
+
+	for _, name := range files {
+		content, err := os.ReadFile(filepath.Join(sourceDir, name))
+		if err != nil {
+			return fmt.Errorf("failed to read license file %s: %w", name, err)
+		}
+
+		err = os.WriteFile(filepath.Join(installDir, LicensesDir, name), content, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write license file %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// licenseFilePaths returns the license files of the dependency installed in
+// installDir: those the tarball shipped at the root of the layer, followed
+// by those bundled into its licenses directory.
+func licenseFilePaths(installDir string) ([]string, error) {
+	var paths []string
+	for _, name := range shippedLicenseFiles {
+		path := filepath.Join(installDir, name)
+		info, err := os.Stat(path)
+		if err == nil && info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(installDir, LicensesDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read license files: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			paths = append(paths, filepath.Join(installDir, LicensesDir, entry.Name()))
+		}
+	}
+
+	return paths, nil
+}
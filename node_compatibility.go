@@ -0,0 +1,242 @@
+package yarn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Masterminds/semver/v3"
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+// NodeCompatibilityPolicy controls what happens when the Node.js version
+// available during the build does not satisfy the engines.node range of the
+// resolved Yarn dependency.
+type NodeCompatibilityPolicy string
+
+const (
+	NodeCompatibilityFail NodeCompatibilityPolicy = "fail"
+	NodeCompatibilityWarn NodeCompatibilityPolicy = "warn"
+	NodeCompatibilityOff  NodeCompatibilityPolicy = "off"
+)
+
+// nodeCompatibilityPolicyFromEnv reads the policy from
+// BP_YARN_NODE_COMPATIBILITY. It defaults to failing the build.
+func nodeCompatibilityPolicyFromEnv() (NodeCompatibilityPolicy, error) {
+	value, ok := os.LookupEnv("BP_YARN_NODE_COMPATIBILITY")
+	if !ok {
+		return NodeCompatibilityFail, nil
+	}
+
+	policy := NodeCompatibilityPolicy(value)
+	switch policy {
+	case NodeCompatibilityFail, NodeCompatibilityWarn, NodeCompatibilityOff:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("failed to parse BP_YARN_NODE_COMPATIBILITY value %s: must be one of fail, warn or off", value)
+	}
+}
+
+type nodeRequirement struct {
+	Version     string
+	EnginesNode string
+}
+
+// readNodeRequirements returns the versions of the given dependency in
+// buildpack.toml, each with the engines.node range that the node-engines
+// table of the buildpack metadata records for it. The range is kept out of
+// the dependency entries, as the dependency tooling rewrites those without
+// any custom keys, but it keeps the other tables of the metadata. It returns
+// false when buildpack.toml has no node-engines table.
+func readNodeRequirements(cnbPath, dependencyID string) ([]nodeRequirement, bool, error) {
+	var config struct {
+		Metadata struct {
+			Dependencies []struct {
+				ID      string `toml:"id"`
+				Version string `toml:"version"`
+			} `toml:"dependencies"`
+			NodeEngines []struct {
+				ID      string `toml:"id"`
+				Version string `toml:"version"`
+				Node    string `toml:"node"`
+			} `toml:"node-engines"`
+		} `toml:"metadata"`
+	}
+
+	_, err := toml.DecodeFile(filepath.Join(cnbPath, "buildpack.toml"), &config)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to read node requirements from buildpack.toml: %w", err)
+	}
+
+	if config.Metadata.NodeEngines == nil {
+		return nil, false, nil
+	}
+
+	ranges := map[string]string{}
+	for _, engine := range config.Metadata.NodeEngines {
+		if engine.ID == dependencyID {
+			ranges[engine.Version] = engine.Node
+		}
+	}
+
+	var requirements []nodeRequirement
+	for _, dependency := range config.Metadata.Dependencies {
+		if dependency.ID != dependencyID {
+			continue
+		}
+
+		requirement := nodeRequirement{Version: dependency.Version, EnginesNode: ranges[dependency.Version]}
+		if !slices.Contains(requirements, requirement) {
+			requirements = append(requirements, requirement)
+		}
+	}
+
+	return requirements, true, nil
+}
+
+// installedNodeVersion returns the version of Node.js the installed Yarn will
+// run with. A "node-version" in the metadata of the yarn build plan entry,
+// set by the buildpack that requires yarn, takes precedence; otherwise
+// `node --version` is run, preferring $NODE_HOME/bin when it is set. It
+// returns an empty string when no Node.js can be found.
+func installedNodeVersion(entry packit.BuildpackPlanEntry, node Executable) (string, error) {
+	if version, ok := entry.Metadata["node-version"].(string); ok && version != "" {
+		return strings.TrimPrefix(version, "v"), nil
+	}
+
+	env := os.Environ()
+	if nodeHome, ok := os.LookupEnv("NODE_HOME"); ok && nodeHome != "" {
+		env = prependPath(env, filepath.Join(nodeHome, "bin"))
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := node.Execute(pexec.Execution{
+		Args:   []string{"--version"},
+		Env:    env,
+		Stdout: buffer,
+		Stderr: buffer,
+	})
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to run node: %w\n%s", err, buffer.String())
+	}
+
+	return strings.TrimPrefix(strings.TrimSpace(buffer.String()), "v"), nil
+}
+
+// checkNodeCompatibility compares the Node.js version with the engines.node
+// range of the resolved dependency. Node.js is only looked up when
+// buildpack.toml records a range for the dependency. When the range is not
+// satisfied it logs which of the available versions of the dependency would
+// be compatible and, depending on the policy, returns an error.
+func checkNodeCompatibility(
+	cnbPath string,
+	dependency postal.Dependency,
+	entry packit.BuildpackPlanEntry,
+	node Executable,
+	policy NodeCompatibilityPolicy,
+	logger scribe.Emitter,
+	report *BuildReport,
+) error {
+	dependencyID, dependencyVersion := dependency.ID, dependency.Version
+
+	requirements, ok, err := readNodeRequirements(cnbPath, dependencyID)
+	if err != nil || !ok {
+		return err
+	}
+
+	var enginesNode string
+	for _, requirement := range requirements {
+		if requirement.Version == dependencyVersion {
+			enginesNode = requirement.EnginesNode
+			break
+		}
+	}
+
+	logger.Subprocess("Checking Node.js compatibility")
+
+	if enginesNode == "" {
+		logger.Action("Skipping: buildpack.toml records no engines.node range for %s %s", dependencyID, dependencyVersion)
+		logger.Break()
+		return nil
+	}
+
+	nodeVersion, err := installedNodeVersion(entry, node)
+	if err != nil {
+		return err
+	}
+
+	if nodeVersion == "" {
+		logger.Action("Skipping: node is not available on the PATH")
+		logger.Break()
+		report.Warn("skipped the Node.js compatibility check of %s %s: node is not available on the PATH", dependencyID, dependencyVersion)
+		return nil
+	}
+
+	installed, err := semver.NewVersion(nodeVersion)
+	if err != nil {
+		return fmt.Errorf("failed to parse node version %q: %w", nodeVersion, err)
+	}
+
+	satisfied, err := satisfiesEngines(enginesNode, installed)
+	if err != nil {
+		return err
+	}
+
+	if satisfied {
+		logger.Action("Node.js %s satisfies %s %s (engines.node %s)", nodeVersion, dependencyID, dependencyVersion, enginesNode)
+		logger.Break()
+		return nil
+	}
+
+	logger.Action("Node.js %s does not satisfy %s %s (engines.node %s)", nodeVersion, dependencyID, dependencyVersion, enginesNode)
+	logger.Break()
+	logger.Detail("Node.js compatibility of available %s versions:", dependencyID)
+	for _, requirement := range requirements {
+		status := "unknown"
+		if requirement.EnginesNode != "" {
+			ok, err := satisfiesEngines(requirement.EnginesNode, installed)
+			switch {
+			case err != nil:
+				status = "invalid range"
+			case ok:
+				status = "compatible"
+			default:
+				status = "incompatible"
+			}
+		}
+
+		logger.Detail("  %-10s %-20s %s", requirement.Version, requirement.EnginesNode, status)
+	}
+	logger.Break()
+
+	if policy == NodeCompatibilityWarn {
+		report.Warn("%s %s requires Node.js %s, but Node.js %s is installed", dependencyID, dependencyVersion, enginesNode, nodeVersion)
+		return nil
+	}
+
+	return fmt.Errorf("%s %s requires Node.js %s, but Node.js %s is installed: use a compatible Node.js version or set BP_YARN_NODE_COMPATIBILITY=warn", dependencyID, dependencyVersion, enginesNode, nodeVersion)
+}
+
+func satisfiesEngines(enginesNode string, node *semver.Version) (bool, error) {
+	constraint, err := semver.NewConstraint(enginesNode)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse engines.node range %q: %w", enginesNode, err)
+	}
+
+	return constraint.Check(node), nil
+}
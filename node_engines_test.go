@@ -0,0 +1,41 @@
+package yarn_test
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testNodeEngines(t *testing.T, context spec.G, it spec.S) {
+	var Expect = NewWithT(t).Expect
+
+	it("records an engines.node range for every dependency in buildpack.toml", func() {
+		var config struct {
+			Metadata struct {
+				Dependencies []struct {
+					ID      string `toml:"id"`
+					Version string `toml:"version"`
+				} `toml:"dependencies"`
+				NodeEngines []struct {
+					ID      string `toml:"id"`
+					Version string `toml:"version"`
+					Node    string `toml:"node"`
+				} `toml:"node-engines"`
+			} `toml:"metadata"`
+		}
+		_, err := toml.DecodeFile("buildpack.toml", &config)
+		Expect(err).NotTo(HaveOccurred())
+
+		ranges := map[string]string{}
+		for _, engine := range config.Metadata.NodeEngines {
+			ranges[engine.ID+"@"+engine.Version] = engine.Node
+		}
+
+		for _, dependency := range config.Metadata.Dependencies {
+			Expect(ranges).To(HaveKeyWithValue(dependency.ID+"@"+dependency.Version, Not(BeEmpty())))
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package packagemanager_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitPackageManager(t *testing.T) {
+	suite := spec.New("packagemanager", spec.Report(report.Terminal{}), spec.Parallel())
+	suite("Parse", testParse)
+	suite("Read", testRead)
+	suite.Run(t)
+}
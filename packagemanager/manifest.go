@@ -0,0 +1,172 @@
+package packagemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// OnFailActions are the values devEngines accepts for onFail.
+var OnFailActions = []string{"ignore", "warn", "error", "download"}
+
+// DevEngine is an entry of devEngines.packageManager: the name of a package
+// manager, the semver range of its versions and what to do when it does not
+// match.
+type DevEngine struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	OnFail  string `json:"onFail,omitempty"`
+}
+
+// Manifest holds the package manager declarations of a package.json.
+type Manifest struct {
+	// PackageManager is the parsed packageManager field, or nil when it is
+	// absent.
+	PackageManager *Spec
+
+	// DevEngines are the entries of devEngines.packageManager, which may be
+	// a single object or an array of them.
+	DevEngines []DevEngine
+
+	// Engines maps the names in engines, such as node or yarn, to their
+	// ranges. Some apps put values other than semver ranges there, such as
+	// "npm": "please-use-yarn", so the ranges are only checked by Engine.
+	Engines map[string]string
+}
+
+// Read reads the package manager declarations of the package.json at path.
+// Malformed values return a ParseError.
+func Read(path string) (Manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var pkg struct {
+		PackageManager *string `json:"packageManager"`
+		DevEngines     struct {
+			PackageManager json.RawMessage `json:"packageManager"`
+		} `json:"devEngines"`
+		Engines map[string]json.RawMessage `json:"engines"`
+	}
+	err = json.Unmarshal(content, &pkg)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var manifest Manifest
+	if pkg.PackageManager != nil {
+		spec, err := Parse(*pkg.PackageManager)
+		if err != nil {
+			return Manifest{}, err
+		}
+		manifest.PackageManager = &spec
+	}
+
+	manifest.DevEngines, err = parseDevEngines(pkg.DevEngines.PackageManager)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	manifest.Engines, err = parseEngines(pkg.Engines)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	return manifest, nil
+}
+
+// DevEngine returns the first entry of devEngines.packageManager for the
+// named package manager.
+func (m Manifest) DevEngine(name string) (DevEngine, bool) {
+	for _, engine := range m.DevEngines {
+		if engine.Name == name {
+			return engine, true
+		}
+	}
+
+	return DevEngine{}, false
+}
+
+// Engine returns the semver range that engines declares for the named
+// package manager or runtime. A value that is not a semver range returns a
+// ParseError.
+func (m Manifest) Engine(name string) (string, bool, error) {
+	version, ok := m.Engines[name]
+	if !ok {
+		return "", false, nil
+	}
+
+	if _, err := semver.NewConstraint(version); err != nil {
+		return "", false, ParseError{Field: "engines." + name, Value: version, Reason: "must be a semver range"}
+	}
+
+	return version, true, nil
+}
+
+func parseDevEngines(raw json.RawMessage) ([]DevEngine, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var engines []DevEngine
+	if raw[0] == '[' {
+		if err := json.Unmarshal(raw, &engines); err != nil {
+			return nil, ParseError{Field: "devEngines.packageManager", Value: string(raw), Reason: "must be an object or an array of objects"}
+		}
+	} else {
+		var engine DevEngine
+		if err := json.Unmarshal(raw, &engine); err != nil {
+			return nil, ParseError{Field: "devEngines.packageManager", Value: string(raw), Reason: "must be an object or an array of objects"}
+		}
+		engines = []DevEngine{engine}
+	}
+
+	for _, engine := range engines {
+		if engine.Name == "" {
+			return nil, ParseError{Field: "devEngines.packageManager.name", Value: engine.Name, Reason: "must not be empty"}
+		}
+
+		if engine.Version != "" {
+			if _, err := semver.NewConstraint(engine.Version); err != nil {
+				return nil, ParseError{Field: "devEngines.packageManager.version", Value: engine.Version, Reason: "must be a semver range"}
+			}
+		}
+
+		if engine.OnFail != "" && !slices.Contains(OnFailActions, engine.OnFail) {
+			return nil, ParseError{Field: "devEngines.packageManager.onFail", Value: engine.OnFail, Reason: "must be one of " + strings.Join(OnFailActions, ", ")}
+		}
+	}
+
+	return engines, nil
+}
+
+func parseEngines(raw map[string]json.RawMessage) (map[string]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	// Report the first malformed engine by name, so the error is stable.
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	engines := map[string]string{}
+	for _, name := range names {
+		var version string
+		if err := json.Unmarshal(raw[name], &version); err != nil {
+			return nil, ParseError{Field: "engines." + name, Value: string(raw[name]), Reason: "must be a string"}
+		}
+
+		engines[name] = version
+	}
+
+	return engines, nil
+}
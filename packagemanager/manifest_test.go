@@ -0,0 +1,154 @@
+package packagemanager_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/yarn/packagemanager"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testRead(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		workingDir  string
+		packageJSON string
+	)
+
+	it.Before(func() {
+		var err error
+		workingDir, err = os.MkdirTemp("", "working-dir")
+		Expect(err).NotTo(HaveOccurred())
+
+		packageJSON = filepath.Join(workingDir, "package.json")
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(workingDir)).To(Succeed())
+	})
+
+	it("reads the package manager declarations", func() {
+		Expect(os.WriteFile(packageJSON, []byte(`{
+			"packageManager": "yarn@4.14.1+sha512.abcdef",
+			"devEngines": {
+				"packageManager": {"name": "yarn", "version": "^4.14.0", "onFail": "download"}
+			},
+			"engines": {"node": ">=20", "yarn": "4.x", "npm": "please-use-yarn"}
+		}`), 0600)).To(Succeed())
+
+		manifest, err := packagemanager.Read(packageJSON)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(manifest).To(Equal(packagemanager.Manifest{
+			PackageManager: &packagemanager.Spec{
+				Name:    "yarn",
+				Version: "4.14.1",
+				Hash:    packagemanager.Hash{Algorithm: "sha512", Digest: "abcdef"},
+			},
+			DevEngines: []packagemanager.DevEngine{
+				{Name: "yarn", Version: "^4.14.0", OnFail: "download"},
+			},
+			Engines: map[string]string{"node": ">=20", "yarn": "4.x", "npm": "please-use-yarn"},
+		}))
+
+		engine, ok := manifest.DevEngine("yarn")
+		Expect(ok).To(BeTrue())
+		Expect(engine.Version).To(Equal("^4.14.0"))
+
+		version, ok, err := manifest.Engine("yarn")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(version).To(Equal("4.x"))
+
+		_, _, err = manifest.Engine("npm")
+		Expect(err).To(Equal(packagemanager.ParseError{Field: "engines.npm", Value: "please-use-yarn", Reason: "must be a semver range"}))
+	})
+
+	it("reads an array of devEngines.packageManager entries", func() {
+		Expect(os.WriteFile(packageJSON, []byte(`{
+			"devEngines": {
+				"packageManager": [{"name": "pnpm"}, {"name": "yarn", "version": "1.22.x"}]
+			}
+		}`), 0600)).To(Succeed())
+
+		manifest, err := packagemanager.Read(packageJSON)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(manifest.DevEngines).To(HaveLen(2))
+		engine, ok := manifest.DevEngine("yarn")
+		Expect(ok).To(BeTrue())
+		Expect(engine.Version).To(Equal("1.22.x"))
+
+		_, ok = manifest.DevEngine("npm")
+		Expect(ok).To(BeFalse())
+	})
+
+	it("returns an empty manifest when nothing is declared", func() {
+		Expect(os.WriteFile(packageJSON, []byte(`{"name": "some-app"}`), 0600)).To(Succeed())
+
+		manifest, err := packagemanager.Read(packageJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest).To(Equal(packagemanager.Manifest{}))
+
+		_, ok, err := manifest.Engine("yarn")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	context("failure cases", func() {
+		context("when package.json cannot be read", func() {
+			it("returns an error", func() {
+				_, err := packagemanager.Read(packageJSON)
+				Expect(err).To(MatchError(ContainSubstring("failed to read " + packageJSON)))
+				Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+			})
+		})
+
+		context("when package.json is not JSON", func() {
+			it("returns an error", func() {
+				Expect(os.WriteFile(packageJSON, []byte(`{`), 0600)).To(Succeed())
+
+				_, err := packagemanager.Read(packageJSON)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse " + packageJSON)))
+			})
+		})
+
+		for content, expected := range map[string]packagemanager.ParseError{
+			`{"packageManager": "yarn"}`: {
+				Field: "packageManager", Value: "yarn", Reason: "must be name@version",
+			},
+			`{"devEngines": {"packageManager": "yarn"}}`: {
+				Field: "devEngines.packageManager", Value: `"yarn"`, Reason: "must be an object or an array of objects",
+			},
+			`{"devEngines": {"packageManager": {"version": "4.x"}}}`: {
+				Field: "devEngines.packageManager.name", Value: "", Reason: "must not be empty",
+			},
+			`{"devEngines": {"packageManager": {"name": "yarn", "version": "four"}}}`: {
+				Field: "devEngines.packageManager.version", Value: "four", Reason: "must be a semver range",
+			},
+			`{"devEngines": {"packageManager": {"name": "yarn", "onFail": "panic"}}}`: {
+				Field: "devEngines.packageManager.onFail", Value: "panic", Reason: "must be one of ignore, warn, error, download",
+			},
+			`{"engines": {"yarn": 4}}`: {
+				Field: "engines.yarn", Value: "4", Reason: "must be a string",
+			},
+		} {
+			context("when package.json contains "+content, func() {
+				it("returns a ParseError", func() {
+					Expect(os.WriteFile(packageJSON, []byte(content), 0600)).To(Succeed())
+
+					_, err := packagemanager.Read(packageJSON)
+
+					var parseError packagemanager.ParseError
+					Expect(errors.As(err, &parseError)).To(BeTrue())
+					Expect(parseError).To(Equal(expected))
+				})
+			})
+		}
+	})
+}
@@ -0,0 +1,179 @@
+// Package packagemanager parses the package manager declarations of a
+// package.json: the corepack packageManager field, devEngines.packageManager
+// and engines.
+package packagemanager
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// HashAlgorithms are the algorithms corepack accepts in the hash of a
+// packageManager.
+var HashAlgorithms = []string{"sha1", "sha224", "sha256", "sha384", "sha512"}
+
+// ParseError is returned for a malformed value in package.json. Field is the
+// path of the value, for example packageManager or engines.yarn.
+type ParseError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+// Hash is the integrity hash of a packageManager, such as the sha512.<hex>
+// that corepack appends after a "+" or, for URLs, a "#".
+type Hash struct {
+	Algorithm string
+	Digest    string
+}
+
+// String returns the hash as algorithm.digest, or an empty string when there
+// is none.
+func (h Hash) String() string {
+	if h.Algorithm == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s.%s", h.Algorithm, h.Digest)
+}
+
+// Spec is a parsed packageManager, for example yarn@4.14.1+sha512.abc. Only
+// one of Version, Range and URL is set.
+type Spec struct {
+	Name string
+
+	// Version is the exact version, such as 4.14.1.
+	Version string
+
+	// Range is a semver range, such as ^4.14.0. Corepack itself requires an
+	// exact version, but other tools accept ranges.
+	Range string
+
+	// URL is the address of a package archive, such as
+	// https://registry.npmjs.org/@yarnpkg/cli-dist/-/cli-dist-4.14.1.tgz.
+	URL string
+
+	Hash Hash
+}
+
+// Parse parses a packageManager value of the form name@version, name@range
+// or name@url, optionally followed by +algorithm.digest, or #algorithm.digest
+// for URLs. Malformed values return a ParseError.
+func Parse(value string) (Spec, error) {
+	fail := func(reason string) (Spec, error) {
+		return Spec{}, ParseError{Field: "packageManager", Value: value, Reason: reason}
+	}
+
+	// The name of a scoped package starts with an @ of its own.
+	at := strings.Index(value, "@")
+	if strings.HasPrefix(value, "@") {
+		slash := strings.Index(value, "/")
+		if slash < 0 {
+			return fail("scoped name must be @scope/name")
+		}
+
+		at = strings.Index(value[slash:], "@")
+		if at >= 0 {
+			at += slash
+		}
+	}
+
+	if at <= 0 {
+		return fail("must be name@version")
+	}
+
+	spec := Spec{Name: value[:at]}
+	reference := value[at+1:]
+	if reference == "" {
+		return fail("must be name@version")
+	}
+
+	if strings.Contains(reference, "://") {
+		location, hash, found := strings.Cut(reference, "#")
+		parsed, err := url.Parse(location)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fail("URL must be an http or https URL")
+		}
+		spec.URL = location
+
+		if found {
+			spec.Hash, err = parseHash(hash)
+			if err != nil {
+				return fail(err.Error())
+			}
+		}
+
+		return spec, nil
+	}
+
+	version, hash, found := strings.Cut(reference, "+")
+	if found {
+		var err error
+		spec.Hash, err = parseHash(hash)
+		if err != nil {
+			return fail(err.Error())
+		}
+	}
+
+	if _, err := semver.StrictNewVersion(version); err == nil {
+		spec.Version = version
+		return spec, nil
+	}
+
+	if _, err := semver.NewConstraint(version); err != nil {
+		return fail(fmt.Sprintf("%s is neither a version nor a range", version))
+	}
+	spec.Range = version
+
+	return spec, nil
+}
+
+// parseHash parses algorithm.digest, where the digest is hexadecimal.
+func parseHash(value string) (Hash, error) {
+	algorithm, digest, _ := strings.Cut(value, ".")
+	if !slices.Contains(HashAlgorithms, algorithm) {
+		return Hash{}, fmt.Errorf("hash algorithm must be one of %s", strings.Join(HashAlgorithms, ", "))
+	}
+
+	if _, err := hex.DecodeString(digest); err != nil || digest == "" {
+		return Hash{}, fmt.Errorf("hash digest must be hexadecimal")
+	}
+
+	return Hash{Algorithm: algorithm, Digest: digest}, nil
+}
+
+// Constraint returns the version or range of the spec, or an empty string for
+// a URL.
+func (s Spec) Constraint() string {
+	if s.Version != "" {
+		return s.Version
+	}
+
+	return s.Range
+}
+
+// String returns the spec in the form it is parsed from.
+func (s Spec) String() string {
+	if s.URL != "" {
+		if s.Hash.Algorithm != "" {
+			return fmt.Sprintf("%s@%s#%s", s.Name, s.URL, s.Hash)
+		}
+
+		return fmt.Sprintf("%s@%s", s.Name, s.URL)
+	}
+
+	if s.Hash.Algorithm != "" {
+		return fmt.Sprintf("%s@%s+%s", s.Name, s.Constraint(), s.Hash)
+	}
+
+	return fmt.Sprintf("%s@%s", s.Name, s.Constraint())
+}
@@ -0,0 +1,85 @@
+package packagemanager_test
+
+import (
+	"testing"
+
+	"github.com/paketo-buildpacks/yarn/packagemanager"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testParse(t *testing.T, context spec.G, it spec.S) {
+	var Expect = NewWithT(t).Expect
+
+	it("parses an exact version", func() {
+		spec, err := packagemanager.Parse("yarn@4.14.1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(spec).To(Equal(packagemanager.Spec{Name: "yarn", Version: "4.14.1"}))
+		Expect(spec.Constraint()).To(Equal("4.14.1"))
+		Expect(spec.String()).To(Equal("yarn@4.14.1"))
+	})
+
+	it("parses the hash after the version", func() {
+		spec, err := packagemanager.Parse("yarn@4.14.1+sha512.abcdef")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(spec).To(Equal(packagemanager.Spec{
+			Name:    "yarn",
+			Version: "4.14.1",
+			Hash:    packagemanager.Hash{Algorithm: "sha512", Digest: "abcdef"},
+		}))
+		Expect(spec.String()).To(Equal("yarn@4.14.1+sha512.abcdef"))
+	})
+
+	it("parses a range", func() {
+		spec, err := packagemanager.Parse("pnpm@^9.1.0")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(spec).To(Equal(packagemanager.Spec{Name: "pnpm", Range: "^9.1.0"}))
+		Expect(spec.Constraint()).To(Equal("^9.1.0"))
+	})
+
+	it("parses a URL with its hash", func() {
+		spec, err := packagemanager.Parse("yarn@https://registry.npmjs.org/@yarnpkg/cli-dist/-/cli-dist-4.14.1.tgz#sha224.0123ab")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(spec).To(Equal(packagemanager.Spec{
+			Name: "yarn",
+			URL:  "https://registry.npmjs.org/@yarnpkg/cli-dist/-/cli-dist-4.14.1.tgz",
+			Hash: packagemanager.Hash{Algorithm: "sha224", Digest: "0123ab"},
+		}))
+		Expect(spec.Constraint()).To(BeEmpty())
+		Expect(spec.String()).To(Equal("yarn@https://registry.npmjs.org/@yarnpkg/cli-dist/-/cli-dist-4.14.1.tgz#sha224.0123ab"))
+	})
+
+	it("parses a scoped name", func() {
+		spec, err := packagemanager.Parse("@yarnpkg/cli-dist@4.14.1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(spec).To(Equal(packagemanager.Spec{Name: "@yarnpkg/cli-dist", Version: "4.14.1"}))
+	})
+
+	context("failure cases", func() {
+		for value, reason := range map[string]string{
+			"yarn":                      "must be name@version",
+			"yarn@":                     "must be name@version",
+			"@4.14.1":                   "scoped name must be @scope/name",
+			"@yarnpkg@4.14.1":           "scoped name must be @scope/name",
+			"yarn@latest-and-greatest":  "latest-and-greatest is neither a version nor a range",
+			"yarn@4.14.1+md5.abcdef":    "hash algorithm must be one of sha1, sha224, sha256, sha384, sha512",
+			"yarn@4.14.1+sha512.xyz":    "hash digest must be hexadecimal",
+			"yarn@4.14.1+sha512":        "hash digest must be hexadecimal",
+			"yarn@ftp://example.com/y":  "URL must be an http or https URL",
+			"yarn@https://":             "URL must be an http or https URL",
+			"yarn@https://a.com/y#sha1": "hash digest must be hexadecimal",
+		} {
+			it("returns a ParseError for "+value, func() {
+				_, err := packagemanager.Parse(value)
+				Expect(err).To(Equal(packagemanager.ParseError{Field: "packageManager", Value: value, Reason: reason}))
+				Expect(err).To(MatchError(ContainSubstring(`invalid packageManager "` + value + `": ` + reason)))
+			})
+		}
+	})
+}
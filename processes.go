@@ -0,0 +1,120 @@
+package yarn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2"
+)
+
+// invalidProcessTypeChars matches the characters that process types may not
+// contain, such as the colon in db:migrate.
+var invalidProcessTypeChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// processesFromEnv returns a `yarn run <script>` process for each script of
+// the project selected in BP_YARN_PROCESSES, a comma-separated list of script
+// names whose first entry is the default process. The value * selects every
+// script, with start as the default when there is one. It returns no
+// processes when the variable is not set.
+//
+// A project outside the working directory is selected with --cwd for Yarn
+// Classic. Yarn Berry rejects that flag, so its processes run in the project
+// directory instead.
+func processesFromEnv(project Project, workingDir, dependencyID string) ([]packit.Process, error) {
+	value := os.Getenv("BP_YARN_PROCESSES")
+	if value == "" {
+		return nil, nil
+	}
+
+	scripts, err := readScripts(project.PackageJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	defaultName := ""
+	if strings.TrimSpace(value) == "*" {
+		for name := range scripts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if _, ok := scripts["start"]; ok {
+			defaultName = "start"
+		}
+	} else {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			if _, ok := scripts[name]; !ok {
+				return nil, fmt.Errorf("BP_YARN_PROCESSES lists script %s, which is not defined in the scripts of %s", name, project.PackageJSON)
+			}
+
+			names = append(names, name)
+		}
+
+		if len(names) > 0 {
+			defaultName = names[0]
+		}
+	}
+
+	var args []string
+	var processDir string
+	if project.Path != workingDir {
+		if dependencyID == BerryDependency {
+			processDir = project.Path
+		} else {
+			args = append(args, "--cwd", project.Path)
+		}
+	}
+
+	var processes []packit.Process
+	types := map[string]string{}
+	for _, name := range names {
+		processType := invalidProcessTypeChars.ReplaceAllString(name, "-")
+		if other, ok := types[processType]; ok {
+			return nil, fmt.Errorf("BP_YARN_PROCESSES selects scripts %s and %s, which both become process type %s", other, name, processType)
+		}
+		types[processType] = name
+
+		processes = append(processes, packit.Process{
+			Type:             processType,
+			Command:          "yarn",
+			Args:             append(append([]string{}, args...), "run", name),
+			Default:          name == defaultName,
+			Direct:           true,
+			WorkingDirectory: processDir,
+		})
+	}
+
+	return processes, nil
+}
+
+func readScripts(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, errors.New("BP_YARN_PROCESSES is set, but the project has no package.json")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scripts: %w", err)
+	}
+
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	err = json.Unmarshal(content, &pkg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse scripts in %s: %w", path, err)
+	}
+
+	return pkg.Scripts, nil
+}
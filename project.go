@@ -0,0 +1,138 @@
+package yarn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Project locates the files of the Yarn project inside the app directory.
+// Each file path is empty when the project does not contain the file.
+type Project struct {
+	Path        string
+	PackageJSON string
+	YarnrcYML   string
+	Yarnrc      string
+	Lockfile    string
+
+	// manifest shares the package manager declarations of PackageJSON
+	// between the version sources of a resolve.
+	manifest *cachedManifest
+}
+
+// FindProject returns the Yarn project of the app in workingDir. By default
+// the project is the app directory itself; BP_YARN_PROJECT_PATH selects a
+// subdirectory, for example the frontend of a monorepo. The path must exist
+// and must not leave the app directory.
+func FindProject(workingDir string) (Project, error) {
+	projectPath := workingDir
+
+	if value, ok := os.LookupEnv("BP_YARN_PROJECT_PATH"); ok && value != "" {
+		var err error
+		projectPath, err = resolveProjectPath("BP_YARN_PROJECT_PATH", workingDir, value)
+		if err != nil {
+			return Project{}, err
+		}
+	}
+
+	return newProject(projectPath)
+}
+
+// FindProjects returns the independent Yarn projects listed, comma separated,
+// in BP_YARN_PROJECT_PATHS. Each project may pin its own version of Yarn. It
+// returns no projects when the variable is not set.
+func FindProjects(workingDir string) ([]Project, error) {
+	value, ok := os.LookupEnv("BP_YARN_PROJECT_PATHS")
+	if !ok || value == "" {
+		return nil, nil
+	}
+
+	if single, ok := os.LookupEnv("BP_YARN_PROJECT_PATH"); ok && single != "" {
+		return nil, errors.New("BP_YARN_PROJECT_PATH and BP_YARN_PROJECT_PATHS cannot both be set")
+	}
+
+	var projects []Project
+	for _, path := range strings.Split(value, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		projectPath, err := resolveProjectPath("BP_YARN_PROJECT_PATHS", workingDir, path)
+		if err != nil {
+			return nil, err
+		}
+
+		project, err := newProject(projectPath)
+		if err != nil {
+			return nil, err
+		}
+
+		projects = append(projects, project)
+	}
+
+	return projects, nil
+}
+
+func newProject(projectPath string) (Project, error) {
+	project := Project{Path: projectPath}
+	for name, field := range map[string]*string{
+		"package.json": &project.PackageJSON,
+		".yarnrc.yml":  &project.YarnrcYML,
+		".yarnrc":      &project.Yarnrc,
+		"yarn.lock":    &project.Lockfile,
+	} {
+		path := filepath.Join(projectPath, name)
+
+		_, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return Project{}, fmt.Errorf("failed to inspect %s: %w", path, err)
+		}
+
+		*field = path
+	}
+
+	return project, nil
+}
+
+func resolveProjectPath(name, workingDir, value string) (string, error) {
+	if filepath.IsAbs(value) {
+		return "", fmt.Errorf("invalid %s %q: must be relative to the app directory", name, value)
+	}
+
+	root, err := filepath.EvalSymlinks(workingDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve app directory: %w", err)
+	}
+
+	path, err := filepath.EvalSymlinks(filepath.Join(root, value))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("invalid %s %q: %s does not exist", name, value, filepath.Join(workingDir, value))
+		}
+		return "", fmt.Errorf("failed to resolve %s %q: %w", name, value, err)
+	}
+
+	// Symlinks are resolved before the check so that a link cannot point the
+	// project outside of the app directory.
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid %s %q: must be inside the app directory", name, value)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect %s %q: %w", name, value, err)
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("invalid %s %q: %s is not a directory", name, value, filepath.Join(workingDir, value))
+	}
+
+	return filepath.Join(workingDir, rel), nil
+}
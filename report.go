@@ -0,0 +1,102 @@
+package yarn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+)
+
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+
+	CacheSourceLayer         = "layer"
+	CacheSourceArtifactCache = "artifact-cache"
+)
+
+// BuildReport is the machine-readable summary of a build that is written to
+// the path set in BP_YARN_REPORT_PATH.
+type BuildReport struct {
+	Installs []InstallReport `json:"installs"`
+	Warnings []string        `json:"warnings"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// InstallReport describes how one Yarn dependency was provided. Cache is hit
+// when the dependency came from a cached layer or the artifact cache, in
+// which case CacheSource tells which, and miss when it was delivered. Cache
+// is empty for a system Yarn. Source names the version source that decided
+// the resolution, as in VersionResolution.Source. Durations are in
+// milliseconds.
+type InstallReport struct {
+	DependencyID       string `json:"dependency-id"`
+	Flavor             string `json:"flavor"`
+	Version            string `json:"version"`
+	Source             string `json:"source"`
+	Cache              string `json:"cache,omitempty"`
+	CacheSource        string `json:"cache-source,omitempty"`
+	DeliveryDurationMS int64  `json:"delivery-duration-ms"`
+	SBOMDurationMS     int64  `json:"sbom-duration-ms"`
+}
+
+func newInstallReport(dependency postal.Dependency, source string) InstallReport {
+	return InstallReport{
+		DependencyID: dependency.ID,
+		Flavor:       Flavor(dependency.ID),
+		Version:      dependency.Version,
+		Source:       source,
+	}
+}
+
+func (r *BuildReport) addInstall(install InstallReport) {
+	if r == nil {
+		return
+	}
+
+	r.Installs = append(r.Installs, install)
+}
+
+// Warn records a warning. The caller remains responsible for logging it.
+func (r *BuildReport) Warn(format string, v ...interface{}) {
+	if r == nil {
+		return
+	}
+
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, v...))
+}
+
+// write writes the report to path, recording err as the cause of a failed
+// build.
+func (r *BuildReport) write(path string, err error) error {
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	if r.Installs == nil {
+		r.Installs = []InstallReport{}
+	}
+
+	if r.Warnings == nil {
+		r.Warnings = []string{}
+	}
+
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode build report: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to write build report: %w", err)
+	}
+
+	err = os.WriteFile(path, append(content, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write build report: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package yarn
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultSourceDateEpoch is the timestamp given to the files of the layers
+// when SOURCE_DATE_EPOCH is not set. It is the earliest time that zip-based
+// formats can represent, as used by other reproducible build tools.
+var DefaultSourceDateEpoch = time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC)
+
+// sourceDateEpochFromEnv returns the time in SOURCE_DATE_EPOCH, a number of
+// seconds since the Unix epoch, or DefaultSourceDateEpoch when it is not set.
+func sourceDateEpochFromEnv() (time.Time, error) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return DefaultSourceDateEpoch, nil
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, fmt.Errorf("failed to parse SOURCE_DATE_EPOCH value %s: must be a number of seconds since the Unix epoch", value)
+	}
+
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// normalizeLayers normalizes the layers of the result that end up in an image.
+// Layers that are only cached are left alone.
+func normalizeLayers(layers []packit.Layer, epoch time.Time) error {
+	for _, layer := range layers {
+		if !layer.Launch && !layer.Build {
+			continue
+		}
+
+		_, err := os.Lstat(layer.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		err = normalizeTree(layer.Path, epoch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// normalizeTree makes the files below root independent of when and in which
+// order they were written: every file, directory and symlink gets the given
+// modification time and the ownership of the build user, directories and
+// executable files get mode 0755, and other files mode 0644.
+func normalizeTree(root string, epoch time.Time) error {
+	uid, gid := os.Getuid(), os.Getgid()
+
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		err = os.Lchown(path, uid, gid)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			return lchtimes(path, epoch)
+
+		case entry.IsDir():
+			// Setting the times of the files in a directory does not change
+			// the directory, but its mode may have to allow traversal first,
+			// so its times are set once the walk is done.
+			dirs = append(dirs, path)
+			return os.Chmod(path, 0755)
+
+		default:
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+
+			mode := fs.FileMode(0644)
+			if info.Mode()&0111 != 0 {
+				mode = 0755
+			}
+
+			err = os.Chmod(path, mode)
+			if err != nil {
+				return err
+			}
+
+			return os.Chtimes(path, epoch, epoch)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to normalize %s: %w", root, err)
+	}
+
+	slices.Reverse(dirs)
+	for _, dir := range dirs {
+		err = os.Chtimes(dir, epoch, epoch)
+		if err != nil {
+			return fmt.Errorf("failed to normalize %s: %w", root, err)
+		}
+	}
+
+	return nil
+}
+
+func lchtimes(path string, t time.Time) error {
+	tv := unix.NsecToTimeval(t.UnixNano())
+	return unix.Lutimes(path, []unix.Timeval{tv, tv})
+}
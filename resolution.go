@@ -0,0 +1,238 @@
+package yarn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Masterminds/semver/v3"
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+// Inspection explains which Yarn a build of an app would install, with one
+// ProjectInspection for each Yarn project of the app.
+type Inspection struct {
+	Projects []ProjectInspection `json:"projects"`
+}
+
+// ProjectInspection is the Yarn chosen for a project and the decisions that
+// led to it. When the dependency cannot be resolved, Error says why and the
+// version, checksum and URI are empty.
+type ProjectInspection struct {
+	Path         string   `json:"path"`
+	DependencyID string   `json:"dependency-id"`
+	Constraint   string   `json:"constraint"`
+	System       bool     `json:"system,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	Checksum     string   `json:"checksum,omitempty"`
+	URI          string   `json:"uri,omitempty"`
+	Trail        []string `json:"trail"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// Inspect runs the resolution of Build for the app in workingDir against the
+// dependencies in buildpackTOML, without downloading or installing anything.
+// It reads the same environment variables as Build. A project whose
+// dependency cannot be resolved is reported with an error rather than
+// failing the inspection.
+func Inspect(dependencyManager DependencyManager, versionResolver VersionResolver, workingDir, buildpackTOML, stack string, plan packit.BuildpackPlan) (Inspection, error) {
+	useSystemYarn, err := lookupBoolEnv("BP_YARN_USE_SYSTEM")
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	projects, err := FindProjects(workingDir)
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	var inspection Inspection
+	if len(projects) > 0 {
+		if useSystemYarn {
+			return Inspection{}, errors.New("BP_YARN_USE_SYSTEM cannot be combined with BP_YARN_PROJECT_PATHS")
+		}
+
+		for _, project := range projects {
+			resolution, err := versionResolver.Resolve(project, plan.Entries, true)
+			if err != nil {
+				return Inspection{}, err
+			}
+
+			inspection.Projects = append(inspection.Projects,
+				inspectDependency(dependencyManager, buildpackTOML, stack, project.Path, resolution.DependencyID, resolution.Constraint, resolution.Trail))
+		}
+
+		return inspection, nil
+	}
+
+	project, err := FindProject(workingDir)
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	resolution, err := versionResolver.Resolve(project, plan.Entries, false)
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	dependencyID, version, trail := resolution.DependencyID, resolution.Constraint, resolution.Trail
+
+	if useSystemYarn {
+		constraint := systemYarnConstraint(dependencyID, version)
+		inspection.Projects = append(inspection.Projects, ProjectInspection{
+			Path:         project.Path,
+			DependencyID: dependencyID,
+			Constraint:   constraint,
+			System:       true,
+			Trail:        append(trail, fmt.Sprintf("BP_YARN_USE_SYSTEM is set, so the yarn on the PATH is used if it satisfies %s", constraint)),
+		})
+
+		return inspection, nil
+	}
+
+	inspection.Projects = append(inspection.Projects,
+		inspectDependency(dependencyManager, buildpackTOML, stack, project.Path, dependencyID, version, trail))
+
+	return inspection, nil
+}
+
+// inspectDependency resolves the dependency like Build does and adds the
+// versions that buildpack.toml offers to the trail.
+func inspectDependency(dependencyManager DependencyManager, buildpackTOML, stack, path, dependencyID, version string, trail []string) ProjectInspection {
+	inspection := ProjectInspection{
+		Path:         path,
+		DependencyID: dependencyID,
+		Constraint:   version,
+		Trail:        trail,
+	}
+
+	offered, err := readOfferedVersions(buildpackTOML, dependencyID, stack)
+	if err != nil {
+		inspection.Error = err.Error()
+		return inspection
+	}
+
+	constraint := version
+	if version == "" || version == "default" {
+		constraint = "*"
+		if offered.Default != "" {
+			constraint = offered.Default
+			inspection.Trail = append(inspection.Trail, fmt.Sprintf("The default version of %s in buildpack.toml is %s", dependencyID, offered.Default))
+		} else {
+			inspection.Trail = append(inspection.Trail, fmt.Sprintf("buildpack.toml has no default version of %s, so any version matches", dependencyID))
+		}
+	}
+
+	if len(offered.Versions) == 0 {
+		inspection.Trail = append(inspection.Trail, fmt.Sprintf("buildpack.toml offers no version of %s for stack %s on %s", dependencyID, stack, offered.Platform))
+	} else {
+		inspection.Trail = append(inspection.Trail, fmt.Sprintf("buildpack.toml offers %s %s for stack %s on %s", dependencyID, strings.Join(offered.Versions, ", "), stack, offered.Platform))
+	}
+
+	dependency, err := dependencyManager.Resolve(buildpackTOML, dependencyID, version, stack)
+	if err != nil {
+		inspection.Error = err.Error()
+		return inspection
+	}
+
+	inspection.Version = dependency.Version
+	inspection.Checksum = dependency.Checksum
+	inspection.URI = dependency.URI
+	inspection.Trail = append(inspection.Trail, fmt.Sprintf("%s %s is the highest of them that satisfies %s", dependencyID, dependency.Version, constraint))
+
+	return inspection
+}
+
+// warnSkippedSources logs and reports the version sources that the resolution
+// skipped because package.json could not be read.
+func warnSkippedSources(logger scribe.Emitter, report *BuildReport, resolution VersionResolution) {
+	for _, warning := range resolution.Warnings {
+		logger.Process("Ignoring the package manager declarations of package.json: %s", warning)
+		logger.Break()
+		report.Warn("ignored the package manager declarations of package.json: %s", warning)
+	}
+}
+
+// logTrail logs the decision trail of the resolution at the debug level.
+func logTrail(logger scribe.Emitter, trail []string) {
+	for _, decision := range trail {
+		logger.Debug.Subprocess("%s", decision)
+	}
+	logger.Debug.Break()
+}
+
+// offeredVersions are the versions of a dependency in buildpack.toml that
+// support a stack on the target platform, and the default version constraint
+// of the dependency.
+type offeredVersions struct {
+	Versions []string
+	Default  string
+	Platform string
+}
+
+// readOfferedVersions reads the versions of the dependency that postal would
+// choose from. postal.Service does not expose them, so the file is read
+// directly.
+func readOfferedVersions(path, dependencyID, stack string) (offeredVersions, error) {
+	var config struct {
+		Metadata struct {
+			DefaultVersions map[string]string `toml:"default-versions"`
+			Dependencies    []struct {
+				ID      string   `toml:"id"`
+				Version string   `toml:"version"`
+				Stacks  []string `toml:"stacks"`
+				OS      string   `toml:"os"`
+				Arch    string   `toml:"arch"`
+			} `toml:"dependencies"`
+		} `toml:"metadata"`
+	}
+
+	_, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return offeredVersions{}, fmt.Errorf("failed to read dependencies from %s: %w", path, err)
+	}
+
+	targetOS, targetArch := os.Getenv("CNB_TARGET_OS"), os.Getenv("CNB_TARGET_ARCH")
+	if targetOS == "" {
+		targetOS = runtime.GOOS
+	}
+	if targetArch == "" {
+		targetArch = runtime.GOARCH
+	}
+
+	offered := offeredVersions{
+		Default:  config.Metadata.DefaultVersions[dependencyID],
+		Platform: fmt.Sprintf("%s/%s", targetOS, targetArch),
+	}
+
+	var versions []*semver.Version
+	for _, dependency := range config.Metadata.Dependencies {
+		if dependency.ID != dependencyID ||
+			!(slices.Contains(dependency.Stacks, stack) || slices.Contains(dependency.Stacks, "*")) ||
+			(dependency.OS != "" && dependency.OS != targetOS) ||
+			(dependency.Arch != "" && dependency.Arch != targetArch) {
+			continue
+		}
+
+		version, err := semver.NewVersion(dependency.Version)
+		if err != nil {
+			return offeredVersions{}, fmt.Errorf("failed to parse version %s of %s in %s: %w", dependency.Version, dependencyID, path, err)
+		}
+
+		if !slices.ContainsFunc(versions, version.Equal) {
+			versions = append(versions, version)
+		}
+	}
+
+	slices.SortFunc(versions, func(a, b *semver.Version) int { return a.Compare(b) })
+	for _, version := range versions {
+		offered.Versions = append(offered.Versions, version.Original())
+	}
+
+	return offered, nil
+}
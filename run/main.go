@@ -2,10 +2,11 @@ package main
 
 import (
 	"os"
+	"time"
 
 	"github.com/paketo-buildpacks/packit/v2"
-	"github.com/paketo-buildpacks/packit/v2/cargo"
 	"github.com/paketo-buildpacks/packit/v2/chronos"
+	"github.com/paketo-buildpacks/packit/v2/pexec"
 	"github.com/paketo-buildpacks/packit/v2/postal"
 	"github.com/paketo-buildpacks/packit/v2/sbom"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
@@ -18,8 +19,12 @@ func (f Generator) GenerateFromDependency(dependency postal.Dependency, path str
 	return sbom.GenerateFromDependency(dependency, path)
 }
 
+func (f Generator) Generate(path string) (sbom.SBOM, error) {
+	return sbom.Generate(path)
+}
+
 func main() {
-	dependencyManager := postal.NewService(cargo.NewTransport())
+	dependencyManager := yarn.NewDeliveryService(yarn.NewTransport())
 	logEmitter := scribe.NewEmitter(os.Stdout).WithLevel(os.Getenv("BP_LOG_LEVEL"))
 
 	packit.Run(
@@ -27,7 +32,11 @@ func main() {
 		yarn.Build(
 			dependencyManager,
 			Generator{},
+			yarn.NewVersionResolver(yarn.DefaultVersionSources()...),
+			pexec.NewExecutable("node"),
+			pexec.NewExecutable("yarn"),
 			chronos.DefaultClock,
+			yarn.SleeperFunc(time.Sleep),
 			logEmitter,
 		),
 	)
@@ -0,0 +1,149 @@
+package yarn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+)
+
+// ReferenceLicenseFiles returns the SBOMs formatted by formatter with the
+// license files at paths referenced from the package entries that describe
+// the dependency, format by format: as license external references in
+// CycloneDX, as license-file external references in SPDX and as locations of
+// the licenses in Syft. A package describes the dependency when it has its
+// PURL, or its version and either its name or one of the npm packages it is
+// published as, so that scanned entries are found as well. Formats without a
+// package list are returned as they are.
+func ReferenceLicenseFiles(formatter packit.SBOMFormatter, dependency postal.Dependency, paths []string) (packit.SBOMFormatter, error) {
+	var referenced mergedSBOM
+	for _, format := range formatter.Formats() {
+		content, err := io.ReadAll(format.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to format %s SBOM: %w", format.Extension, err)
+		}
+
+		if _, ok := sbomPackageLists[format.Extension]; ok && len(paths) > 0 {
+			content, err = referenceLicenseFiles(format.Extension, content, dependency, paths)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		referenced = append(referenced, mergedSBOMFormat{extension: format.Extension, content: content})
+	}
+
+	return referenced, nil
+}
+
+func referenceLicenseFiles(extension string, content []byte, dependency postal.Dependency, paths []string) ([]byte, error) {
+	list := sbomPackageLists[extension]
+
+	var document map[string]json.RawMessage
+	err := json.Unmarshal(content, &document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reference license files in %s SBOM: %w", extension, err)
+	}
+
+	packages, err := sbomPackages(document[list])
+	if err != nil {
+		return nil, fmt.Errorf("failed to reference license files in %s SBOM: %w", extension, err)
+	}
+
+	for i, p := range packages {
+		if !sbomPackageDescribes(p, dependency) {
+			continue
+		}
+
+		var fields map[string]any
+		err = json.Unmarshal(p, &fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reference license files in %s SBOM: %w", extension, err)
+		}
+
+		switch extension {
+		case "cdx.json":
+			references, _ := fields["externalReferences"].([]any)
+			for _, path := range paths {
+				references = append(references, map[string]any{"type": "license", "url": "file://" + path})
+			}
+			fields["externalReferences"] = references
+
+		case "spdx.json":
+			references, _ := fields["externalRefs"].([]any)
+			for _, path := range paths {
+				references = append(references, map[string]any{
+					"referenceCategory": "OTHER",
+					"referenceType":     "license-file",
+					"referenceLocator":  "file://" + path,
+				})
+			}
+			fields["externalRefs"] = references
+
+		case "syft.json":
+			licenses, _ := fields["licenses"].([]any)
+			if len(licenses) == 0 {
+				for _, license := range dependency.Licenses {
+					licenses = append(licenses, map[string]any{"value": license, "spdxExpression": license, "type": "declared"})
+				}
+			}
+
+			for _, license := range licenses {
+				license, ok := license.(map[string]any)
+				if !ok {
+					continue
+				}
+
+				locations, _ := license["locations"].([]any)
+				for _, path := range paths {
+					locations = append(locations, map[string]any{"path": path, "accessPath": path})
+				}
+				license["locations"] = locations
+			}
+			fields["licenses"] = licenses
+		}
+
+		packages[i], err = json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reference license files in %s SBOM: %w", extension, err)
+		}
+	}
+
+	document[list], err = json.Marshal(packages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reference license files in %s SBOM: %w", extension, err)
+	}
+
+	content, err = json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reference license files in %s SBOM: %w", extension, err)
+	}
+
+	return content, nil
+}
+
+// sbomPackageDescribes reports whether a package in any of the supported
+// formats describes the dependency.
+func sbomPackageDescribes(content json.RawMessage, dependency postal.Dependency) bool {
+	var p struct {
+		Name        string `json:"name"`
+		Version     string `json:"version"`
+		VersionInfo string `json:"versionInfo"`
+	}
+
+	// A package that cannot be read describes nothing.
+	_ = json.Unmarshal(content, &p)
+
+	if dependency.PURL != "" && slices.Contains(sbomPackageKeys(content), "purl:"+dependency.PURL) {
+		return true
+	}
+
+	if p.Version != dependency.Version && p.VersionInfo != dependency.Version {
+		return false
+	}
+
+	return p.Name == dependency.Name || slices.Contains(advisoryPackages[dependency.ID], p.Name)
+}
@@ -0,0 +1,162 @@
+package yarn_test
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/sbom"
+	"github.com/paketo-buildpacks/yarn"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testReferenceLicenseFiles(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		dependency postal.Dependency
+	)
+
+	formatter := func(dependencies ...postal.Dependency) packit.SBOMFormatter {
+		var formats packit.SBOMFormats
+		for _, dependency := range dependencies {
+			bom, err := sbom.GenerateFromDependency(dependency, "/layers/yarn")
+			Expect(err).NotTo(HaveOccurred())
+
+			formatter, err := bom.InFormats(sbom.CycloneDXFormat, sbom.SPDXFormat, sbom.SyftFormat)
+			Expect(err).NotTo(HaveOccurred())
+
+			if formats == nil {
+				formats = formatter.Formats()
+				continue
+			}
+
+			merged, err := yarn.MergeSBOMs(formats, formatter)
+			Expect(err).NotTo(HaveOccurred())
+			formats = merged.Formats()
+		}
+
+		return formats
+	}
+
+	// packages returns the package entries of each format, by extension.
+	packages := func(formatter packit.SBOMFormatter) map[string][]map[string]any {
+		lists := map[string]string{"cdx.json": "components", "spdx.json": "packages", "syft.json": "artifacts"}
+
+		result := map[string][]map[string]any{}
+		for _, format := range formatter.Formats() {
+			content, err := io.ReadAll(format.Content)
+			Expect(err).NotTo(HaveOccurred())
+
+			var document map[string]json.RawMessage
+			Expect(json.Unmarshal(content, &document)).To(Succeed())
+
+			var entries []map[string]any
+			Expect(json.Unmarshal(document[lists[format.Extension]], &entries)).To(Succeed())
+			result[format.Extension] = entries
+		}
+
+		return result
+	}
+
+	it.Before(func() {
+		dependency = postal.Dependency{
+			ID:       "berry",
+			Name:     "Yarn Berry",
+			Version:  "4.18.0",
+			PURL:     "pkg:generic/berry@4.18.0",
+			Licenses: []string{"BSD-2-Clause"},
+		}
+	})
+
+	it("references the license files from the entry of the dependency in each format", func() {
+		referenced, err := yarn.ReferenceLicenseFiles(formatter(dependency), dependency, []string{"/layers/yarn/licenses/LICENSE.md"})
+		Expect(err).NotTo(HaveOccurred())
+
+		entries := map[string]map[string]any{}
+		for extension, list := range packages(referenced) {
+			for _, entry := range list {
+				if entry["name"] == "Yarn Berry" {
+					entries[extension] = entry
+				}
+			}
+		}
+		Expect(entries).To(HaveLen(3))
+
+		Expect(entries["cdx.json"]["externalReferences"]).To(ContainElement(map[string]any{
+			"type": "license",
+			"url":  "file:///layers/yarn/licenses/LICENSE.md",
+		}))
+
+		Expect(entries["spdx.json"]["externalRefs"]).To(ContainElement(map[string]any{
+			"referenceCategory": "OTHER",
+			"referenceType":     "license-file",
+			"referenceLocator":  "file:///layers/yarn/licenses/LICENSE.md",
+		}))
+
+		licenses := entries["syft.json"]["licenses"].([]any)
+		Expect(licenses).NotTo(BeEmpty())
+		for _, license := range licenses {
+			Expect(license).To(HaveKeyWithValue("locations", ContainElement(map[string]any{
+				"path":       "/layers/yarn/licenses/LICENSE.md",
+				"accessPath": "/layers/yarn/licenses/LICENSE.md",
+			})))
+		}
+	})
+
+	it("finds the scanned entry of the npm package the dependency is published as", func() {
+		scanned := postal.Dependency{Name: "@yarnpkg/cli-dist", Version: "4.18.0", PURL: "pkg:npm/%40yarnpkg/cli-dist@4.18.0"}
+
+		referenced, err := yarn.ReferenceLicenseFiles(formatter(scanned), dependency, []string{"/layers/yarn/licenses/LICENSE.md"})
+		Expect(err).NotTo(HaveOccurred())
+
+		for extension, entries := range packages(referenced) {
+			Expect(entries).To(ContainElement(And(
+				HaveKeyWithValue("name", "@yarnpkg/cli-dist"),
+				WithTransform(func(entry map[string]any) (string, error) {
+					content, err := json.Marshal(entry)
+					return string(content), err
+				}, ContainSubstring("/layers/yarn/licenses/LICENSE.md")),
+			)), extension)
+		}
+	})
+
+	it("leaves the entries of other packages alone", func() {
+		other := postal.Dependency{Name: "serve", Version: "14.2.3", PURL: "pkg:npm/serve@14.2.3"}
+
+		referenced, err := yarn.ReferenceLicenseFiles(formatter(other, dependency), dependency, []string{"/layers/yarn/licenses/LICENSE.md"})
+		Expect(err).NotTo(HaveOccurred())
+
+		for extension, entries := range packages(referenced) {
+			var names []any
+			for _, entry := range entries {
+				content, err := json.Marshal(entry)
+				Expect(err).NotTo(HaveOccurred())
+
+				if entry["name"] == "serve" || entry["name"] == "Yarn Berry" {
+					names = append(names, entry["name"])
+					Expect(strings.Contains(string(content), "LICENSE.md")).To(Equal(entry["name"] == "Yarn Berry"), extension)
+				}
+			}
+			Expect(names).To(ConsistOf("serve", "Yarn Berry"), extension)
+		}
+	})
+
+	context("failure cases", func() {
+		context("when a format cannot be parsed", func() {
+			it("returns an error", func() {
+				_, err := yarn.ReferenceLicenseFiles(
+					packit.SBOMFormats{{Extension: "cdx.json", Content: strings.NewReader("not json")}},
+					dependency,
+					[]string{"/layers/yarn/licenses/LICENSE.md"},
+				)
+				Expect(err).To(MatchError(ContainSubstring("failed to reference license files in cdx.json SBOM")))
+			})
+		})
+	})
+}
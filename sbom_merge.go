@@ -0,0 +1,184 @@
+package yarn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/paketo-buildpacks/packit/v2"
+)
+
+// sbomPackageLists names the list that holds the packages in each formatted
+// SBOM, by file extension.
+var sbomPackageLists = map[string]string{
+	"cdx.json":  "components",
+	"spdx.json": "packages",
+	"syft.json": "artifacts",
+}
+
+// MergeSBOMs returns the SBOMs formatted by base with the packages of the
+// SBOMs formatted by extra added to them, format by format. A package of
+// extra is not added when base already holds a package with the same PURL or
+// identifier, so that a dependency the scan found is not described twice.
+// Formats that extra does not produce are returned as they are.
+func MergeSBOMs(base, extra packit.SBOMFormatter) (packit.SBOMFormatter, error) {
+	extraFormats := map[string]io.Reader{}
+	for _, format := range extra.Formats() {
+		extraFormats[format.Extension] = format.Content
+	}
+
+	var merged mergedSBOM
+	for _, format := range base.Formats() {
+		content, err := io.ReadAll(format.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to format %s SBOM: %w", format.Extension, err)
+		}
+
+		if reader, ok := extraFormats[format.Extension]; ok {
+			extraContent, err := io.ReadAll(reader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to format %s SBOM: %w", format.Extension, err)
+			}
+
+			content, err = mergeSBOMDocuments(format.Extension, content, extraContent)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		merged = append(merged, mergedSBOMFormat{extension: format.Extension, content: content})
+	}
+
+	return merged, nil
+}
+
+func mergeSBOMDocuments(extension string, base, extra []byte) ([]byte, error) {
+	list, ok := sbomPackageLists[extension]
+	if !ok {
+		return nil, fmt.Errorf("failed to merge %s SBOMs: unsupported format", extension)
+	}
+
+	var document, extraDocument map[string]json.RawMessage
+	err := json.Unmarshal(base, &document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge %s SBOMs: %w", extension, err)
+	}
+
+	err = json.Unmarshal(extra, &extraDocument)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge %s SBOMs: %w", extension, err)
+	}
+
+	packages, err := sbomPackages(document[list])
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge %s SBOMs: %w", extension, err)
+	}
+
+	extraPackages, err := sbomPackages(extraDocument[list])
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge %s SBOMs: %w", extension, err)
+	}
+
+	known := map[string]bool{}
+	for _, p := range packages {
+		for _, key := range sbomPackageKeys(p) {
+			known[key] = true
+		}
+	}
+
+	for _, p := range extraPackages {
+		duplicate := false
+		for _, key := range sbomPackageKeys(p) {
+			duplicate = duplicate || known[key]
+		}
+
+		if !duplicate {
+			packages = append(packages, p)
+		}
+	}
+
+	document[list], err = json.Marshal(packages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge %s SBOMs: %w", extension, err)
+	}
+
+	content, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge %s SBOMs: %w", extension, err)
+	}
+
+	return content, nil
+}
+
+func sbomPackages(list json.RawMessage) ([]json.RawMessage, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	var packages []json.RawMessage
+	err := json.Unmarshal(list, &packages)
+	if err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
+
+// sbomPackageKeys returns the PURL and identifiers of a package in any of the
+// supported formats.
+func sbomPackageKeys(content json.RawMessage) []string {
+	var p struct {
+		ID           string `json:"id"`
+		SPDXID       string `json:"SPDXID"`
+		BOMRef       string `json:"bom-ref"`
+		PURL         string `json:"purl"`
+		ExternalRefs []struct {
+			ReferenceType    string `json:"referenceType"`
+			ReferenceLocator string `json:"referenceLocator"`
+		} `json:"externalRefs"`
+	}
+
+	// A package that cannot be read has no keys, and is kept.
+	_ = json.Unmarshal(content, &p)
+
+	var keys []string
+	for _, id := range []string{p.ID, p.SPDXID, p.BOMRef} {
+		if id != "" {
+			keys = append(keys, "id:"+id)
+		}
+	}
+
+	if p.PURL != "" {
+		keys = append(keys, "purl:"+p.PURL)
+	}
+
+	for _, ref := range p.ExternalRefs {
+		if ref.ReferenceType == "purl" {
+			keys = append(keys, "purl:"+ref.ReferenceLocator)
+		}
+	}
+
+	return keys
+}
+
+// mergedSBOM holds formatted SBOMs and hands out a fresh reader of each on
+// every call to Formats, as the SBOMs are read more than once.
+type mergedSBOM []mergedSBOMFormat
+
+type mergedSBOMFormat struct {
+	extension string
+	content   []byte
+}
+
+func (s mergedSBOM) Formats() []packit.SBOMFormat {
+	var formats []packit.SBOMFormat
+	for _, format := range s {
+		formats = append(formats, packit.SBOMFormat{
+			Extension: format.extension,
+			Content:   bytes.NewReader(format.content),
+		})
+	}
+
+	return formats
+}
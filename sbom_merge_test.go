@@ -0,0 +1,108 @@
+package yarn_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/sbom"
+	"github.com/paketo-buildpacks/yarn"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testMergeSBOMs(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		scanned   sbom.Formatter
+		installed sbom.Formatter
+	)
+
+	formatter := func(dependency postal.Dependency) sbom.Formatter {
+		bom, err := sbom.GenerateFromDependency(dependency, "/layers/yarn")
+		Expect(err).NotTo(HaveOccurred())
+
+		formatter, err := bom.InFormats(sbom.CycloneDXFormat, sbom.SPDXFormat, sbom.SyftFormat)
+		Expect(err).NotTo(HaveOccurred())
+		return formatter
+	}
+
+	read := func(format packit.SBOMFormat) string {
+		content, err := io.ReadAll(format.Content)
+		Expect(err).NotTo(HaveOccurred())
+		return string(content)
+	}
+
+	it.Before(func() {
+		scanned = formatter(postal.Dependency{
+			Name:    "serve",
+			Version: "14.2.3",
+			PURL:    "pkg:npm/serve@14.2.3",
+		})
+
+		installed = formatter(postal.Dependency{
+			ID:       "yarn",
+			Name:     "Yarn",
+			Version:  "1.22.22",
+			CPEs:     []string{"cpe:2.3:a:yarnpkg:yarn:1.22.22:*:*:*:*:*:*:*"},
+			PURL:     "pkg:generic/yarn@1.22.22",
+			Licenses: []string{"BSD-2-Clause"},
+		})
+	})
+
+	it("adds the packages of the extra SBOM to each format", func() {
+		merged, err := yarn.MergeSBOMs(scanned, installed)
+		Expect(err).NotTo(HaveOccurred())
+
+		formats := merged.Formats()
+		Expect(formats).To(HaveLen(3))
+
+		for _, format := range formats {
+			content := read(format)
+			Expect(content).To(ContainSubstring("pkg:npm/serve@14.2.3"), format.Extension)
+			Expect(content).To(ContainSubstring("pkg:generic/yarn@1.22.22"), format.Extension)
+		}
+	})
+
+	it("can be read more than once", func() {
+		merged, err := yarn.MergeSBOMs(scanned, installed)
+		Expect(err).NotTo(HaveOccurred())
+
+		first := read(merged.Formats()[0])
+		Expect(read(merged.Formats()[0])).To(Equal(first))
+	})
+
+	context("when the base SBOM already describes a package", func() {
+		it("does not add it again", func() {
+			merged, err := yarn.MergeSBOMs(installed, formatter(postal.Dependency{
+				ID:       "yarn",
+				Name:     "Yarn",
+				Version:  "1.22.22",
+				CPEs:     []string{"cpe:2.3:a:yarnpkg:yarn:1.22.22:*:*:*:*:*:*:*"},
+				PURL:     "pkg:generic/yarn@1.22.22",
+				Licenses: []string{"BSD-2-Clause"},
+			}))
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, format := range merged.Formats() {
+				Expect(strings.Count(read(format), `"name":"Yarn"`)).To(Equal(1), format.Extension)
+			}
+		})
+	})
+
+	context("failure cases", func() {
+		context("when a format cannot be merged", func() {
+			it("returns an error", func() {
+				_, err := yarn.MergeSBOMs(
+					packit.SBOMFormats{{Extension: "cdx.json", Content: strings.NewReader("not json")}},
+					packit.SBOMFormats{{Extension: "cdx.json", Content: strings.NewReader("{}")}},
+				)
+				Expect(err).To(MatchError(ContainSubstring("failed to merge cdx.json SBOMs")))
+			})
+		})
+	})
+}
@@ -0,0 +1,149 @@
+package yarn
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/sbom"
+)
+
+// sbomFormatNames maps the short names accepted in BP_SBOM_FORMATS to media
+// types.
+var sbomFormatNames = map[string]string{
+	"cyclonedx": sbom.CycloneDXFormat,
+	"spdx":      sbom.SPDXFormat,
+	"syft":      sbom.SyftFormat,
+}
+
+// SBOMMode selects how the SBOM of the installed Yarn is generated.
+type SBOMMode string
+
+const (
+	// SBOMModeDependency describes the dependency entry in buildpack.toml.
+	SBOMModeDependency SBOMMode = "dependency"
+	// SBOMModeScan describes the files found by scanning the install.
+	SBOMModeScan SBOMMode = "scan"
+	// SBOMModeBoth describes the scanned files together with the dependency
+	// entry.
+	SBOMModeBoth SBOMMode = "both"
+)
+
+// sbomOptions controls how the SBOM is generated, in which formats and where
+// copies of the formatted SBOMs are written.
+type sbomOptions struct {
+	Mode      SBOMMode
+	Formats   []string
+	OutputDir string
+}
+
+// sbomOptionsFromEnv returns the SBOM options for a buildpack that supports
+// the given formats. BP_YARN_SBOM_MODE selects how the SBOM is generated,
+// BP_SBOM_FORMATS selects a subset of the formats, either by media type or by
+// the short names cyclonedx, spdx and syft, and BP_YARN_SBOM_OUTPUT_DIR sets a
+// directory to copy the formatted SBOMs to.
+func sbomOptionsFromEnv(supported []string) (sbomOptions, error) {
+	options := sbomOptions{Mode: SBOMModeDependency, Formats: supported}
+
+	if value := os.Getenv("BP_YARN_SBOM_MODE"); value != "" {
+		options.Mode = SBOMMode(value)
+		switch options.Mode {
+		case SBOMModeDependency, SBOMModeScan, SBOMModeBoth:
+		default:
+			return sbomOptions{}, fmt.Errorf("failed to parse BP_YARN_SBOM_MODE value %s: must be one of dependency, scan or both", value)
+		}
+	}
+
+	if value := os.Getenv("BP_SBOM_FORMATS"); value != "" {
+		options.Formats = nil
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			format, ok := sbomFormatNames[strings.ToLower(name)]
+			if !ok {
+				format = name
+			}
+
+			if !slices.Contains(supported, format) {
+				return sbomOptions{}, fmt.Errorf("failed to parse BP_SBOM_FORMATS value %q: unsupported format %q: must be one of %s", value, name, strings.Join(supportedFormatNames(supported), ", "))
+			}
+
+			if !slices.Contains(options.Formats, format) {
+				options.Formats = append(options.Formats, format)
+			}
+		}
+
+		if len(options.Formats) == 0 {
+			return sbomOptions{}, fmt.Errorf("failed to parse BP_SBOM_FORMATS value %q: must list at least one format", value)
+		}
+	}
+
+	if value := os.Getenv("BP_YARN_SBOM_OUTPUT_DIR"); value != "" {
+		if !filepath.IsAbs(value) {
+			return sbomOptions{}, fmt.Errorf("invalid BP_YARN_SBOM_OUTPUT_DIR %q: must be an absolute path", value)
+		}
+		options.OutputDir = value
+	}
+
+	return options, nil
+}
+
+// writeSBOMCopies writes each format of the SBOM of the dependency to the
+// output directory as <id>-<version>.sbom.<extension>.
+func writeSBOMCopies(formatter packit.SBOMFormatter, dependency postal.Dependency, outputDir string) error {
+	err := os.MkdirAll(outputDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create SBOM output directory: %w", err)
+	}
+
+	for _, format := range formatter.Formats() {
+		path := filepath.Join(outputDir, fmt.Sprintf("%s-%s.sbom.%s", dependency.ID, dependency.Version, format.Extension))
+
+		err = writeSBOMCopy(path, format.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeSBOMCopy(path string, content io.Reader) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write SBOM copy: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = io.Copy(file, content)
+	if err != nil {
+		return fmt.Errorf("failed to write SBOM copy %s: %w", path, err)
+	}
+
+	return file.Close()
+}
+
+// supportedFormatNames lists the supported formats by short name, falling
+// back to the media type for formats without one.
+func supportedFormatNames(supported []string) []string {
+	var names []string
+	for _, format := range supported {
+		name := format
+		for short, mediaType := range sbomFormatNames {
+			if mediaType == format {
+				name = short
+			}
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
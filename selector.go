@@ -0,0 +1,69 @@
+package yarn
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// projectLayerInstallDir is the directory of a per-version layer that Yarn
+// is installed into. It keeps the bin directory of the install off the PATH,
+// where it would shadow the selector.
+const projectLayerInstallDir = "dist"
+
+// projectSelection maps a Yarn project to the bin directory of the Yarn it
+// pins.
+type projectSelection struct {
+	ProjectPath string
+	BinDir      string
+}
+
+// writeSelector writes yarn and yarnpkg scripts into the bin directory of the
+// layer. Each script walks up from the current directory to the nearest
+// configured project and runs the Yarn installed for it, so that every
+// project in the app gets the version it pins.
+func writeSelector(layerPath string, selections []projectSelection) error {
+	binDir := filepath.Join(layerPath, "bin")
+	err := os.MkdirAll(binDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create selector bin directory: %w", err)
+	}
+
+	for _, name := range []string{"yarn", "yarnpkg"} {
+		err = os.WriteFile(filepath.Join(binDir, name), []byte(selectorScript(name, selections)), 0755)
+		if err != nil {
+			return fmt.Errorf("failed to write %s selector: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func selectorScript(name string, selections []projectSelection) string {
+	var script strings.Builder
+	projects := []string{}
+
+	script.WriteString("#!/bin/sh\n")
+	script.WriteString("dir=\"$(pwd)\"\n")
+	script.WriteString("while :; do\n")
+	script.WriteString("\tcase \"$dir\" in\n")
+	for _, selection := range selections {
+		fmt.Fprintf(&script, "\t%s) exec %s \"$@\" ;;\n", shellQuote(selection.ProjectPath), shellQuote(filepath.Join(selection.BinDir, name)))
+		projects = append(projects, shellQuote(selection.ProjectPath))
+	}
+	script.WriteString("\tesac\n")
+	script.WriteString("\tif [ \"$dir\" = / ]; then\n")
+	script.WriteString("\t\tbreak\n")
+	script.WriteString("\tfi\n")
+	script.WriteString("\tdir=\"$(dirname \"$dir\")\"\n")
+	script.WriteString("done\n")
+	fmt.Fprintf(&script, "echo \"%s: $(pwd) is not inside a Yarn project; configured projects:\" %s >&2\n", name, strings.Join(projects, " "))
+	script.WriteString("exit 1\n")
+
+	return script.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
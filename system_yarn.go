@@ -0,0 +1,118 @@
+package yarn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+	"github.com/paketo-buildpacks/packit/v2/postal"
+)
+
+// systemYarnConstraint returns the version constraint that a system-installed
+// Yarn must satisfy. An explicit version from the build plan is used as is;
+// otherwise the constraint only requires the expected flavor of Yarn.
+func systemYarnConstraint(dependencyID, version string) string {
+	if version != "" && version != "default" {
+		return version
+	}
+
+	if dependencyID == BerryDependency {
+		return ">=2.0.0"
+	}
+
+	return "1.*"
+}
+
+// findSystemYarn looks up the yarn already installed on the PATH, checks that
+// the version it reports satisfies the constraint and describes it as a
+// dependency so that it can be recorded in the BOM and SBOM. The version is
+// read from an empty temporary directory so that the settings of the app
+// cannot hand the command over to another release of Yarn.
+func findSystemYarn(yarn Executable, dependencyID, constraint string) (postal.Dependency, string, error) {
+	path, err := exec.LookPath("yarn")
+	if err != nil {
+		return postal.Dependency{}, "", fmt.Errorf("BP_YARN_USE_SYSTEM is set but no yarn was found on the PATH: %w", err)
+	}
+
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return postal.Dependency{}, "", fmt.Errorf("failed to resolve system yarn: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "yarn-version")
+	if err != nil {
+		return postal.Dependency{}, "", fmt.Errorf("failed to create directory to run system yarn: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	buffer := bytes.NewBuffer(nil)
+	err = yarn.Execute(pexec.Execution{
+		Args:   []string{"--version"},
+		Dir:    dir,
+		Stdout: buffer,
+		Stderr: buffer,
+	})
+	if err != nil {
+		return postal.Dependency{}, "", fmt.Errorf("failed to run system yarn: %w\n%s", err, buffer.String())
+	}
+
+	version := strings.TrimSpace(buffer.String())
+	installed, err := semver.NewVersion(version)
+	if err != nil {
+		return postal.Dependency{}, "", fmt.Errorf("failed to parse system yarn version %q: %w", version, err)
+	}
+
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return postal.Dependency{}, "", fmt.Errorf("failed to parse yarn version constraint %q: %w", constraint, err)
+	}
+
+	if !c.Check(installed) {
+		return postal.Dependency{}, "", fmt.Errorf("system yarn %s at %s does not satisfy the version constraint %s", version, path, constraint)
+	}
+
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return postal.Dependency{}, "", err
+	}
+
+	name := "Yarn"
+	if dependencyID == BerryDependency {
+		name = "Yarn Berry"
+	}
+
+	return postal.Dependency{
+		ID:       dependencyID,
+		Name:     name,
+		Version:  version,
+		Checksum: fmt.Sprintf("sha256:%s", checksum),
+		CPE:      fmt.Sprintf("cpe:2.3:a:yarnpkg:yarn:%s:*:*:*:*:*:*:*", version),
+		PURL:     fmt.Sprintf("pkg:generic/%s@%s?checksum=%s", dependencyID, version, checksum),
+		URI:      fmt.Sprintf("file://%s", path),
+		Source:   fmt.Sprintf("file://%s", path),
+	}, path, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open system yarn: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum system yarn: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,163 @@
+package yarn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	tracerName = "github.com/paketo-buildpacks/yarn"
+
+	// tracesShutdownTimeout bounds how long the build waits for the spans to
+	// be exported once it is done.
+	tracesShutdownTimeout = 10 * time.Second
+)
+
+// phaseTracer starts the spans of the build phases. Each tracer carries the span
+// that the spans it starts are children of.
+type phaseTracer struct {
+	tracer trace.Tracer
+	ctx    context.Context
+}
+
+// start starts a span for the named phase and returns a tracer for the
+// phases nested within it.
+func (t phaseTracer) start(name string, attributes ...attribute.KeyValue) (phaseTracer, trace.Span) {
+	ctx, span := t.tracer.Start(t.ctx, name, trace.WithAttributes(attributes...))
+	return phaseTracer{tracer: t.tracer, ctx: ctx}, span
+}
+
+// endSpan ends the span, marking it as failed when err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func dependencyAttributes(dependency postal.Dependency) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("yarn.dependency.id", dependency.ID),
+		attribute.String("yarn.version", dependency.Version),
+	}
+}
+
+// noopTracing returns a tracer that records nothing and a shutdown function
+// that does nothing.
+func noopTracing() (phaseTracer, func() error) {
+	return phaseTracer{tracer: noop.NewTracerProvider().Tracer(tracerName), ctx: context.Background()}, func() error { return nil }
+}
+
+// newTracing sets up tracing of the build phases from the environment. Spans
+// are written as JSON to the file in BP_YARN_TRACES_FILE and exported over
+// OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set; the other standard OTEL_*
+// variables configure the exporter, sampler and resource as usual.
+// OTEL_SDK_DISABLED=true or OTEL_TRACES_EXPORTER=none turn tracing off. The
+// root span continues the trace in TRACEPARENT, if any. The returned function
+// flushes the spans and must be called once the build is done. When the
+// environment asks for tracing that cannot be set up, it returns an error
+// together with a tracer that records nothing, so that the build can go on
+// without traces.
+func newTracing() (phaseTracer, func() error, error) {
+	disabled, shutdown := noopTracing()
+
+	sdkDisabled, err := lookupBoolEnv("OTEL_SDK_DISABLED")
+	if err != nil {
+		return disabled, shutdown, err
+	}
+
+	if sdkDisabled || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return disabled, shutdown, nil
+	}
+
+	otlpEnabled := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+	if otlpEnabled {
+		protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+		if protocol == "" {
+			protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+		}
+
+		if protocol != "" && protocol != "http/protobuf" {
+			return disabled, shutdown, fmt.Errorf("unsupported OTLP protocol %s: only http/protobuf is supported", protocol)
+		}
+	}
+
+	var (
+		options []sdktrace.TracerProviderOption
+		closers []io.Closer
+	)
+
+	if path := os.Getenv("BP_YARN_TRACES_FILE"); path != "" {
+		err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			return disabled, shutdown, fmt.Errorf("failed to create traces file: %w", err)
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			return disabled, shutdown, fmt.Errorf("failed to create traces file: %w", err)
+		}
+		closers = append(closers, file)
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			_ = file.Close()
+			return disabled, shutdown, fmt.Errorf("failed to create traces file exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithSyncer(exporter))
+	}
+
+	if otlpEnabled {
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			for _, closer := range closers {
+				_ = closer.Close()
+			}
+			return disabled, shutdown, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	if len(options) == 0 {
+		return disabled, shutdown, nil
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{
+		"traceparent": os.Getenv("TRACEPARENT"),
+		"tracestate":  os.Getenv("TRACESTATE"),
+	})
+
+	shutdown = func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), tracesShutdownTimeout)
+		defer cancel()
+
+		// Shutdown reports export failures to the global error handler only,
+		// so the spans are flushed first to learn whether they were exported.
+		err := errors.Join(provider.ForceFlush(ctx), provider.Shutdown(ctx))
+		for _, closer := range closers {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}
+
+	return phaseTracer{tracer: provider.Tracer(tracerName), ctx: ctx}, shutdown, nil
+}
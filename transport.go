@@ -0,0 +1,55 @@
+package yarn
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/cargo"
+)
+
+// Transport fetches dependencies for postal.Service. It behaves like
+// cargo.Transport, but bounds each download by the timeout configured with
+// BP_YARN_DOWNLOAD_TIMEOUT so that a stalled attempt fails and can be
+// retried.
+type Transport struct {
+	local cargo.Transport
+}
+
+func NewTransport() Transport {
+	return Transport{
+		local: cargo.NewTransport(),
+	}
+}
+
+func (t Transport) Drop(root, uri string) (io.ReadCloser, error) {
+	if strings.HasPrefix(uri, "file://") {
+		return t.local.Drop(root, uri)
+	}
+
+	policy, err := deliveryPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse request uri: %s", err)
+	}
+
+	// The client timeout also covers reading the response body, which is
+	// where most of the time is spent while the archive is extracted.
+	client := &http.Client{Timeout: policy.Timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %s", err)
+	}
+
+	if response.StatusCode >= 400 {
+		_ = response.Body.Close()
+		return nil, fmt.Errorf("unexpected status code %d while fetching %q", response.StatusCode, uri)
+	}
+
+	return response.Body, nil
+}
@@ -0,0 +1,106 @@
+package yarn_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/paketo-buildpacks/yarn"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testTransport(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		server    *httptest.Server
+		transport yarn.Transport
+	)
+
+	it.Before(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/slow":
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				time.Sleep(500 * time.Millisecond)
+				_, _ = w.Write([]byte("too-late"))
+			case "/missing":
+				w.WriteHeader(http.StatusNotFound)
+			default:
+				_, _ = w.Write([]byte("some-content"))
+			}
+		}))
+
+		transport = yarn.NewTransport()
+	})
+
+	it.After(func() {
+		server.Close()
+		Expect(os.Unsetenv("BP_YARN_DOWNLOAD_TIMEOUT")).To(Succeed())
+	})
+
+	it("downloads the dependency", func() {
+		bundle, err := transport.Drop("", server.URL+"/some-dependency.tgz")
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { Expect(bundle.Close()).To(Succeed()) }()
+
+		content, err := io.ReadAll(bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("some-content"))
+	})
+
+	it("reads file URIs relative to the given root", func() {
+		root := t.TempDir()
+		Expect(os.WriteFile(filepath.Join(root, "some-dependency.tgz"), []byte("local-content"), 0644)).To(Succeed())
+
+		bundle, err := transport.Drop(root, "file:///some-dependency.tgz")
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { Expect(bundle.Close()).To(Succeed()) }()
+
+		content, err := io.ReadAll(bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("local-content"))
+	})
+
+	context("when the download takes longer than BP_YARN_DOWNLOAD_TIMEOUT", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_YARN_DOWNLOAD_TIMEOUT", "100ms")).To(Succeed())
+		})
+
+		it("fails while reading the response", func() {
+			bundle, err := transport.Drop("", server.URL+"/slow")
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { _ = bundle.Close() }()
+
+			_, err = io.ReadAll(bundle)
+			Expect(err).To(MatchError(ContainSubstring("Client.Timeout")))
+		})
+	})
+
+	context("failure cases", func() {
+		context("when the server responds with an error status", func() {
+			it("returns an error", func() {
+				_, err := transport.Drop("", server.URL+"/missing")
+				Expect(err).To(MatchError(ContainSubstring("unexpected status code 404")))
+			})
+		})
+
+		context("when BP_YARN_DOWNLOAD_TIMEOUT is invalid", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_DOWNLOAD_TIMEOUT", "forever")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := transport.Drop("", server.URL)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_YARN_DOWNLOAD_TIMEOUT")))
+			})
+		})
+	})
+}
@@ -0,0 +1,73 @@
+package yarn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+)
+
+// errNodeNotFound is returned by checkInstalledVersion when node is not
+// available to run the installed Yarn.
+var errNodeNotFound = errors.New("node is not available on the PATH")
+
+// checkInstalledVersion runs `yarn --version` from the bin directory of the
+// given layer and checks that it reports the expected version. It runs in the
+// layer rather than in the app, where a yarnPath, yarn-path or packageManager
+// setting could hand the command over to another release of Yarn. Both the
+// classic and Berry entrypoints need node to run, so the check is skipped when
+// node is not on the PATH.
+func checkInstalledVersion(node, yarn Executable, layerPath, version string) error {
+	err := node.Execute(pexec.Execution{
+		Args:   []string{"--version"},
+		Stdout: &bytes.Buffer{},
+		Stderr: &bytes.Buffer{},
+	})
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return errNodeNotFound
+		}
+		return fmt.Errorf("failed to run node: %w", err)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err = yarn.Execute(pexec.Execution{
+		Args:   []string{"--version"},
+		Dir:    layerPath,
+		Env:    prependPath(os.Environ(), filepath.Join(layerPath, "bin")),
+		Stdout: buffer,
+		Stderr: buffer,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to run installed yarn: %w\n%s", err, buffer.String())
+	}
+
+	output := strings.TrimSpace(buffer.String())
+	if output != version {
+		return fmt.Errorf("installed yarn reported version %q, expected %q:\n%s", output, version, buffer.String())
+	}
+
+	return nil
+}
+
+// prependPath returns a copy of env with dir added to the front of PATH.
+func prependPath(env []string, dir string) []string {
+	var result []string
+	path := dir
+	for _, variable := range env {
+		if value, ok := strings.CutPrefix(variable, "PATH="); ok {
+			if value != "" {
+				path = strings.Join([]string{dir, value}, string(os.PathListSeparator))
+			}
+			continue
+		}
+		result = append(result, variable)
+	}
+
+	return append(result, fmt.Sprintf("PATH=%s", path))
+}
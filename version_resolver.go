@@ -0,0 +1,444 @@
+package yarn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/draft"
+	"github.com/paketo-buildpacks/yarn/packagemanager"
+)
+
+// The priorities of the default version sources. When several sources ask
+// for a version, the one with the highest priority decides.
+const (
+	PackageManagerPriority = 50
+	DevEnginesPriority     = 45
+	EnvironmentPriority    = 40
+	PlanPriority           = 30
+	EnginesPriority        = 20
+	YarnrcPriority         = 10
+	LockfilePriority       = 10
+)
+
+// VersionConstraint is the version of Yarn that a VersionSource asks for.
+type VersionConstraint struct {
+	// Source names the source, for example packageManager.
+	Source string
+
+	// Constraint is a semver constraint such as 4.14.1, 1.22.* or >=2.0.0.
+	Constraint string
+
+	// Priority orders the constraints of the sources.
+	Priority int
+
+	// Pin is set when the project pins an exact version. Pins only decide
+	// the version when the resolver is asked to honor them.
+	Pin bool
+
+	// FlavorOnly is set when the source can only tell Yarn Classic from Yarn
+	// Berry. Its constraint selects the flavor but never the version.
+	FlavorOnly bool
+
+	// Description explains where the constraint comes from.
+	Description string
+}
+
+// VersionSource is a place that can ask for a version of Yarn for a project.
+// It returns false when it does not ask for one.
+type VersionSource interface {
+	Constraint(project Project, entries []packit.BuildpackPlanEntry) (VersionConstraint, bool, error)
+}
+
+// VersionResolution is the Yarn dependency and version constraint chosen for
+// a project, with the constraints of the sources by priority and the trail of
+// decisions that led to them.
+type VersionResolution struct {
+	DependencyID string
+	Constraint   string
+	Constraints  []VersionConstraint
+	Trail        []string
+
+	// Warnings are the errors of the sources that were skipped because the
+	// package.json of the project could not be read, such as a malformed
+	// packageManager. Each is a ManifestError.
+	Warnings []error
+}
+
+// Source names the source whose constraint decided the resolution, or
+// "default" when no source asked for a version.
+func (r VersionResolution) Source() string {
+	if len(r.Constraints) == 0 {
+		return "default"
+	}
+
+	return r.Constraints[0].Source
+}
+
+// SourceResolver resolves the version of Yarn from a list of version sources.
+type SourceResolver struct {
+	sources []VersionSource
+}
+
+// NewVersionResolver returns a resolver that consults the given sources. Of
+// sources with the same priority, the earlier one decides.
+func NewVersionResolver(sources ...VersionSource) SourceResolver {
+	return SourceResolver{sources: sources}
+}
+
+// DefaultVersionSources returns the sources consulted by the buildpack: the
+// packageManager and devEngines.packageManager of package.json,
+// BP_YARN_VERSION, the build plan, the engines.yarn range of package.json
+// and, when BP_YARN_DETECT_FLAVOR is true, .yarnrc.yml and yarn.lock.
+func DefaultVersionSources() []VersionSource {
+	return []VersionSource{
+		PackageManagerVersionSource{},
+		DevEnginesVersionSource{},
+		EnvironmentVersionSource{},
+		PlanVersionSource{},
+		EnginesVersionSource{},
+		YarnrcVersionSource{},
+		LockfileVersionSource{},
+	}
+}
+
+// Resolve returns the dependency and version constraint for the project. The
+// constraint with the highest priority selects Yarn Berry when its major
+// version is 2 or more, and Yarn Classic otherwise. Unless pins are honored,
+// an explicit version that asks for Yarn Berry selects it ahead of the pins
+// above it, as the build did before the resolver existed. When pins are honored,
+// as for the projects of BP_YARN_PROJECT_PATHS, the version is that of the
+// highest-priority constraint that is not FlavorOnly. Otherwise pins only
+// select the flavor: Yarn Berry is resolved with its default version in
+// buildpack.toml, and Yarn Classic with the highest-priority constraint for
+// Yarn Classic that is neither a pin nor FlavorOnly.
+//
+// The package.json of the project is read once for all the sources. A source
+// that fails with a ManifestError is skipped and the error is recorded in the
+// Warnings of the resolution, so that a package.json the buildpack cannot
+// read does not fail the build.
+func (r SourceResolver) Resolve(project Project, entries []packit.BuildpackPlanEntry, pins bool) (VersionResolution, error) {
+	project.manifest = &cachedManifest{}
+
+	var resolution VersionResolution
+	for _, source := range r.sources {
+		constraint, ok, err := source.Constraint(project, entries)
+
+		var manifestError ManifestError
+		if errors.As(err, &manifestError) {
+			if !slices.ContainsFunc(resolution.Warnings, func(warning error) bool { return warning.Error() == err.Error() }) {
+				resolution.Warnings = append(resolution.Warnings, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			return VersionResolution{}, err
+		}
+
+		if ok {
+			resolution.Constraints = append(resolution.Constraints, constraint)
+		}
+	}
+
+	sort.SliceStable(resolution.Constraints, func(i, j int) bool {
+		return resolution.Constraints[i].Priority > resolution.Constraints[j].Priority
+	})
+
+	for _, warning := range resolution.Warnings {
+		resolution.Trail = append(resolution.Trail, fmt.Sprintf("Skipped the sources that read package.json: %s", warning))
+	}
+
+	for _, constraint := range resolution.Constraints {
+		resolution.Trail = append(resolution.Trail, constraint.Description)
+	}
+
+	resolution.DependencyID, resolution.Constraint = YarnDependency, "default"
+	if len(resolution.Constraints) == 0 {
+		resolution.Trail = append(resolution.Trail, "No source requires a version of Yarn, so Yarn Classic is used")
+		return resolution, nil
+	}
+
+	decisive := resolution.Constraints[0]
+	flavor := "Yarn Classic"
+	if berryConstraint(decisive.Constraint) {
+		resolution.DependencyID, flavor = BerryDependency, "Yarn Berry"
+	}
+
+	if explicit, ok := explicitBerryConstraint(resolution.Constraints); ok && !pins && decisive.Pin && resolution.DependencyID == YarnDependency {
+		resolution.DependencyID, flavor = BerryDependency, "Yarn Berry"
+		resolution.Trail = append(resolution.Trail, fmt.Sprintf("The %s asks for Yarn Berry, which takes precedence over the %s", explicit.Source, decisive.Source))
+	} else {
+		resolution.Trail = append(resolution.Trail, fmt.Sprintf("The %s has the highest priority and selects %s", decisive.Source, flavor))
+	}
+
+	if !pins && resolution.DependencyID == BerryDependency {
+		resolution.Trail = append(resolution.Trail, "Yarn Berry is resolved with its default version in buildpack.toml")
+		return resolution, nil
+	}
+
+	for _, constraint := range resolution.Constraints {
+		if constraint.FlavorOnly || (!pins && (constraint.Pin || berryConstraint(constraint.Constraint))) {
+			continue
+		}
+
+		resolution.Constraint = constraint.Constraint
+		resolution.Trail = append(resolution.Trail, fmt.Sprintf("%s is resolved with the constraint %s of the %s", flavor, constraint.Constraint, constraint.Source))
+		return resolution, nil
+	}
+
+	resolution.Trail = append(resolution.Trail, fmt.Sprintf("%s is resolved with its default version in buildpack.toml", flavor))
+	return resolution, nil
+}
+
+// explicitBerryConstraint returns the first constraint below the pins when it
+// is an explicit version of Yarn Berry from BP_YARN_VERSION or the build plan.
+// The engines.yarn range only states the versions a project works with, so it
+// never overrides a pin.
+func explicitBerryConstraint(constraints []VersionConstraint) (VersionConstraint, bool) {
+	for _, constraint := range constraints {
+		if constraint.Pin {
+			continue
+		}
+
+		return constraint, constraint.Priority >= PlanPriority && !constraint.FlavorOnly && berryConstraint(constraint.Constraint)
+	}
+
+	return VersionConstraint{}, false
+}
+
+// berryConstraint reports whether the major version of the constraint, after
+// any leading operators, is 2 or more.
+func berryConstraint(constraint string) bool {
+	constraint = strings.TrimLeft(constraint, "^~<>=v ")
+	major, err := strconv.Atoi(strings.SplitN(constraint, ".", 2)[0])
+	return err == nil && major >= 2
+}
+
+// PackageManagerVersionSource pins the version in the packageManager field of
+// package.json, for example yarn@4.14.1, without any corepack hash suffix.
+type PackageManagerVersionSource struct{}
+
+func (PackageManagerVersionSource) Constraint(project Project, _ []packit.BuildpackPlanEntry) (VersionConstraint, bool, error) {
+	manifest, ok, err := readManifest(project)
+	if err != nil || !ok {
+		return VersionConstraint{}, false, err
+	}
+
+	spec := manifest.PackageManager
+	if spec == nil || spec.Name != "yarn" || spec.Constraint() == "" {
+		return VersionConstraint{}, false, nil
+	}
+
+	return VersionConstraint{
+		Source:      "packageManager",
+		Constraint:  spec.Constraint(),
+		Priority:    PackageManagerPriority,
+		Pin:         spec.Version != "",
+		Description: fmt.Sprintf("The packageManager %s in %s pins Yarn %s", spec, project.PackageJSON, spec.Constraint()),
+	}, true, nil
+}
+
+// DevEnginesVersionSource asks for the range of the yarn entry of
+// devEngines.packageManager in package.json.
+type DevEnginesVersionSource struct{}
+
+func (DevEnginesVersionSource) Constraint(project Project, _ []packit.BuildpackPlanEntry) (VersionConstraint, bool, error) {
+	manifest, ok, err := readManifest(project)
+	if err != nil || !ok {
+		return VersionConstraint{}, false, err
+	}
+
+	engine, ok := manifest.DevEngine("yarn")
+	if !ok || engine.Version == "" {
+		return VersionConstraint{}, false, nil
+	}
+
+	return VersionConstraint{
+		Source:      "devEngines.packageManager",
+		Constraint:  engine.Version,
+		Priority:    DevEnginesPriority,
+		Description: fmt.Sprintf("The devEngines.packageManager of %s requires Yarn %s", project.PackageJSON, engine.Version),
+	}, true, nil
+}
+
+// EnvironmentVersionSource asks for the version in BP_YARN_VERSION.
+type EnvironmentVersionSource struct{}
+
+func (EnvironmentVersionSource) Constraint(Project, []packit.BuildpackPlanEntry) (VersionConstraint, bool, error) {
+	version := os.Getenv("BP_YARN_VERSION")
+	if version == "" {
+		return VersionConstraint{}, false, nil
+	}
+
+	return VersionConstraint{
+		Source:      "BP_YARN_VERSION",
+		Constraint:  version,
+		Priority:    EnvironmentPriority,
+		Description: fmt.Sprintf("BP_YARN_VERSION requires Yarn %s", version),
+	}, true, nil
+}
+
+// PlanVersionSource asks for the version of the merged yarn entry of the
+// build plan.
+type PlanVersionSource struct{}
+
+func (PlanVersionSource) Constraint(_ Project, entries []packit.BuildpackPlanEntry) (VersionConstraint, bool, error) {
+	entry, _ := draft.NewPlanner().Resolve("yarn", entries, nil)
+	version, _ := entry.Metadata["version"].(string)
+	if version == "" || version == "default" {
+		return VersionConstraint{}, false, nil
+	}
+
+	description := fmt.Sprintf("The build plan requires Yarn %s", version)
+	if source, ok := entry.Metadata["version-source"].(string); ok && source != "" {
+		description = fmt.Sprintf("%s, from %s", description, source)
+	}
+
+	return VersionConstraint{
+		Source:      "build plan",
+		Constraint:  version,
+		Priority:    PlanPriority,
+		Description: description,
+	}, true, nil
+}
+
+// EnginesVersionSource asks for the engines.yarn range of package.json.
+type EnginesVersionSource struct{}
+
+func (EnginesVersionSource) Constraint(project Project, _ []packit.BuildpackPlanEntry) (VersionConstraint, bool, error) {
+	manifest, ok, err := readManifest(project)
+	if err != nil || !ok {
+		return VersionConstraint{}, false, err
+	}
+
+	version, ok, err := manifest.Engine("yarn")
+	if err != nil {
+		return VersionConstraint{}, false, ManifestError{Path: project.PackageJSON, Err: err}
+	}
+
+	if !ok {
+		return VersionConstraint{}, false, nil
+	}
+
+	return VersionConstraint{
+		Source:      "engines.yarn",
+		Constraint:  version,
+		Priority:    EnginesPriority,
+		Description: fmt.Sprintf("The engines.yarn range in %s requires Yarn %s", project.PackageJSON, version),
+	}, true, nil
+}
+
+// YarnrcVersionSource asks for Yarn Berry when the project has a .yarnrc.yml,
+// which only Yarn Berry reads. It only does so when BP_YARN_DETECT_FLAVOR is
+// true.
+type YarnrcVersionSource struct{}
+
+func (YarnrcVersionSource) Constraint(project Project, _ []packit.BuildpackPlanEntry) (VersionConstraint, bool, error) {
+	detect, err := lookupBoolEnv("BP_YARN_DETECT_FLAVOR")
+	if err != nil || !detect || project.YarnrcYML == "" {
+		return VersionConstraint{}, false, err
+	}
+
+	return VersionConstraint{
+		Source:      ".yarnrc.yml",
+		Constraint:  ">=2.0.0",
+		Priority:    YarnrcPriority,
+		Description: fmt.Sprintf("%s is only read by Yarn Berry", project.YarnrcYML),
+	}, true, nil
+}
+
+// LockfileVersionSource asks for the flavor of Yarn that wrote yarn.lock:
+// Yarn Berry writes a __metadata entry and Yarn Classic a v1 header. It only
+// does so when BP_YARN_DETECT_FLAVOR is true.
+type LockfileVersionSource struct{}
+
+func (LockfileVersionSource) Constraint(project Project, _ []packit.BuildpackPlanEntry) (VersionConstraint, bool, error) {
+	detect, err := lookupBoolEnv("BP_YARN_DETECT_FLAVOR")
+	if err != nil || !detect || project.Lockfile == "" {
+		return VersionConstraint{}, false, err
+	}
+
+	content, err := os.ReadFile(project.Lockfile)
+	if err != nil {
+		return VersionConstraint{}, false, fmt.Errorf("failed to read lockfile: %w", err)
+	}
+
+	switch {
+	case bytes.Contains(content, []byte("\n__metadata:")):
+		return VersionConstraint{
+			Source:      "yarn.lock",
+			Constraint:  ">=2.0.0",
+			Priority:    LockfilePriority,
+			FlavorOnly:  true,
+			Description: fmt.Sprintf("%s was written by Yarn Berry", project.Lockfile),
+		}, true, nil
+
+	case bytes.Contains(content, []byte("# yarn lockfile v1")):
+		return VersionConstraint{
+			Source:      "yarn.lock",
+			Constraint:  "1.*",
+			Priority:    LockfilePriority,
+			FlavorOnly:  true,
+			Description: fmt.Sprintf("%s was written by Yarn Classic", project.Lockfile),
+		}, true, nil
+	}
+
+	return VersionConstraint{}, false, nil
+}
+
+// ManifestError is returned by a version source when the package.json of the
+// project cannot be read or holds a malformed package manager declaration.
+// Err is the error of the packagemanager package, such as a
+// packagemanager.ParseError.
+type ManifestError struct {
+	Path string
+	Err  error
+}
+
+func (e ManifestError) Error() string {
+	return fmt.Sprintf("failed to read package manager declarations: %s", e.Err)
+}
+
+func (e ManifestError) Unwrap() error {
+	return e.Err
+}
+
+// cachedManifest holds the package manager declarations of a package.json
+// once they have been read, so that the sources of a resolve share them.
+type cachedManifest struct {
+	once     sync.Once
+	manifest packagemanager.Manifest
+	err      error
+}
+
+// readManifest reads the package manager declarations of the package.json of
+// the project, at most once per resolve. It returns false when the project
+// has no package.json.
+func readManifest(project Project) (packagemanager.Manifest, bool, error) {
+	if project.PackageJSON == "" {
+		return packagemanager.Manifest{}, false, nil
+	}
+
+	cache := project.manifest
+	if cache == nil {
+		cache = &cachedManifest{}
+	}
+
+	cache.once.Do(func() {
+		cache.manifest, cache.err = packagemanager.Read(project.PackageJSON)
+	})
+
+	if cache.err != nil {
+		return packagemanager.Manifest{}, false, ManifestError{Path: project.PackageJSON, Err: cache.err}
+	}
+
+	return cache.manifest, true, nil
+}
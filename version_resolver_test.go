@@ -0,0 +1,517 @@
+package yarn_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/yarn"
+	"github.com/paketo-buildpacks/yarn/packagemanager"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+type stubVersionSource struct {
+	constraint yarn.VersionConstraint
+	ok         bool
+	err        error
+}
+
+func (s stubVersionSource) Constraint(yarn.Project, []packit.BuildpackPlanEntry) (yarn.VersionConstraint, bool, error) {
+	return s.constraint, s.ok, s.err
+}
+
+// rewritingVersionSource rewrites the file at path when it is consulted, to
+// show whether later sources read the file again.
+type rewritingVersionSource struct {
+	path    string
+	content string
+}
+
+func (s rewritingVersionSource) Constraint(yarn.Project, []packit.BuildpackPlanEntry) (yarn.VersionConstraint, bool, error) {
+	return yarn.VersionConstraint{}, false, os.WriteFile(s.path, []byte(s.content), 0600)
+}
+
+func testVersionResolver(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		workingDir string
+		project    yarn.Project
+		entries    []packit.BuildpackPlanEntry
+		resolver   yarn.SourceResolver
+	)
+
+	it.Before(func() {
+		var err error
+		workingDir, err = os.MkdirTemp("", "working-dir")
+		Expect(err).NotTo(HaveOccurred())
+
+		project = yarn.Project{
+			Path:        workingDir,
+			PackageJSON: filepath.Join(workingDir, "package.json"),
+		}
+		Expect(os.WriteFile(project.PackageJSON, []byte(`{}`), 0600)).To(Succeed())
+
+		entries = []packit.BuildpackPlanEntry{{Name: "yarn"}}
+		resolver = yarn.NewVersionResolver(yarn.DefaultVersionSources()...)
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(workingDir)).To(Succeed())
+	})
+
+	it("resolves the default version of Yarn Classic when no source requires a version", func() {
+		resolution, err := resolver.Resolve(project, entries, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(resolution).To(Equal(yarn.VersionResolution{
+			DependencyID: "yarn",
+			Constraint:   "default",
+			Trail:        []string{"No source requires a version of Yarn, so Yarn Classic is used"},
+		}))
+	})
+
+	context("when package.json pins a version with packageManager", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(project.PackageJSON, []byte(`{"packageManager":"yarn@4.17.1+sha512.abcdef"}`), 0600)).To(Succeed())
+		})
+
+		it("selects the flavor but resolves the default version", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("berry"))
+			Expect(resolution.Constraint).To(Equal("default"))
+			Expect(resolution.Constraints).To(Equal([]yarn.VersionConstraint{
+				{
+					Source:      "packageManager",
+					Constraint:  "4.17.1",
+					Priority:    yarn.PackageManagerPriority,
+					Pin:         true,
+					Description: "The packageManager yarn@4.17.1+sha512.abcdef in " + project.PackageJSON + " pins Yarn 4.17.1",
+				},
+			}))
+			Expect(resolution.Trail).To(Equal([]string{
+				"The packageManager yarn@4.17.1+sha512.abcdef in " + project.PackageJSON + " pins Yarn 4.17.1",
+				"The packageManager has the highest priority and selects Yarn Berry",
+				"Yarn Berry is resolved with its default version in buildpack.toml",
+			}))
+		})
+
+		context("when pins are honored", func() {
+			it("resolves the pinned version", func() {
+				resolution, err := resolver.Resolve(project, entries, true)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resolution.DependencyID).To(Equal("berry"))
+				Expect(resolution.Constraint).To(Equal("4.17.1"))
+			})
+		})
+
+		context("when the build plan requires a version of Yarn Classic", func() {
+			it.Before(func() {
+				entries[0].Metadata = map[string]interface{}{"version": "1.22.*"}
+			})
+
+			it("lets the pin select the flavor", func() {
+				resolution, err := resolver.Resolve(project, entries, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resolution.DependencyID).To(Equal("berry"))
+				Expect(resolution.Constraint).To(Equal("default"))
+			})
+		})
+	})
+
+	context("when the packageManager pins Yarn Classic and the build plan requires a version", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(project.PackageJSON, []byte(`{"packageManager":"yarn@1.22.19"}`), 0600)).To(Succeed())
+			entries[0].Metadata = map[string]interface{}{"version": "1.22.*", "version-source": "package.json"}
+		})
+
+		it("resolves the version of the build plan", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("yarn"))
+			Expect(resolution.Constraint).To(Equal("1.22.*"))
+			Expect(resolution.Trail).To(ContainElements(
+				"The build plan requires Yarn 1.22.*, from package.json",
+				"Yarn Classic is resolved with the constraint 1.22.* of the build plan",
+			))
+		})
+	})
+
+	context("when the build plan requires a version of Yarn Berry and the packageManager pins Yarn Classic", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(project.PackageJSON, []byte(`{"packageManager":"yarn@1.22.19"}`), 0600)).To(Succeed())
+			entries[0].Metadata = map[string]interface{}{"version": "4.*"}
+		})
+
+		it("selects Yarn Berry, as the explicit version comes ahead of the pin", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("berry"))
+			Expect(resolution.Constraint).To(Equal("default"))
+			Expect(resolution.Trail).To(Equal([]string{
+				"The packageManager yarn@1.22.19 in " + project.PackageJSON + " pins Yarn 1.22.19",
+				"The build plan requires Yarn 4.*",
+				"The build plan asks for Yarn Berry, which takes precedence over the packageManager",
+				"Yarn Berry is resolved with its default version in buildpack.toml",
+			}))
+		})
+
+		context("when pins are honored", func() {
+			it("resolves the pinned version of Yarn Classic", func() {
+				resolution, err := resolver.Resolve(project, entries, true)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resolution.DependencyID).To(Equal("yarn"))
+				Expect(resolution.Constraint).To(Equal("1.22.19"))
+			})
+		})
+	})
+
+	context("when devEngines.packageManager declares Yarn", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(project.PackageJSON, []byte(`{
+				"devEngines": {"packageManager": [{"name": "pnpm"}, {"name": "yarn", "version": "1.22.x", "onFail": "error"}]}
+			}`), 0600)).To(Succeed())
+			Expect(os.Setenv("BP_YARN_VERSION", "1.*")).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_VERSION")).To(Succeed())
+		})
+
+		it("takes precedence over BP_YARN_VERSION", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("yarn"))
+			Expect(resolution.Constraint).To(Equal("1.22.x"))
+			Expect(resolution.Constraints[0]).To(Equal(yarn.VersionConstraint{
+				Source:      "devEngines.packageManager",
+				Constraint:  "1.22.x",
+				Priority:    yarn.DevEnginesPriority,
+				Description: "The devEngines.packageManager of " + project.PackageJSON + " requires Yarn 1.22.x",
+			}))
+		})
+	})
+
+	context("when the packageManager is not Yarn", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(project.PackageJSON, []byte(`{"packageManager":"pnpm@9.1.0"}`), 0600)).To(Succeed())
+		})
+
+		it("ignores it", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.Constraints).To(BeEmpty())
+		})
+	})
+
+	context("when BP_YARN_VERSION is set", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_YARN_VERSION", "1.22.19")).To(Succeed())
+			entries[0].Metadata = map[string]interface{}{"version": "1.*"}
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_VERSION")).To(Succeed())
+		})
+
+		it("takes precedence over the build plan", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("yarn"))
+			Expect(resolution.Constraint).To(Equal("1.22.19"))
+			Expect(resolution.Trail[0]).To(Equal("BP_YARN_VERSION requires Yarn 1.22.19"))
+		})
+	})
+
+	context("when package.json declares engines.yarn", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(project.PackageJSON, []byte(`{"engines":{"node":">=18","yarn":"^1.22.0"}}`), 0600)).To(Succeed())
+		})
+
+		it("resolves its range", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("yarn"))
+			Expect(resolution.Constraint).To(Equal("^1.22.0"))
+			Expect(resolution.Trail).To(Equal([]string{
+				"The engines.yarn range in " + project.PackageJSON + " requires Yarn ^1.22.0",
+				"The engines.yarn has the highest priority and selects Yarn Classic",
+				"Yarn Classic is resolved with the constraint ^1.22.0 of the engines.yarn",
+			}))
+		})
+
+		context("when the packageManager pins Yarn Berry", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(project.PackageJSON, []byte(`{"packageManager":"yarn@4.14.1","engines":{"node":">=18","yarn":"^1.22.0"}}`), 0600)).To(Succeed())
+			})
+
+			it("selects Yarn Berry, as the packageManager has a higher priority", func() {
+				resolution, err := resolver.Resolve(project, entries, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resolution.DependencyID).To(Equal("berry"))
+				Expect(resolution.Constraint).To(Equal("default"))
+				Expect(resolution.Source()).To(Equal("packageManager"))
+			})
+
+			context("when pins are honored", func() {
+				it("resolves the pinned version of Yarn Berry", func() {
+					resolution, err := resolver.Resolve(project, entries, true)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(resolution.DependencyID).To(Equal("berry"))
+					Expect(resolution.Constraint).To(Equal("4.14.1"))
+				})
+			})
+		})
+
+		context("when the range asks for Yarn Berry and the packageManager pins Yarn Classic", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(project.PackageJSON, []byte(`{"packageManager":"yarn@1.22.19","engines":{"yarn":">=4.0.0"}}`), 0600)).To(Succeed())
+			})
+
+			it("keeps Yarn Classic, as the range does not override the pin", func() {
+				resolution, err := resolver.Resolve(project, entries, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resolution.DependencyID).To(Equal("yarn"))
+				Expect(resolution.Source()).To(Equal("packageManager"))
+			})
+		})
+
+		context("when BP_YARN_DETECT_FLAVOR is true and the project has a .yarnrc.yml", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_DETECT_FLAVOR", "true")).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(workingDir, ".yarnrc.yml"), nil, 0600)).To(Succeed())
+				project.YarnrcYML = filepath.Join(workingDir, ".yarnrc.yml")
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_DETECT_FLAVOR")).To(Succeed())
+			})
+
+			it("takes precedence over the Berry configuration", func() {
+				resolution, err := resolver.Resolve(project, entries, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resolution.DependencyID).To(Equal("yarn"))
+				Expect(resolution.Constraint).To(Equal("^1.22.0"))
+				Expect(resolution.Trail[1]).To(Equal(project.YarnrcYML + " is only read by Yarn Berry"))
+			})
+		})
+	})
+
+	context("when the project has a .yarnrc.yml and a Berry lockfile but BP_YARN_DETECT_FLAVOR is not set", func() {
+		it.Before(func() {
+			project.YarnrcYML = filepath.Join(workingDir, ".yarnrc.yml")
+			Expect(os.WriteFile(project.YarnrcYML, nil, 0600)).To(Succeed())
+
+			project.Lockfile = filepath.Join(workingDir, "yarn.lock")
+			Expect(os.WriteFile(project.Lockfile, []byte("# generated\n\n__metadata:\n  version: 8\n"), 0600)).To(Succeed())
+		})
+
+		it("ignores them", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("yarn"))
+			Expect(resolution.Constraints).To(BeEmpty())
+		})
+	})
+
+	context("when the lockfile was written by Yarn Berry", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_YARN_DETECT_FLAVOR", "true")).To(Succeed())
+			project.Lockfile = filepath.Join(workingDir, "yarn.lock")
+			Expect(os.WriteFile(project.Lockfile, []byte("# generated\n\n__metadata:\n  version: 8\n"), 0600)).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_DETECT_FLAVOR")).To(Succeed())
+		})
+
+		it("selects Yarn Berry without choosing its version", func() {
+			resolution, err := resolver.Resolve(project, entries, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("berry"))
+			Expect(resolution.Constraint).To(Equal("default"))
+			Expect(resolution.Constraints[0].FlavorOnly).To(BeTrue())
+		})
+	})
+
+	context("when the lockfile was written by Yarn Classic", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_YARN_DETECT_FLAVOR", "true")).To(Succeed())
+			project.Lockfile = filepath.Join(workingDir, "yarn.lock")
+			Expect(os.WriteFile(project.Lockfile, []byte("# THIS IS AN AUTOGENERATED FILE.\n# yarn lockfile v1\n"), 0600)).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_YARN_DETECT_FLAVOR")).To(Succeed())
+		})
+
+		it("resolves the default version of Yarn Classic", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("yarn"))
+			Expect(resolution.Constraint).To(Equal("default"))
+			Expect(resolution.Trail).To(ContainElement("Yarn Classic is resolved with its default version in buildpack.toml"))
+		})
+	})
+
+	context("when the packageManager is malformed", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(project.PackageJSON, []byte(`{"packageManager":"yarn@"}`), 0600)).To(Succeed())
+			entries[0].Metadata = map[string]interface{}{"version": "1.22.*"}
+		})
+
+		it("skips the sources that read package.json and warns with a ParseError", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("yarn"))
+			Expect(resolution.Constraint).To(Equal("1.22.*"))
+			Expect(resolution.Warnings).To(HaveLen(1))
+			Expect(resolution.Warnings[0]).To(MatchError(`failed to read package manager declarations: invalid packageManager "yarn@": must be name@version`))
+			Expect(resolution.Trail[0]).To(Equal(`Skipped the sources that read package.json: failed to read package manager declarations: invalid packageManager "yarn@": must be name@version`))
+
+			var manifestError yarn.ManifestError
+			Expect(errors.As(resolution.Warnings[0], &manifestError)).To(BeTrue())
+			Expect(manifestError.Path).To(Equal(project.PackageJSON))
+
+			var parseError packagemanager.ParseError
+			Expect(errors.As(resolution.Warnings[0], &parseError)).To(BeTrue())
+			Expect(parseError.Field).To(Equal("packageManager"))
+		})
+	})
+
+	context("when engines.yarn is not a semver range", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(project.PackageJSON, []byte(`{"engines":{"npm":"please-use-yarn","yarn":"classic"}}`), 0600)).To(Succeed())
+		})
+
+		it("skips the engines.yarn source and warns with a ParseError", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.Constraints).To(BeEmpty())
+			Expect(resolution.Warnings).To(HaveLen(1))
+
+			var parseError packagemanager.ParseError
+			Expect(errors.As(resolution.Warnings[0], &parseError)).To(BeTrue())
+			Expect(parseError).To(Equal(packagemanager.ParseError{Field: "engines.yarn", Value: "classic", Reason: "must be a semver range"}))
+		})
+	})
+
+	context("when package.json is not valid JSON", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(project.PackageJSON, []byte(`{`), 0600)).To(Succeed())
+		})
+
+		it("warns once for all the sources that read it", func() {
+			resolution, err := resolver.Resolve(project, entries, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("yarn"))
+			Expect(resolution.Warnings).To(HaveLen(1))
+			Expect(resolution.Warnings[0]).To(MatchError(ContainSubstring("failed to parse " + project.PackageJSON)))
+		})
+	})
+
+	context("when several sources read package.json", func() {
+		it("reads it once", func() {
+			Expect(os.WriteFile(project.PackageJSON, []byte(`{"packageManager":"yarn@1.22.19"}`), 0600)).To(Succeed())
+
+			resolver = yarn.NewVersionResolver(
+				yarn.PackageManagerVersionSource{},
+				rewritingVersionSource{path: project.PackageJSON, content: `{"devEngines":{"packageManager":{"name":"yarn","version":"4.x"}}}`},
+				yarn.DevEnginesVersionSource{},
+			)
+
+			resolution, err := resolver.Resolve(project, entries, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.Constraints).To(HaveLen(1))
+			Expect(resolution.Constraints[0].Source).To(Equal("packageManager"))
+		})
+	})
+
+	context("when sources are plugged in", func() {
+		it("orders their constraints by priority, keeping the order of equal priorities", func() {
+			resolver = yarn.NewVersionResolver(
+				stubVersionSource{constraint: yarn.VersionConstraint{Source: "low", Constraint: "4.*", Priority: 1, Description: "low"}, ok: true},
+				stubVersionSource{constraint: yarn.VersionConstraint{Source: "skipped", Constraint: "3.*", Priority: 100}},
+				stubVersionSource{constraint: yarn.VersionConstraint{Source: "first", Constraint: "1.22.19", Priority: 5, Description: "first"}, ok: true},
+				stubVersionSource{constraint: yarn.VersionConstraint{Source: "second", Constraint: "1.22.22", Priority: 5, Description: "second"}, ok: true},
+			)
+
+			resolution, err := resolver.Resolve(project, entries, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolution.DependencyID).To(Equal("yarn"))
+			Expect(resolution.Constraint).To(Equal("1.22.19"))
+			Expect(resolution.Trail[:3]).To(Equal([]string{"first", "second", "low"}))
+		})
+	})
+
+	context("failure cases", func() {
+		context("when a source fails", func() {
+			it("returns the error", func() {
+				resolver = yarn.NewVersionResolver(stubVersionSource{err: errors.New("failed to read source")})
+
+				_, err := resolver.Resolve(project, entries, false)
+				Expect(err).To(MatchError("failed to read source"))
+			})
+		})
+
+		context("when BP_YARN_DETECT_FLAVOR is invalid", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_DETECT_FLAVOR", "maybe")).To(Succeed())
+				project.YarnrcYML = filepath.Join(workingDir, ".yarnrc.yml")
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_DETECT_FLAVOR")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := resolver.Resolve(project, entries, false)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_YARN_DETECT_FLAVOR value maybe")))
+			})
+		})
+
+		context("when the lockfile cannot be read", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_YARN_DETECT_FLAVOR", "true")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_YARN_DETECT_FLAVOR")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				project.Lockfile = filepath.Join(workingDir, "missing.lock")
+
+				_, err := resolver.Resolve(project, entries, false)
+				Expect(err).To(MatchError(ContainSubstring("failed to read lockfile")))
+			})
+		})
+	})
+}